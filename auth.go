@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	// "crypto/rsa"
 	// "encoding/gob"
 	// "github.com/gorilla/mux"
@@ -21,6 +22,8 @@ import (
 type ImpfUser struct {
 	Password string `db:"password"`
 	Username string `db:"username"`
+	Admin    bool   `db:"admin"`     // May lift suppressions
+	CenterID int    `db:"center_id"` // Center of calls and persons the user adds
 }
 
 type contextKey string
@@ -34,11 +37,9 @@ func authenticateUser(user, pass string) bool {
 
 	log.Debugf("Trying to authenticate: user[%s] pass[%s]\n", user, pass)
 
-	// Create an instance of `Credentials` to store the credentials from DB
-	storedCreds := ImpfUser{}
-
 	// Get the existing entry present in the database for the given username
-	if err := bridge.db.Get(&storedCreds, "SELECT * FROM users WHERE username=$1", user); err != nil {
+	storedCreds, err := bridge.store.GetUser(user)
+	if err != nil {
 
 		if err == sql.ErrNoRows {
 			log.Debug(err)
@@ -108,7 +109,7 @@ func authHandler(w http.ResponseWriter, r *http.Request) {
 
 // Serve login page
 func loginHandler(w http.ResponseWriter, r *http.Request) {
-	if err := templates.ExecuteTemplate(w, "login.html", TmplData{}); err != nil {
+	if err := templates.ExecuteTemplate(w, "login.html", TmplData{Lang: uiLanguage(r)}); err != nil {
 		log.Error(err)
 	}
 }
@@ -147,6 +148,45 @@ func middlewareAuth(next http.Handler) http.Handler {
 	})
 }
 
+// middlewareAdmin restricts a handler to admins. It has to be used after
+// middlewareAuth, which sets the current user.
+func middlewareAdmin(next http.Handler) http.Handler {
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		username := contextString(contextKeyCurrentUser, r)
+		user, err := bridge.store.GetUser(username)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Error(err)
+			http.Error(w, "Failed to load user", http.StatusInternalServerError)
+			return
+		}
+
+		if !user.Admin {
+			log.Warnf("User [%s] is not allowed to access %s", username, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// currentCenter returns the ID of the center of the current user. Calls and
+// persons of users without center, or if the user can't be loaded, get center
+// 0 and use the message templates for all centers.
+func currentCenter(r *http.Request) int {
+
+	username := contextString(contextKeyCurrentUser, r)
+	user, err := bridge.store.GetUser(username)
+	if err != nil {
+		log.Warnf("Failed to load center of user [%s]: %v", username, err)
+		return 0
+	}
+
+	return user.CenterID
+}
+
 func forbiddenHandler(w http.ResponseWriter, r *http.Request) {
 	log.Warn("forbidden reached")
 
@@ -164,7 +204,7 @@ func forbiddenHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	tData := TmplData{AppMessages: []string{"Login Fehlgeschlagen"}}
+	tData := TmplData{Lang: uiLanguage(r), AppMessages: []string{"Login Fehlgeschlagen"}}
 	if err := templates.ExecuteTemplate(w, "login.html", tData); err != nil {
 		log.Error(err)
 	}
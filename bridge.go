@@ -1,160 +1,173 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
-	"os"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	log "github.com/sirupsen/logrus"
-
-	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 // Bridge is the main struct of the application. It abstracts the connection to
 // the database and twilio providing methods to act on both
 type Bridge struct {
 	// TODO handle duplicates and validate data
-	db     *sqlx.DB
-	sender *TwillioSender
-}
-
-var schemaPersons = `
-CREATE TABLE IF NOT EXISTS persons (
-	phone TEXT PRIMARY KEY,
-	center_id INTEGER NOT NULL,
-	group_num INTEGER NOT NULL,
-	status INTEGER NOT NULL
-);
-`
-var schemaCalls = `
-CREATE TABLE IF NOT EXISTS calls (
-	id INTEGER PRIMARY KEY AUTOINCREMENT,
-	title TEXT NOT NULL,
-	center_id INTEGER NOT NULL,
-	capacity INTEGER NOT NULL,
-	time_start DATETIME NOT NULL,
-	time_end DATETIME NOT NULL,
-	young_only INTEGER NOT NULL,
-	loc_name TEXT NOT NULL,
-	loc_street TEXT NOT NULL,
-	loc_housenr TEXT NOT NULL,
-	loc_plz TEXT NOT NULL,
-	loc_city TEXT NOT NULL,
-	loc_opt TEXT NOT NULL
-);
-`
-
-var schemaUsers = `
-CREATE TABLE IF NOT EXISTS users (
-  username text primary key,
-  password text
-);
-`
-
-var schemaNotifications = `
-CREATE TABLE IF NOT EXISTS invitations (
-  id INTEGER PRIMARY KEY AUTOINCREMENT,
-  phone TEXT NOT NULL,
-  call_id INTEGER NOT NULL,
-  status TEXT NOT NULL,
-  time DATETIME NOT NULL
-);
-`
+	store     Store
+	sender    MessageSender
+	scheduler *Scheduler
+	delivery  *Scheduler // Delivers the outbox
+	importer  *Scheduler // Runs imports of persons
+	messages  *MessageTemplates
+}
 
 // NewBridge creates a new instance of the bridge using predifined parameters
 // from env vars, global vars and/or defaults
 func NewBridge() *Bridge {
 
 	log.Info("Creating new bridge")
-	log.Info("Using database:", dbPath)
+	log.Infof("Using database: %s %s", dbDriver, dbPath)
 
-	// Open connection to database file. Will be created if it does not already
-	// exist. Exit application on errors, we can't continue without database
-	db, err := sqlx.Connect("sqlite3", dbPath)
+	// Open connection to the database and bring the schema up to date. Exit
+	// application on errors, we can't continue without database
+	store, err := NewStore(dbDriver, dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	// Only required because of a bug with sqlx and sqlite.
-	// TODO remove when migrating to postgresql if performance is too bad
-	db.SetMaxOpenConns(1)
+	// Exit application if the suppression list can't be used, deleted
+	// persons could be added again
+	suppressionSalt, err = loadSuppressionSalt(store, suppressionSalt)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Exec the schema or fail; multi-statement Exec behavior varies between
-	// database drivers
+	// Exit application if the SMS provider is not configured correctly
+	sender, err := NewMessageSender(smsProvider)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Exit application if a message template is invalid, messages would
+	// fail to render later
+	messages, err := NewMessageTemplates(messageDir, store)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	log.Debug("Verifying DB schema for calls")
-	db.MustExec(schemaCalls)
+	bridge := Bridge{store: store, sender: sender, messages: messages}
 
-	log.Debug("Verifying DB schema for persons")
-	db.MustExec(schemaPersons)
+	bridge.scheduler = NewScheduler(schedulerInterval, bridge.runScheduled, bridge.nextWakeup)
+	bridge.scheduler.Start(context.Background())
 
-	log.Debug("Verifying DB schema for users")
-	db.MustExec(schemaUsers)
+	// Messages are delivered from the outbox in the background. Messages left
+	// from the last run are sent by the initial run.
+	bridge.delivery = NewScheduler(outboxPollInterval, func(ctx context.Context) { bridge.DeliverOutbox() }, bridge.nextDelivery)
+	bridge.delivery.Start(context.Background())
 
-	log.Debug("Verifying DB schema for notifications")
-	db.MustExec(schemaNotifications)
+	// Imports are run in the background. Imports interrupted by the last
+	// shutdown are continued by the initial run.
+	bridge.importer = NewScheduler(importPollInterval, bridge.RunImports, nil)
+	bridge.importer.Start(context.Background())
 
-	sender := NewTwillioSender(
-		os.Getenv("IMPF_TWILIO_API_ENDPOINT"),
-		os.Getenv("IMPF_TWILIO_API_USER"),
-		os.Getenv("IMPF_TWILIO_API_PASS"),
-		os.Getenv("IMPF_TWILIO_API_FROM"),
-	)
+	return &bridge
+}
 
-	bridge := Bridge{db: db, sender: sender}
+// Close shuts the bridge down. It stops the schedulers, waiting for runs in
+// progress, e.g. for the batch of an import in progress, delivers the
+// messages still waiting in the outbox and closes the database connection.
+func (b *Bridge) Close() error {
+	b.scheduler.Stop()
+	b.delivery.Stop()
+	b.importer.Stop()
+	b.DeliverOutbox()
+	return b.store.Close()
+}
 
-	// 15-minute timer/ticker tor periodically do stuff
-	ticker := time.NewTicker(15 * time.Minute)
-	quit := make(chan struct{})
+// runScheduled is run by the scheduler. It invites persons for calls with
+// free seats and deletes old calls. Remaining steps are skipped when ctx is
+// cancelled.
+func (b *Bridge) runScheduled(ctx context.Context) {
+	steps := []func(){
+		b.SendNotifications,
+		b.DeleteOldCalls,
+	}
 
-	go func() {
-		// Initial run when the ticker starts so we don't have to wait until
-		// the ticker on first start
-		bridge.SendNotifications()
-		bridge.DeleteOldCalls()
-		for {
-			select {
-			case <-ticker.C:
-				bridge.SendNotifications()
-				bridge.DeleteOldCalls()
-			case <-quit:
-				ticker.Stop()
-				return
-			}
+	for _, step := range steps {
+		if ctx.Err() != nil {
+			log.Info("Scheduler run cancelled")
+			return
 		}
-	}()
+		step()
+	}
+}
 
-	return &bridge
+// nextWakeup tells the scheduler to run when the next invitation expires, so
+// its seat is offered to the next person right away, or when the wave spacing
+// of a call with free seats allows its next wave
+func (b *Bridge) nextWakeup(now time.Time) time.Time {
+
+	var wakeup time.Time
+
+	expiry, err := b.store.NextInvitationExpiry()
+	if err != nil {
+		log.Error("Error getting next invitation expiry: ", err)
+	} else if expiry.Valid {
+		wakeup = expiry.Time
+	}
+
+	calls, err := b.store.GetActiveCalls(now)
+	if err != nil {
+		log.Error("Error getting active calls: ", err)
+		return wakeup
+	}
+
+	for _, call := range calls {
+		if next := call.NextWaveAt(); next.After(now) && (wakeup.IsZero() || next.Before(wakeup)) {
+			wakeup = next
+		}
+	}
+
+	return wakeup
 }
 
 // DeleteOldCalls finds calls for which the end_time has passed and deletes
 // them from the db
 func (b Bridge) DeleteOldCalls() {
 
-	m := map[string]interface{}{"now": time.Now()}
-	result, err := b.db.NamedExec("DELETE FROM calls WHERE time_end < :now", m)
-
+	numrows, err := b.store.DeleteCallsEndedBefore(time.Now())
 	if err != nil {
 		log.Error("Error deleting calls: ", err)
 		return
 	}
 
-	numrows, err := result.RowsAffected()
-	if err != nil {
-		log.Error(err)
-	}
-
 	log.Info("Old calls deleted: ", numrows)
 }
 
-// SendNotifications checks for all active calls, if any notifications have to
-// be send. If so it finds the next persons to send notifications to and
-// triggers the notificaion mechanism
+// SendNotifications expires invitations which have not been replied to in
+// time and then checks for all active calls, if any notifications have to be
+// send. How many persons are invited follows the strategy of the call, see
+// Call.InvitationsToSend. The persons to notify are found and the notificaion
+// mechanism is triggered
 func (b Bridge) SendNotifications() {
 	log.Debug("Timer reached, sending notifications to calls")
 
+	// Expire first, so the seats of expired invitations are given to the
+	// next persons right away
+	expired, err := b.store.ExpireInvitations(time.Now())
+	if err != nil {
+		log.Error(err)
+	} else {
+		log.Info("Invitations expired: ", len(expired))
+	}
+
 	// Get all calls where end-time has not been reached yet
 	calls, err := b.GetActiveCalls()
 
@@ -165,18 +178,16 @@ func (b Bridge) SendNotifications() {
 	// For each call
 	for _, v := range calls {
 
-		// Check how many people have accepted
-		acceptedPersons, err := b.GetAcceptedPersons(v.ID)
-
+		// Check how many people still have to reply
+		numOpen, err := b.store.CountOpenInvitations(v.ID, time.Now())
 		if err != nil {
 			log.Error(err)
-			return
+			continue
 		}
 
-		// if less then capacity, send notifications
-		if v.Capacity > len(acceptedPersons) {
-			err := b.NotifyCall(v.ID, v.Capacity-len(acceptedPersons))
-			if err != nil {
+		// if seats are not covered by open invitations, send notifications
+		if num := v.InvitationsToSend(numOpen, time.Now()); num > 0 {
+			if err := b.NotifyCall(v.ID, num); err != nil {
 				log.Error(err)
 			}
 		}
@@ -185,130 +196,808 @@ func (b Bridge) SendNotifications() {
 
 // NotifyCall is given a call ID and a maximum number of persons to notify. It
 // sends notificaions for that call to the amount of persons specified or less
-// if there are no persons to notify left
+// if there are no persons to notify left. All invitations sent by one call of
+// NotifyCall form a new wave.
+//
+// The invitations and their messages are saved in one transaction before any
+// message is sent. Delivery happens from the outbox afterwards, so persons
+// are never invited without the invitation being recorded and messages of
+// saved invitations are sent even if the application is stopped in between.
 func (b *Bridge) NotifyCall(id, numPersons int) error {
-	// TODO
-	var err error
-	var persons []Person
-	var call CallStatus
 
-	if persons, err = b.GetNextPersonsForCall(numPersons, id); err != nil {
+	persons, err := b.GetNextPersonsForCall(numPersons, id)
+	if err != nil {
 		return err
 	}
 
-	if call, err = b.GetCallStatus(strconv.Itoa(id)); err != nil {
+	if len(persons) == 0 {
+		log.Infof("No persons left to notify for call %d", id)
+		return nil
+	}
+
+	call, err := b.store.GetCall(id)
+	if err != nil {
 		return err
 	}
 
+	wave, err := b.store.NextWave(id)
 	if err != nil {
 		return err
 	}
 
+	// Each person gets the invitation in their language
+	bodies := map[string]string{}
+	messages := make([]OutboxMessage, len(persons))
 	for k := range persons {
-		if err := b.sender.SendMessageNotify(
-			persons[k].Phone,
-			call.Call.TimeStart.Format("14:12"),
-			call.Call.TimeEnd.Format("14:12"),
-			call.Call.LocName,
-			call.Call.LocStreet,
-			call.Call.LocHouseNr,
-			call.Call.LocPLZ,
-			call.Call.LocCity,
-			call.Call.LocOpt,
-		); err != nil {
+		lang := persons[k].MessageLanguage()
+		body, ok := bodies[lang]
+		if !ok {
+			body, err = b.renderCallMessage(call, lang, messageNotify, newMessageData(call, time.Now(), lang))
+			if err != nil {
+				return err
+			}
+			bodies[lang] = body
+		}
+		messages[k] = OutboxMessage{Phone: persons[k].Phone, Body: body}
+	}
+
+	now := time.Now()
+	if err := b.store.AddInvitations(id, wave, messages, now, call.InvitationDeadline(now)); err != nil {
+		return err
+	}
+
+	log.Infof("Queued wave %d of invitations for call %d to %d persons", wave, id, len(messages))
+
+	b.delivery.Trigger(triggerMessageQueued)
+	return nil
+}
+
+// outboxBatchSize is the maximum number of messages DeliverOutbox fetches at
+// once
+const outboxBatchSize = 100
+
+// QueueMessage writes a message to the outbox and triggers its delivery
+func (b *Bridge) QueueMessage(msg OutboxMessage) error {
+	if err := b.store.QueueMessage(msg, time.Now()); err != nil {
+		return err
+	}
+
+	b.delivery.Trigger(triggerMessageQueued)
+	return nil
+}
+
+// renderMessage returns the text of a message for a center in a language.
+// Characters outside of GSM-7 are replaced if transliterate or
+// smsTransliterate is set.
+func (b *Bridge) renderMessage(centerID int, lang, name string, data MessageData, transliterate bool) (string, error) {
+
+	body, err := b.messages.Render(centerID, lang, name, data)
+	if err != nil {
+		return "", err
+	}
+
+	if transliterate || smsTransliterate {
+		body = transliterateGSM(body)
+	}
+	return body, nil
+}
+
+// renderCallMessage returns the text of a message about a call in a language
+func (b *Bridge) renderCallMessage(call Call, lang, name string, data MessageData) (string, error) {
+	return b.renderMessage(call.CenterID, lang, name, data, call.Transliterate)
+}
+
+// InvitationPreview returns the invitation persons without a preferred
+// language would get for a call that has not been saved yet, with its
+// encoding and number of segments
+func (b *Bridge) InvitationPreview(call Call) (string, SMSInfo, error) {
+
+	body, err := b.renderCallMessage(call, defaultLanguage, messageNotify, newMessageData(call, time.Now(), defaultLanguage))
+	if err != nil {
+		return "", SMSInfo{}, err
+	}
+
+	return body, analyzeSMS(body), nil
+}
+
+// QueueTemplateMessage queues a message without placeholders about a call,
+// e.g. the onboarding message, using the templates of the center of the person
+// in their language
+func (b *Bridge) QueueTemplateMessage(phoneNumber, name string) error {
+
+	person := b.recipient(phoneNumber)
+	body, err := b.renderMessage(person.CenterID, person.MessageLanguage(), name, MessageData{}, false)
+	if err != nil {
+		return err
+	}
+
+	return b.QueueMessage(OutboxMessage{Phone: phoneNumber, Body: body})
+}
+
+// recipient returns the person a message is sent to. Unknown numbers get the
+// messages for all centers in the default language.
+func (b *Bridge) recipient(phoneNumber string) Person {
+	person, err := b.store.GetPerson(phoneNumber)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			log.Warn(err)
+		}
+		return Person{Phone: phoneNumber}
+	}
+	return person
+}
+
+// DeliverOutbox sends all pending messages of the outbox which are due, using
+// outboxWorkers concurrent workers. Messages that fail to send are retried
+// later with increasing delays, see retryDelay. After outboxMaxAttempts failed
+// attempts a message is marked as failed and not retried anymore.
+func (b *Bridge) DeliverOutbox() {
+
+	for {
+		messages, err := b.store.PendingMessages(outboxBatchSize, time.Now())
+		if err != nil {
 			log.Error(err)
+			return
+		}
+
+		jobs := make(chan OutboxMessage)
+		var wg sync.WaitGroup
+		var numSent, numErrors int32
+
+		for i := 0; i < outboxWorkers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for msg := range jobs {
+					sent, err := b.deliverMessage(msg)
+					if err != nil {
+						log.Error(err)
+						atomic.AddInt32(&numErrors, 1)
+					}
+					if sent {
+						atomic.AddInt32(&numSent, 1)
+					}
+				}
+			}()
+		}
+
+		for _, msg := range messages {
+			jobs <- msg
+		}
+		close(jobs)
+		wg.Wait()
+
+		log.Debugf("Delivered %d of %d pending messages", numSent, len(messages))
+
+		// Failed messages are not due again right away, so there are more
+		// messages to fetch only if the batch was full. Stop on database
+		// errors, the messages would be fetched again.
+		if len(messages) < outboxBatchSize || numErrors > 0 {
+			return
+		}
+	}
+}
+
+// deliverMessage sends a message of the outbox and records the result.
+// Returns if the message was sent and an error if the result could not be
+// saved.
+func (b *Bridge) deliverMessage(msg OutboxMessage) (bool, error) {
+
+	providerID, sendErr := b.sender.SendMessage(msg.Phone, msg.Body)
+	if sendErr == nil {
+		return true, b.store.MarkMessageSent(msg.ID, providerID, time.Now())
+	}
+
+	attempts := msg.Attempts + 1
+	if attempts >= outboxMaxAttempts {
+		log.Errorf("Giving up on message %d after %d attempts: %v", msg.ID, attempts, sendErr)
+		return false, b.store.MarkMessageFailed(msg.ID, sendErr.Error())
+	}
+
+	next := time.Now().Add(retryDelay(attempts))
+	log.Warnf("Failed to deliver message %d (attempt %d), retrying at %s: %v", msg.ID, attempts, next, sendErr)
+	return false, b.store.MarkMessageRetry(msg.ID, sendErr.Error(), next)
+}
+
+// nextDelivery tells the delivery scheduler to run when the next retry of a
+// message is due
+func (b *Bridge) nextDelivery(now time.Time) time.Time {
+	next, err := b.store.NextMessageAttempt()
+	if err != nil {
+		log.Error("Error getting next message attempt: ", err)
+		return time.Time{}
+	}
+
+	if !next.Valid {
+		return time.Time{}
+	}
+	return next.Time
+}
+
+// UpdateDeliveryStatus records a delivery receipt of the SMS provider for the
+// message with the given provider ID. If the message was an invitation which
+// could not be delivered, the invitation expires and the scheduler is
+// triggered to invite the next person. Returns ErrMessageNotFound for unknown
+// messages.
+func (b *Bridge) UpdateDeliveryStatus(providerID, status, deliveryError string) error {
+
+	log.Debugf("Delivery status of message %s: %s %s", providerID, status, deliveryError)
+
+	msg, err := b.store.UpdateDeliveryStatus(providerID, status, deliveryError, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if Undeliverable(msg.DeliveryStatus) {
+		log.Warnf("Message %d to %s could not be delivered: %s %s", msg.ID, msg.Phone, msg.DeliveryStatus, msg.DeliveryError)
+		if msg.InvitationID.Valid {
+			b.scheduler.Trigger(triggerUndelivered)
 		}
 	}
 
 	return nil
 }
 
+// GetUndeliverablePersons returns the persons whose last messages could not be
+// delivered repeatedly. They are candidates for deactivation.
+func (b *Bridge) GetUndeliverablePersons() ([]Person, error) {
+	return b.store.GetUndeliverablePersons(undeliveredThreshold)
+}
+
+// GetMessages returns the latest messages of the outbox for the status page
+func (b *Bridge) GetMessages(limit int) ([]OutboxMessage, error) {
+	return b.store.GetMessages(limit)
+}
+
+// RequeueMessage queues a failed message for delivery again
+func (b *Bridge) RequeueMessage(id int) error {
+	log.Infof("Requeueing message %d", id)
+	if err := b.store.RequeueMessage(id); err != nil {
+		return err
+	}
+
+	b.delivery.Trigger(triggerMessageQueued)
+	return nil
+}
+
 // AddCall adds a call to the database
 func (b *Bridge) AddCall(call Call) error {
-
 	log.Debugf("Adding call %+v\n", call)
+	if err := b.store.AddCall(call); err != nil {
+		return err
+	}
+
+	// Send the first invitations for the call right away
+	b.scheduler.Trigger(triggerCallCreated)
+	return nil
+}
 
-	tx := b.db.MustBegin()
-	res, err := tx.NamedExec(
-		`INSERT INTO calls (
-			title,
-			center_id,
-			capacity,
-			time_start,
-			time_end,
-			young_only,
-			loc_name,
-			loc_street,
-			loc_housenr,
-			loc_plz,
-			loc_city,
-			loc_opt
-		) VALUES (
-			:title,
-			:center_id,
-			:capacity,
-			:time_start,
-			:time_end,
-			:young_only,
-			:loc_name,
-			:loc_street,
-			:loc_housenr,
-			:loc_plz,
-			:loc_city,
-			:loc_opt
-		)`, &call)
-
-	rows, _ := res.RowsAffected()
-	log.Debugf("%v rows affected\n", rows)
+// AddPerson adds a person to the databse. Returns ErrPersonSuppressed if the
+// person asked to be deleted.
+func (b *Bridge) AddPerson(person Person) error {
+	log.Debugf("Adding person %+v\n", person)
 
+	suppressed, err := b.suppressedPhones([]string{person.Phone})
 	if err != nil {
 		return err
 	}
+	if suppressed[person.Phone] {
+		return ErrPersonSuppressed
+	}
 
-	return tx.Commit()
+	return b.store.AddPerson(person)
 }
 
-// AddPerson adds a person to the databse
-func (b *Bridge) AddPerson(person Person) error {
+// AddPersons adds multiple persons at once. All persons are added in a single
+// transaction, if one of them can't be added none are. Existing persons are
+// handled according to mode.
+func (b *Bridge) AddPersons(persons []Person, mode ImportMode) (ImportSummary, error) {
+	log.Debugf("Adding persons in mode %s: %+v\n ", mode, persons)
 
-	log.Debugf("Adding person %+v\n", person)
+	persons, suppressed, err := b.withoutSuppressed(persons)
+	if err != nil {
+		return ImportSummary{}, err
+	}
+
+	summary, err := b.store.AddPersons(persons, mode)
+	summary.Suppressed = suppressed
+	return summary, err
+}
+
+// withoutSuppressed returns persons without those who asked to be deleted and
+// the number of persons left out
+func (b *Bridge) withoutSuppressed(persons []Person) ([]Person, int, error) {
+
+	phones := make([]string, len(persons))
+	for k, person := range persons {
+		phones[k] = person.Phone
+	}
+
+	suppressed, err := b.suppressedPhones(phones)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(suppressed) == 0 {
+		return persons, 0, nil
+	}
+
+	allowed := []Person{}
+	for _, person := range persons {
+		if suppressed[person.Phone] {
+			log.Infof("Not adding suppressed person %s", person.Phone)
+			continue
+		}
+		allowed = append(allowed, person)
+	}
+	return allowed, len(persons) - len(allowed), nil
+}
+
+// suppressedPhones returns which of phones are in the suppression list
+func (b *Bridge) suppressedPhones(phones []string) (map[string]bool, error) {
+
+	suppressed := map[string]bool{}
+
+	for start := 0; start < len(phones); start += importBatchSize {
+
+		end := start + importBatchSize
+		if end > len(phones) {
+			end = len(phones)
+		}
+
+		// Phones by hash
+		hashes := map[string]string{}
+		list := []string{}
+		for _, phone := range phones[start:end] {
+			hash := suppressionHash(phone)
+			hashes[hash] = phone
+			list = append(list, hash)
+		}
 
-	var res sql.Result
-	var err error
+		found, err := b.store.SuppressedHashes(list)
+		if err != nil {
+			return nil, err
+		}
+		for _, hash := range found {
+			suppressed[hashes[hash]] = true
+		}
+	}
 
-	tx := b.db.MustBegin()
-	if res, err = tx.NamedExec(
-		"INSERT INTO persons (center_id, group_num, phone, status) VALUES "+
-			"(:center_id, :group_num, :phone, :status)", &person); err != nil {
+	return suppressed, nil
+}
+
+// GetSuppression returns the suppression of a phone number, also if it has
+// been lifted. Returns sql.ErrNoRows if the number has never been suppressed.
+func (b *Bridge) GetSuppression(phoneNumber string) (Suppression, error) {
+	return b.store.GetSuppression(suppressionHash(phoneNumber))
+}
+
+// LiftSuppression allows a person who asked to be deleted to be added again.
+// user is recorded as the one who lifted it. Returns sql.ErrNoRows if the
+// number is not suppressed.
+func (b *Bridge) LiftSuppression(phoneNumber, user string) error {
+
+	if err := b.store.LiftSuppression(suppressionHash(phoneNumber), user, time.Now()); err != nil {
 		return err
 	}
 
-	if numrows, err := res.RowsAffected(); err != nil {
+	log.Infof("Suppression of %s lifted by %s", phoneNumber, user)
+	return nil
+}
+
+// CreateImport saves an uploaded list of persons as new import of the center
+// of user and triggers the worker, which reads it in the background, see
+// readImport. Returns the ID of the new import.
+func (b *Bridge) CreateImport(filename, user string, centerID int, data []byte) (int, error) {
+
+	imp := Import{
+		Filename:  filename,
+		CreatedAt: time.Now(),
+		CreatedBy: user,
+		CenterID:  centerID,
+		Sheets:    "[]",
+		Status:    ImportUploaded,
+
+		ImportColumns: defaultImportColumns,
+	}
+
+	log.Debugf("Saving import of %s with %d bytes\n", filename, len(data))
+	id, err := b.store.AddImport(imp, data)
+	if err != nil {
+		return 0, err
+	}
+
+	b.importer.Trigger(triggerImportQueued)
+	return id, nil
+}
+
+// readImport reads the uploaded file of an import and validates its rows, so
+// they can be previewed before they are imported. The rows of all sheets are
+// saved, the first sheet with rows is selected. The mapping of the columns is
+// detected from its first row. Files which can't be read make the import
+// fail.
+func (b *Bridge) readImport(imp Import) error {
+
+	data, err := b.store.GetImportFile(imp.ID)
+	if err != nil {
 		return err
-	} else {
-		log.Debugf("Persons will be added: %v\n", numrows)
 	}
 
-	return tx.Commit()
+	sheets, err := readSheets(bytes.NewReader(data))
+	if err != nil {
+		log.Warnf("Reading file of import %d failed: %v", imp.ID, err)
+		imp.Status, imp.Error = ImportFailed, "Datei konnte nicht gelesen werden: "+err.Error()
+		if errors.Is(err, ErrUnsupportedFormat) {
+			imp.Error = "Dateiformat wird nicht unterstützt"
+		}
+		return b.store.SaveImportRows(imp, nil)
+	}
+
+	// The rows of each sheet follow each other, start and end are those of
+	// the selected sheet
+	names := []string{}
+	rows := []ImportRow{}
+	start, end := 0, 0
+
+	for i, sheet := range sheets {
+		names = append(names, sheet.Name)
+		first := len(rows)
+		for k, record := range sheet.Records {
+			if emptyRecord(record) {
+				continue
+			}
+			row, err := newImportRow(i, k+1, record)
+			if err != nil {
+				return err
+			}
+			rows = append(rows, row)
+		}
+		if start == end && len(rows) > first {
+			imp.Sheet, start, end = i, first, len(rows)
+		}
+	}
+
+	encoded, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	imp.Sheets = string(encoded)
+
+	// Only the rows of the selected sheet are validated, the others when
+	// their sheet is selected
+	imp.detectColumns(rows[start:end])
+	if err := b.validateImport(imp, rows[start:end]); err != nil {
+		return err
+	}
+
+	log.Debugf("Saving %d rows of import %d, sheet %d and columns %+v\n", len(rows), imp.ID, imp.Sheet, imp.ImportColumns)
+	imp.Status = ImportReady
+	return b.store.SaveImportRows(imp, rows)
 }
 
-// AddPersons adds multiple persons at once. We don't just reuse the
-// AddPerson() function here, to optimize performance. Named transactions are
-// created for each person, but the commit is only done once
-func (b *Bridge) AddPersons(persons []Person) error {
+// validateImport validates the rows of an import, see validateImport. Rows of
+// persons who asked to be deleted are invalid, so they are listed in the
+// report.
+func (b *Bridge) validateImport(imp Import, rows []ImportRow) error {
 
-	log.Debugf("Adding persons: %+v\n ", persons)
+	validateImport(imp, rows)
 
-	tx := b.db.MustBegin()
-	for k := range persons {
-		if _, err := tx.NamedExec(
-			"INSERT INTO persons (center_id, group_num, phone, status) VALUES "+
-				"(:center_id, :group_num, :phone, :status)", &persons[k]); err != nil {
+	phones := []string{}
+	for _, row := range rows {
+		if row.Error == "" && !imp.IsHeader(row) {
+			phones = append(phones, row.Phone)
+		}
+	}
+
+	suppressed, err := b.suppressedPhones(phones)
+	if err != nil {
+		return err
+	}
+
+	for k := range rows {
+		if rows[k].Error == "" && suppressed[rows[k].Phone] {
+			rows[k].Phone, rows[k].Group, rows[k].Language = "", 0, ""
+			rows[k].Error = "Rufnummer gesperrt, Löschung verlangt"
+		}
+	}
+	return nil
+}
+
+// SelectImportSheet changes the sheet of an import which is imported. The
+// mapping of the columns is detected again from its first row. Returns
+// ErrImportDone if the import is not ready, e.g. because it has been
+// confirmed.
+func (b *Bridge) SelectImportSheet(id, sheet int) error {
+
+	imp, err := b.store.GetImport(id)
+	if err != nil {
+		return err
+	}
+	if imp.Status != ImportReady {
+		return ErrImportDone
+	}
+	if sheet < 0 || sheet >= len(imp.SheetNames()) {
+		return fmt.Errorf("invalid sheet %d of import %d", sheet, id)
+	}
+
+	imp.Sheet = sheet
+	rows, err := b.store.GetImportRows(id, sheet)
+	if err != nil {
+		return err
+	}
+
+	imp.detectColumns(rows)
+	if err := b.validateImport(imp, rows); err != nil {
+		return err
+	}
+
+	return b.store.UpdateImport(imp, rows)
+}
+
+// MapImportColumns changes the mapping of the columns of an import and
+// validates its rows again. Returns ErrImportDone if the import is not
+// ready, e.g. because it has been confirmed.
+func (b *Bridge) MapImportColumns(id int, columns ImportColumns, header bool) error {
+
+	imp, rows, err := b.GetImport(id)
+	if err != nil {
+		return err
+	}
+	if imp.Status != ImportReady {
+		return ErrImportDone
+	}
+
+	imp.ImportColumns, imp.Header = columns, header
+	if err := b.validateImport(imp, rows); err != nil {
+		return err
+	}
+
+	return b.store.UpdateImport(imp, rows)
+}
+
+// ConfirmImport queues an import for the worker, which adds the persons of
+// its valid rows in the background, see runImport. Rows with errors are
+// skipped. Existing persons are handled according to mode. Returns
+// ErrImportDone if the import has been confirmed before.
+func (b *Bridge) ConfirmImport(id int, mode ImportMode) error {
+
+	log.Debugf("Queuing import %d in mode %s\n", id, mode)
+	if err := b.store.ConfirmImport(id, mode); err != nil {
+		return err
+	}
+
+	b.importer.Trigger(triggerImportQueued)
+	return nil
+}
+
+// CancelImport stops an import the worker has not finished yet. The worker
+// stops after the batch of persons in progress, persons added before are
+// kept. Returns ErrImportDone if the import is not active anymore.
+func (b *Bridge) CancelImport(id int) error {
+	return b.store.CancelImport(id)
+}
+
+// GetImports returns the last limit imports, newest first
+func (b *Bridge) GetImports(limit int) ([]Import, error) {
+	return b.store.GetImports(limit)
+}
+
+// importBatchSize is the number of persons imported in one transaction. The
+// progress is saved and cancellation checked after each batch.
+const importBatchSize = 500
+
+// RunImports is run by the import worker. It processes imports until none is
+// left or ctx is cancelled. Uploaded files are read, confirmed imports are
+// run. Interrupted imports are continued.
+func (b *Bridge) RunImports(ctx context.Context) {
+
+	for ctx.Err() == nil {
+
+		imp, err := b.store.NextImport()
+		if errors.Is(err, sql.ErrNoRows) {
+			return
+		}
+		if err != nil {
+			log.Error("Error getting next import: ", err)
+			return
+		}
+
+		if imp.Status == ImportUploaded {
+			err = b.readImport(imp)
+		} else {
+			err = b.runImport(ctx, imp)
+		}
+
+		// The import is tried again by the next run
+		if err != nil && !errors.Is(err, ErrImportCancelled) {
+			log.Errorf("Error processing import %d: %v", imp.ID, err)
+			return
+		}
+	}
+}
+
+// runImport adds the persons of the valid rows of a confirmed import in
+// batches and queues the onboarding message for each new person. An import
+// which has been interrupted continues after the last batch saved. In mode
+// ImportFail the import fails before adding anybody if one of the persons
+// exists already.
+func (b *Bridge) runImport(ctx context.Context, imp Import) error {
+
+	rows, err := b.store.GetImportRows(imp.ID, imp.Sheet)
+	if err != nil {
+		return err
+	}
+
+	persons := []Person{}
+	for _, row := range rows {
+		if row.Error == "" && !imp.IsHeader(row) {
+			persons = append(persons, row.Person(imp.CenterID))
+		}
+	}
+
+	if imp.Status == ImportQueued {
+		log.Infof("Starting import %d of %d persons in mode %s", imp.ID, len(persons), imp.Mode)
+		if err := b.store.StartImport(imp.ID, len(persons)); err != nil {
+			return err
+		}
+	}
+
+	if imp.Mode == ImportFail && imp.Processed == 0 {
+		if phone, err := b.existingPerson(persons); err != nil {
+			return err
+		} else if phone != "" {
+			return b.failImport(imp.ID, phone)
+		}
+	}
+
+	for start := imp.Processed; start < len(persons); start += importBatchSize {
+
+		// The import stays running and is continued by the next run
+		if ctx.Err() != nil {
+			log.Infof("Import %d interrupted after %d persons", imp.ID, start)
+			return nil
+		}
+
+		end := start + importBatchSize
+		if end > len(persons) {
+			end = len(persons)
+		}
+
+		// Persons may have asked to be deleted after the rows were
+		// validated
+		batch, suppressed, err := b.withoutSuppressed(persons[start:end])
+		if err != nil {
 			return err
 		}
 
+		summary, err := b.store.ImportBatch(imp.ID, batch, b.onboardingMessages(batch), suppressed, imp.Mode, time.Now())
+		if errors.Is(err, ErrImportCancelled) {
+			log.Infof("Import %d cancelled after %d persons", imp.ID, start)
+			return nil
+		}
+		var exists *PersonExistsError
+		if errors.As(err, &exists) {
+			return b.failImport(imp.ID, exists.Phone)
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Debugf("Import %d: %d of %d persons processed: %+v\n", imp.ID, end, len(persons), summary)
+		if summary.Inserted > 0 {
+			b.delivery.Trigger(triggerMessageQueued)
+		}
 	}
-	return tx.Commit()
+
+	log.Infof("Import %d completed", imp.ID)
+	return b.store.FinishImport(imp.ID, ImportRunning, ImportDone, "", time.Now())
+}
+
+// failImport stops a running import because the person with phone exists
+// already
+func (b *Bridge) failImport(id int, phone string) error {
+	log.Infof("Import %d failed: person %s exists already", id, phone)
+	return b.store.FinishImport(id, ImportRunning, ImportFailed, "Rufnummer schon vorhanden: "+phone, time.Now())
+}
+
+// existingPerson returns the phone number of the first of persons who exists
+// already, empty if none does
+func (b *Bridge) existingPerson(persons []Person) (string, error) {
+
+	for start := 0; start < len(persons); start += importBatchSize {
+
+		end := start + importBatchSize
+		if end > len(persons) {
+			end = len(persons)
+		}
+
+		phones := []string{}
+		for _, person := range persons[start:end] {
+			phones = append(phones, person.Phone)
+		}
+
+		existing, err := b.store.ExistingPhones(phones)
+		if err != nil {
+			return "", err
+		}
+		if len(existing) > 0 {
+			return existing[0], nil
+		}
+	}
+
+	return "", nil
+}
+
+// onboardingMessages returns the onboarding message for each person in their
+// language. Persons whose message can't be rendered get none.
+func (b *Bridge) onboardingMessages(persons []Person) []OutboxMessage {
+
+	messages := make([]OutboxMessage, len(persons))
+	for k, person := range persons {
+		body, err := b.renderMessage(person.CenterID, person.MessageLanguage(), messageOnboarding, MessageData{}, false)
+		if err != nil {
+			log.Errorf("Error rendering onboarding message for %s: %v", person.Phone, err)
+			continue
+		}
+		messages[k] = OutboxMessage{Phone: person.Phone, Body: body}
+	}
+	return messages
+}
+
+// GetImport returns an import with all rows of its selected sheet
+func (b *Bridge) GetImport(id int) (Import, []ImportRow, error) {
+
+	imp, err := b.store.GetImport(id)
+	if err != nil {
+		return imp, nil, err
+	}
+
+	rows, err := b.store.GetImportRows(id, imp.Sheet)
+	return imp, rows, err
+}
+
+// GetImportPreview returns an import with the number of valid and invalid
+// rows and the first of them
+func (b *Bridge) GetImportPreview(id int) (ImportPreview, error) {
+
+	imp, rows, err := b.GetImport(id)
+	if err != nil {
+		return ImportPreview{}, err
+	}
+
+	preview := ImportPreview{Import: imp}
+	width := 0
+
+	for _, row := range rows {
+
+		fields := row.Fields()
+		if len(fields) > width {
+			width = len(fields)
+		}
+
+		switch {
+		case imp.IsHeader(row):
+			for k, name := range fields {
+				preview.Columns = append(preview.Columns, ImportColumn{Index: k, Name: strings.TrimSpace(name)})
+			}
+		case row.Error != "":
+			preview.Invalid++
+			if len(preview.Errors) < importPreviewErrors {
+				preview.Errors = append(preview.Errors, row)
+			}
+		default:
+			preview.Valid++
+			if len(preview.Rows) < importPreviewRows {
+				preview.Rows = append(preview.Rows, row)
+			}
+		}
+	}
+
+	// Columns without header, also those after the last named one
+	for k := len(preview.Columns); k < width; k++ {
+		preview.Columns = append(preview.Columns, ImportColumn{Index: k})
+	}
+
+	return preview, nil
 }
 
 // CallStatus bundles a call and the persons who have accepted it for simpler
@@ -324,12 +1013,17 @@ type CallStatus struct {
 // accepted it
 func (b *Bridge) GetCallStatus(id string) (CallStatus, error) {
 
-	var err error
 	status := CallStatus{}
 
 	//Retrieve call information
-	call := Call{}
-	if err = b.db.Get(&call, "SELECT * FROM calls WHERE id=$1", id); err != nil {
+	callID, err := strconv.Atoi(id)
+	if err != nil {
+		log.Warn("Invalid callID:", id)
+		return status, err
+	}
+
+	call, err := b.store.GetCall(callID)
+	if err != nil {
 		log.Warn("Failed to find call with callID:", id)
 		log.Warn(err)
 	}
@@ -344,11 +1038,7 @@ func (b *Bridge) GetActiveCalls() ([]Call, error) {
 
 	log.Debugf("Retrieving active calls with end time before %s", time.Now())
 
-	// Query the database, storing results in a []User (wrapped in []interface{})
-	calls := []Call{}
-	// b.db.Select(&calls, "SELECT * FROM calls ORDER BY time_start ASC")j
-	err := b.db.Select(&calls, "SELECT * FROM calls where time_end > $1", time.Now())
-
+	calls, err := b.store.GetActiveCalls(time.Now())
 	if err != nil {
 		log.Error(err)
 		return calls, err
@@ -359,30 +1049,13 @@ func (b *Bridge) GetActiveCalls() ([]Call, error) {
 }
 
 // GetNextPersonsForCall finds the next `num` persons that should be notified
-// for a callID. Selection is based on group_num
-//TODO FIXME
+// for a callID. Selection is based on group_num, persons that already have an
+// invitation for this call or an open one for another call are skipped
 func (b *Bridge) GetNextPersonsForCall(num, callID int) ([]Person, error) {
 
-	// Selection is based on:
-	// - group_num (lower first)
-	// - random from group_num
-
-	// Get all groups
-
 	log.Debugf("Retrieving next persons %v for call ID: %v\n", num, callID)
 
-	persons := []Person{}
-	err := b.db.Select(&persons,
-		`SELECT * FROM persons
-			WHERE id NOT IN (
-				SELECT id FROM invitations
-					WHERE status NOT IN (
-						"accepted", "notified"
-					)
-					OR call_id !=$1
-				)
-			ORDER BY group_num LIMIT $2`,
-		callID, num)
+	persons, err := b.store.GetNextPersonsForCall(num, callID)
 	if err != nil {
 		log.Error(err)
 		return persons, err
@@ -397,14 +1070,7 @@ func (b *Bridge) GetAcceptedPersons(id int) ([]Person, error) {
 
 	log.Debugf("Retrieving accepted persons for call ID: %v\n", id)
 
-	persons := []Person{}
-	// err := b.db.Select(&persons, "SELECT * FROM persons where last_call_accepted=$1", id)
-	err := b.db.Select(&persons,
-		`SELECT persons.* FROM persons
-		JOIN invitations ON
-		persons.phone = invitations.phone
-		AND invitations.status = 'accepted'
-		AND invitations.call_id=$1`, id)
+	persons, err := b.store.GetAcceptedPersons(id)
 	if err != nil {
 		log.Error(err)
 		return persons, err
@@ -419,10 +1085,7 @@ func (b *Bridge) GetPersons() ([]Person, error) {
 
 	log.Debug("Retrieving persons")
 
-	// Query the database, storing results in a []User (wrapped in []interface{})
-	persons := []Person{}
-	// b.db.Select(&calls, "SELECT * FROM calls ORDER BY time_start ASC")
-	err := b.db.Select(&persons, "SELECT * FROM persons")
+	persons, err := b.store.GetPersons()
 	if err != nil {
 		log.Error(err)
 		return persons, err
@@ -434,108 +1097,199 @@ func (b *Bridge) GetPersons() ([]Person, error) {
 
 // CallFull is true if the call is full. Used to check call status
 func (b *Bridge) CallFull(call Call) (bool, error) {
-	var numAccpets int
-	err := b.db.Get(numAccpets, "select count(id) from invitations where call_id=$1 and status='accepted'", call.ID)
-	return numAccpets >= call.Capacity, err
+	numAccepts, err := b.store.CountAcceptedInvitations(call.ID)
+	return numAccepts >= call.Capacity, err
 }
 
-// LastCallNotified retrieves the last call a person was notified to
-func (b *Bridge) LastCallNotified(person Person) (Call, error) {
-	lastCallOfPerson := Call{}
-	err := b.db.Get(&lastCallOfPerson, "select calls.* from calls join invitations where invitations.phone=$1 and invitations.status = \"accepted\" order by invitations.time desc limit 1", person.Phone)
-	return lastCallOfPerson, err
+// OpenInvitation returns the invitation a reply of a person refers to: the
+// most recent invitation with one of the given statuses for a call that is
+// still active. Returns ErrNoOpenInvitation if there is none.
+func (b *Bridge) OpenInvitation(person Person, statuses ...InvitationStatus) (Invitation, error) {
+	return b.store.OpenInvitation(person.Phone, statuses, time.Now())
 }
 
-// PersonAcceptLastCall retrieves the last call a person has accepted
+// PersonAcceptLastCall accepts the open invitation of a person. If the call
+// is already full, the invitation is rejected and the person is notified
+// about it. Returns ErrNoOpenInvitation if the person has no open invitation.
 func (b *Bridge) PersonAcceptLastCall(phoneNumber string) error {
 
-	// "update invitations set status = \"accepted\" where phone=$1 and id in ( select id from ( select id from invitations order by time desc limit 1) tmp )", phoneNumber)
-
 	log.Debugf("number %s trying to accept call\n", phoneNumber)
 
-	lastCall, err := b.LastCallNotified(Person{Phone: phoneNumber})
+	invitation, err := b.store.AcceptInvitation(phoneNumber, phoneNumber, time.Now())
 
-	if err != nil {
+	full := errors.Is(err, ErrCallFull)
+	if err != nil && !full {
 		return err
 	}
 
-	isFull, err := b.CallFull(lastCall)
-
+	call, err := b.store.GetCall(invitation.CallID)
 	if err != nil {
 		return err
 	}
 
-	if isFull {
-		log.Debugf("number %s rejected for call (is full)\n", phoneNumber)
-		if err := b.sender.SendMessageReject(phoneNumber); err != nil {
-			log.Error(err)
-		}
-	} else {
+	lang := b.recipient(phoneNumber).MessageLanguage()
 
-		log.Debugf("Accepting number %s for call \n", phoneNumber)
-
-		if err = b.sender.SendMessageAccept(
-			phoneNumber,
-			lastCall.TimeStart.Format("14:12"),
-			lastCall.TimeEnd.Format("14:12"),
-			lastCall.LocName,
-			lastCall.LocStreet,
-			lastCall.LocHouseNr,
-			lastCall.LocPLZ,
-			lastCall.LocCity,
-			lastCall.LocOpt,
-			genOTP(phoneNumber, lastCall.ID),
-		); err != nil {
+	if full {
+		log.Debugf("number %s rejected for call %d (is full)\n", phoneNumber, invitation.CallID)
+		body, err := b.renderCallMessage(call, lang, messageReject, newMessageData(call, time.Now(), lang))
+		if err != nil {
 			return err
 		}
+		return b.QueueMessage(OutboxMessage{
+			Phone:        phoneNumber,
+			Body:         body,
+			InvitationID: sql.NullInt64{Int64: int64(invitation.ID), Valid: true},
+		})
+	}
+
+	log.Debugf("Accepted number %s for call %d\n", phoneNumber, invitation.CallID)
+
+	data := newMessageData(call, time.Now(), lang)
+	data.OTP = genOTP(phoneNumber, call.ID)
 
-		_, err = bridge.db.NamedExec(
-			`UPDATE invitations SET status = "accepted", time=:time WHERE phone=:phone `, //TODO Test
-			map[string]interface{}{
-				"phone": phoneNumber,
-				"time":  time.Now(),
-			},
-		)
+	body, err := b.renderCallMessage(call, lang, messageAccept, data)
+	if err != nil {
+		return err
 	}
 
-	return err
+	return b.QueueMessage(OutboxMessage{
+		Phone:        phoneNumber,
+		Body:         body,
+		InvitationID: sql.NullInt64{Int64: int64(invitation.ID), Valid: true},
+	})
 }
 
-// PersonCancelCall cancels the last call a person was invited to
+// PersonCancelCall cancels the open invitation of a person. An accepted
+// invitation is cancelled, one without reply is declined. Returns
+// ErrNoOpenInvitation if the person has no open invitation.
 func (b *Bridge) PersonCancelCall(phoneNumber string) error {
 
 	log.Debugf("Cancelling call for number %s\n", phoneNumber)
 
-	_, err := bridge.db.NamedExec(
-		`UPDATE invitations SET status = "cancelled" WHERE phone=:phone`,
-		map[string]interface{}{
-			"phone": phoneNumber,
-		},
-	)
+	invitation, err := b.store.CancelInvitation(phoneNumber, phoneNumber, time.Now())
+	if err != nil {
+		return err
+	}
+
+	log.Debugf("Invitation %d of number %s is now %s\n", invitation.ID, phoneNumber, invitation.Status)
 
-	return err
+	// Offer the seat to the next person
+	b.scheduler.Trigger(triggerCancellation)
+	return nil
 }
 
-// PersonDelete removes a person from the imported data
-// TODO we shoud keep some kind of reference of the person, so that it won't be
-// reimported
+// HandleInboundMessage handles a SMS a person sent. The body is parsed with
+// ParseKeyword and the action is carried out. Replies to messages which are
+// not understood, ask for an unknown language or refer to an invitation the
+// person doesn't have are answered with a help text. Every message is logged
+// with the result of handling it. Returns the action the message was
+// understood as.
+func (b *Bridge) HandleInboundMessage(phoneNumber, body string) (Action, error) {
+
+	action := ParseKeyword(body)
+	log.Debugf("Inbound message from %s understood as %s\n", phoneNumber, action)
+
+	id, err := b.store.AddInboundMessage(InboundMessage{
+		Phone:      phoneNumber,
+		Body:       body,
+		Action:     action,
+		ReceivedAt: time.Now(),
+	})
+	if err != nil {
+		return action, err
+	}
+
+	switch action {
+	case ActionAccept:
+		err = b.PersonAcceptLastCall(phoneNumber)
+	case ActionCancel:
+		err = b.PersonCancelCall(phoneNumber)
+	case ActionDelete:
+		err = b.PersonDelete(phoneNumber)
+	case ActionLanguage:
+		err = b.PersonSetLanguage(phoneNumber, ParseLanguage(body))
+	}
+
+	result := InboundOK
+	switch {
+	case action == ActionUnknown, errors.Is(err, ErrUnknownLanguage), errors.Is(err, sql.ErrNoRows):
+		result = InboundHelp
+		err = b.QueueTemplateMessage(phoneNumber, messageHelp)
+	case errors.Is(err, ErrNoOpenInvitation):
+		result = InboundNoInvitation
+		err = b.QueueTemplateMessage(phoneNumber, messageNoInvitation)
+	}
+
+	if err != nil {
+		result = InboundError
+	}
+
+	if resultErr := b.store.SetInboundResult(id, result); resultErr != nil {
+		log.Error(resultErr)
+	}
+
+	return action, err
+}
+
+// PersonSetLanguage changes the language of the messages to a person and
+// confirms it in the new language. Returns ErrUnknownLanguage for languages
+// not in languages and sql.ErrNoRows if the number is unknown.
+func (b *Bridge) PersonSetLanguage(phoneNumber, lang string) error {
+
+	if _, ok := languageNames[lang]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownLanguage, lang)
+	}
+
+	log.Debugf("Setting language of number %s to %s\n", phoneNumber, lang)
+
+	numrows, err := b.store.SetPersonLanguage(phoneNumber, lang)
+	if err != nil {
+		return err
+	}
+	if numrows == 0 {
+		return sql.ErrNoRows
+	}
+
+	return b.QueueTemplateMessage(phoneNumber, messageLanguage)
+}
+
+// TransitionInvitation changes the status of an invitation. It fails with
+// ErrInvalidTransition if the change is not allowed from the current status
+// of the invitation. Every change is recorded in the invitation history
+// together with the actor that caused it.
+func (b *Bridge) TransitionInvitation(id int, to InvitationStatus, actor string) error {
+
+	log.Debugf("Changing invitation %d to %s by %s\n", id, to, actor)
+
+	return b.store.TransitionInvitation(id, to, actor, time.Now())
+}
+
+// PersonDelete removes a person from the imported data. The phone number is
+// added to the suppression list, so the person won't be added again.
 func (b *Bridge) PersonDelete(phoneNumber string) error {
 
 	log.Debugf("Deleting number %s\n", phoneNumber)
 
-	m := map[string]interface{}{"phone": phoneNumber}
-	result, err := b.db.NamedExec("DELETE FROM persons WHERE phone=:phone", m)
+	// Suppressing first keeps the person from being added again even if
+	// deleting fails
+	if err := b.store.AddSuppression(suppressionHash(phoneNumber), time.Now()); err != nil {
+		return err
+	}
 
+	// The text depends on the center and language of the person, render it
+	// before the person is gone
+	person := b.recipient(phoneNumber)
+	body, err := b.renderMessage(person.CenterID, person.MessageLanguage(), messageDelete, MessageData{}, false)
 	if err != nil {
 		return err
 	}
 
-	numrows, err := result.RowsAffected()
+	numrows, err := b.store.DeletePerson(phoneNumber)
 	if err != nil {
 		return err
 	}
 
-	if err := b.sender.SendMessageDelete(phoneNumber); err != nil {
+	if err := b.QueueMessage(OutboxMessage{Phone: phoneNumber, Body: body}); err != nil {
 		return err
 	}
 
@@ -1,24 +1,37 @@
 package main
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 
 	"bou.ke/monkey"
 	testfixtures "github.com/go-testfixtures/testfixtures/v3"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/jmoiron/sqlx"
-	_ "github.com/mattn/go-sqlite3"
 )
 
 var (
 	fixtures *testfixtures.Loader
-	sender   *TwillioSender
+	sender   *testSender
+	backends []testBackend
 )
 
+// testBackend is a database backend the bridge tests are run against. If
+// bridge is nil, the backend is not configured and its tests are skipped.
+type testBackend struct {
+	name     string
+	bridge   *Bridge
+	fixtures *testfixtures.Loader
+}
+
 func TestMain(m *testing.M) {
 
 	// Fix current time inside tests to:
@@ -26,8 +39,6 @@ func TestMain(m *testing.M) {
 	monkey.Patch(time.Now, func() time.Time { return time.Date(2021, 1, 1, 20, 0, 0, 0, time.UTC) })
 	fmt.Println("Time is now ", time.Now())
 
-	var err error
-
 	if _, err := os.Stat("./test.db"); err == nil {
 		// Old DB exists, try to remove it
 		fmt.Println("Removing old testDB")
@@ -37,46 +48,97 @@ func TestMain(m *testing.M) {
 		}
 	}
 
-	// exist. Exit application on errors, we can't continue without database
-	db, err := sqlx.Connect("sqlite3", "./test.db")
+	fmt.Println("creating sender")
+	sender = &testSender{}
 
-	db.SetMaxOpenConns(1)
+	// SQLite is always tested. The schema is created from scratch by the
+	// migrations when the store is opened.
+	sqlite, err := newSQLiteStore("./test.db")
 	if err != nil {
 		panic(err)
 	}
+	backends = append(backends, newTestBackend("sqlite3", "sqlite", sqlite, &sqlite.sqlStore))
 
-	fmt.Println("Creating schemas from scratch")
-	db.MustExec(schemaCalls)
-	db.MustExec(schemaPersons)
-	db.MustExec(schemaUsers)
-	db.MustExec(schemaNotifications)
+	// PostgreSQL is only tested if a DSN for a test database is set. Do NOT
+	// use a production database, all data in it will be deleted! The name
+	// of the database has to contain "test".
+	if dsn := os.Getenv("IMPF_TEST_POSTGRES_DSN"); dsn != "" {
+		postgres, err := newPostgresStore(dsn)
+		if err != nil {
+			panic(err)
+		}
+		backends = append(backends, newTestBackend("postgres", "postgresql", postgres, &postgres.sqlStore))
+	} else {
+		backends = append(backends, testBackend{name: "postgres"})
+	}
 
-	fmt.Println("creating sender")
-	sender = NewTwillioSender("test", "test", "test", "test")
+	// Tests not using forEachBackend() run against SQLite
+	bridge = backends[0].bridge
+	fixtures = backends[0].fixtures
 
-	fmt.Println("creating bridge")
-	bridge = &Bridge{
-		db:     db,
-		sender: sender,
-	}
+	os.Exit(m.Run())
+}
 
-	// Open connection to the test database.
-	// Do NOT import fixtures in a production database!
-	// Existing data would be deleted.
+// newTestBackend creates the bridge and fixture loader for a store
+func newTestBackend(name, dialect string, store Store, s *sqlStore) testBackend {
 
-	fmt.Println("creating fixtures")
-	fixtures, err = testfixtures.New(
-		testfixtures.Database(db.DB),                              // You database connection
-		testfixtures.Dialect("sqlite"),                            // Available: "postgresql", "timescaledb", "mysql", "mariadb", "sqlite" and "sqlserver"
+	fmt.Println("creating fixtures for", name)
+	loader, err := testfixtures.New(
+		testfixtures.Database(s.db.DB),                            // You database connection
+		testfixtures.Dialect(dialect),                             // Available: "postgresql", "timescaledb", "mysql", "mariadb", "sqlite" and "sqlserver"
 		testfixtures.Files("./testdata/fixtures/persons.yml"),     // the directory containing the YAML files
 		testfixtures.Files("./testdata/fixtures/invitations.yml"), // the directory containing the YAML files
 		testfixtures.Files("./testdata/fixtures/calls.yml"),       // the directory containing the YAML files
+		testfixtures.Files("./testdata/fixtures/invitation_events.yml"),
+		testfixtures.Files("./testdata/fixtures/outbox.yml"),
+		testfixtures.Files("./testdata/fixtures/suppressions.yml"),
+		testfixtures.Files("./testdata/fixtures/users.yml"),
 	)
 	if err != nil {
 		panic(err)
 	}
 
-	os.Exit(m.Run())
+	messages, err := NewMessageTemplates("", store)
+	if err != nil {
+		panic(err)
+	}
+
+	return testBackend{
+		name:     name,
+		bridge:   &Bridge{store: store, sender: sender, messages: messages},
+		fixtures: loader,
+	}
+}
+
+// forEachBackend runs f as a subtest for every database backend. The global
+// bridge and fixtures are set to the backend for the duration of the subtest.
+func forEachBackend(t *testing.T, f func(t *testing.T)) {
+
+	defaultBridge, defaultFixtures := bridge, fixtures
+	defer func() { bridge, fixtures = defaultBridge, defaultFixtures }()
+
+	for _, backend := range backends {
+		t.Run(backend.name, func(t *testing.T) {
+			if backend.bridge == nil {
+				t.Skip("IMPF_TEST_POSTGRES_DSN not set, skipping PostgreSQL tests")
+			}
+			bridge, fixtures = backend.bridge, backend.fixtures
+			f(t)
+		})
+	}
+}
+
+// testDB returns the database connection of a store, to set up test data for
+// which the store has no methods
+func testDB(s Store) *sqlx.DB {
+	switch v := s.(type) {
+	case *sqliteStore:
+		return v.db
+	case *postgresStore:
+		return v.db
+	default:
+		panic(fmt.Sprintf("unknown store %T", s))
+	}
 }
 
 func prepareTestDatabase() {
@@ -89,316 +151,595 @@ func prepareTestDatabase() {
 
 func TestBridge_GetPersons(t *testing.T) {
 
-	prepareTestDatabase()
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
 
-	tests := []struct {
-		name    string
-		want    []Person
-		wantErr bool
-	}{
-		{
-			name: "Retrieve persons from DB",
-			want: []Person{
-				{Phone: "1230", CenterID: 0, Group: 1, Status: false},
-				{Phone: "1231", CenterID: 0, Group: 1, Status: false},
-				{Phone: "1232", CenterID: 0, Group: 1, Status: false},
-			},
-			wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := bridge.GetPersons()
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.GetPersons() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Errorf("MakeGatewayInfo() mismatch (-want +got):\n%s", diff)
-			}
-		})
-	}
+		tests := []struct {
+			name    string
+			want    []Person
+			wantErr bool
+		}{
+			{
+				name: "Retrieve persons from DB",
+				want: []Person{
+					{Phone: "1230", CenterID: 0, Group: 1, Status: false},
+					{Phone: "1231", CenterID: 0, Group: 1, Status: false},
+					{Phone: "1232", CenterID: 0, Group: 1, Status: false},
+					{Phone: "1233", CenterID: 0, Group: 1, Status: false},
+					{Phone: "1234", CenterID: 0, Group: 1, Status: false},
+					{Phone: "1235", CenterID: 0, Group: 2, Status: false},
+				},
+				wantErr: false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := bridge.GetPersons()
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Bridge.GetPersons() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("MakeGatewayInfo() mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
 }
 
 func TestBridge_GetAcceptedPersons(t *testing.T) {
 
-	prepareTestDatabase()
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
 
-	tests := []struct {
-		name    string
-		id      int
-		want    []Person
-		wantErr bool
-	}{
-		{
-			name: "Call with accepted invitations",
-			id:   1,
-			want: []Person{
-				{Phone: "1230", Group: 1},
-				{Phone: "1231", Group: 1},
-			},
-			wantErr: false,
-		},
-		{
-			name:    "Call without rejected invitations",
-			id:      2,
-			want:    []Person{},
-			wantErr: false,
-		},
-		{
-			name:    "Call without invitations",
-			id:      3,
-			want:    []Person{},
-			wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := bridge.GetAcceptedPersons(tt.id)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.GetAcceptedPersons() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Errorf("MakeGatewayInfo() mismatch (-want +got):\n%s", diff)
-			}
-		})
-	}
+		tests := []struct {
+			name    string
+			id      int
+			want    []Person
+			wantErr bool
+		}{
+			{
+				name: "Call with accepted invitations",
+				id:   1,
+				want: []Person{
+					{Phone: "1230", Group: 1},
+					{Phone: "1231", Group: 1},
+				},
+				wantErr: false,
+			},
+			{
+				name:    "Call without rejected invitations",
+				id:      2,
+				want:    []Person{},
+				wantErr: false,
+			},
+			{
+				name:    "Call without invitations",
+				id:      3,
+				want:    []Person{},
+				wantErr: false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := bridge.GetAcceptedPersons(tt.id)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Bridge.GetAcceptedPersons() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("MakeGatewayInfo() mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
 }
 
 func TestBridge_AddPerson(t *testing.T) {
-	prepareTestDatabase()
-	tests := []struct {
-		name    string
-		person  Person
-		want    []Person
-		wantErr bool
-	}{
-		{
-			name: "Add a valid person",
-			person: Person{
-				Phone:    "0001",
-				CenterID: 0,
-				Group:    1,
-				Status:   false,
-			},
-			want: []Person{
-				{Phone: "1230", Group: 1},
-				{Phone: "1231", Group: 1},
-				{Phone: "1232", Group: 1},
-				{"0001", 0, 1, false},
-			}, wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := bridge.AddPerson(tt.person); (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.AddPerson() error = %v, wantErr %v", err, tt.wantErr)
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+		tests := []struct {
+			name    string
+			person  Person
+			want    []Person
+			wantErr bool
+		}{
+			{
+				name: "Add a valid person",
+				person: Person{
+					Phone:    "0001",
+					CenterID: 0,
+					Group:    1,
+					Status:   false,
+				},
+				want: []Person{
+					{Phone: "0001", CenterID: 0, Group: 1, Status: false},
+					{Phone: "1230", Group: 1},
+					{Phone: "1231", Group: 1},
+					{Phone: "1232", Group: 1},
+					{Phone: "1233", Group: 1},
+					{Phone: "1234", Group: 1},
+					{Phone: "1235", Group: 2},
+				}, wantErr: false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if err := bridge.AddPerson(tt.person); (err != nil) != tt.wantErr {
+					t.Errorf("Bridge.AddPerson() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				got, err := bridge.GetPersons()
+				if err != nil {
+					t.Errorf("GetPersons() after AddPersion() failed with error: %v \n", err)
+				}
+
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("GetPersons() after AddPersion() mismatch (-want +got):\n%s", diff)
+				}
+
+			})
+		}
+	})
+}
+
+func TestBridge_AddPersons(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		fixtures := []Person{
+			{Phone: "1230", Group: 1},
+			{Phone: "1231", Group: 1},
+			{Phone: "1232", Group: 1},
+			{Phone: "1233", Group: 1},
+			{Phone: "1234", Group: 1},
+			{Phone: "1235", Group: 2},
+		}
+
+		tests := []struct {
+			name        string
+			persons     []Person
+			mode        ImportMode
+			want        []Person
+			wantSummary ImportSummary
+			wantErr     error
+		}{
+			{
+				name: "Add two persons",
+				persons: []Person{
+					{Phone: "0001", CenterID: 0, Group: 1, Status: false},
+					{Phone: "0002", CenterID: 0, Group: 1, Status: false},
+				},
+				mode: ImportFail,
+				want: append([]Person{
+					{Phone: "0001", CenterID: 0, Group: 1, Status: false},
+					{Phone: "0002", CenterID: 0, Group: 1, Status: false},
+				}, fixtures...),
+				wantSummary: ImportSummary{Inserted: 2},
+			},
+			{
+				name: "Skip existing person",
+				persons: []Person{
+					{Phone: "0001", Group: 3},
+					{Phone: "1235", Group: 3, CenterID: 1},
+				},
+				mode:        ImportSkip,
+				want:        append([]Person{{Phone: "0001", Group: 3}}, fixtures...),
+				wantSummary: ImportSummary{Inserted: 1, Skipped: 1},
+			},
+			{
+				name: "Update existing person",
+				persons: []Person{
+					{Phone: "0001", Group: 3},
+					{Phone: "1235", Group: 3, CenterID: 1, Language: "en"},
+				},
+				mode: ImportUpdate,
+				want: append(append([]Person{{Phone: "0001", Group: 3}}, fixtures[:5]...),
+					// The language is kept
+					Person{Phone: "1235", Group: 3, CenterID: 1}),
+				wantSummary: ImportSummary{Inserted: 1, Updated: 1},
+			},
+			{
+				name: "Fail on existing person",
+				persons: []Person{
+					{Phone: "0001", Group: 3},
+					{Phone: "1235", Group: 3},
+				},
+				mode:    ImportFail,
+				want:    fixtures,
+				wantErr: ErrPersonExists,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				summary, err := bridge.AddPersons(tt.persons, tt.mode)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Bridge.AddPersons() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				var exists *PersonExistsError
+				if errors.As(err, &exists) && exists.Phone != "1235" {
+					t.Errorf("Bridge.AddPersons() existing person = %q, want %q", exists.Phone, "1235")
+				}
+				if summary != tt.wantSummary {
+					t.Errorf("Bridge.AddPersons() = %+v, want %+v", summary, tt.wantSummary)
+				}
+
+				got, err := bridge.GetPersons()
+				if err != nil {
+					t.Errorf("GetPersons() after AddPersons() failed with error: %v \n", err)
+				}
+
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("GetPersons() after AddPersons() mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func TestBridge_RunImports(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		data := "Rufnummer;Gruppe\n015100000001;1\n015100000002;2\n015100000003;1\n"
+		phones := []string{"+4915100000001", "+4915100000002"}
+
+		// createImport uploads the file of a user of center 7 and lets
+		// the worker read it. The last person of the file exists already.
+		createImport := func(t *testing.T, data string) int {
+			t.Helper()
+			if _, err := bridge.AddPersons([]Person{{Phone: "+4915100000003", Group: 1}}, ImportFail); err != nil {
+				t.Fatal(err)
+			}
+			id, err := bridge.CreateImport("test.csv", "tester", 7, []byte(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			bridge.RunImports(context.Background())
+			return id
+		}
+
+		// check compares the status of an import and the persons and
+		// onboarding messages added
+		check := func(t *testing.T, id int, status ImportStatus, summary ImportSummary, wantPersons int) {
+			t.Helper()
+
+			imp, err := bridge.store.GetImport(id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imp.Status != status || imp.ImportSummary != summary {
+				t.Errorf("GetImport() = %s %+v, want %s %+v", imp.Status, imp.ImportSummary, status, summary)
 			}
 
-			got, err := bridge.GetPersons()
+			var persons, messages int
+			db := testDB(bridge.store)
+			query, args, err := sqlx.In("SELECT COUNT(*) FROM persons WHERE phone IN (?)", phones)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.Get(&persons, db.Rebind(query), args...); err != nil {
+				t.Fatal(err)
+			}
+			query, args, err = sqlx.In("SELECT COUNT(*) FROM outbox WHERE phone IN (?)", phones)
 			if err != nil {
-				t.Errorf("GetPersons() after AddPersion() failed with error: %v \n", err)
+				t.Fatal(err)
+			}
+			if err := db.Get(&messages, db.Rebind(query), args...); err != nil {
+				t.Fatal(err)
+			}
+			if persons != wantPersons || messages != wantPersons || imp.Queued != wantPersons {
+				t.Errorf("%d persons and %d onboarding messages (%d queued), want %d", persons, messages, imp.Queued, wantPersons)
+			}
+		}
+
+		t.Run("Import", func(t *testing.T) {
+			prepareTestDatabase()
+
+			id := createImport(t, data)
+			check(t, id, ImportReady, ImportSummary{}, 0)
+
+			if err := bridge.ConfirmImport(id, ImportSkip); err != nil {
+				t.Fatal(err)
 			}
+			check(t, id, ImportQueued, ImportSummary{}, 0)
 
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Errorf("GetPersons() after AddPersion() mismatch (-want +got):\n%s", diff)
+			bridge.RunImports(context.Background())
+			check(t, id, ImportDone, ImportSummary{Inserted: 2, Skipped: 1}, 2)
+
+			// The persons belong to the center of the user
+			person, err := bridge.store.GetPerson(phones[0])
+			if err != nil || person.CenterID != 7 {
+				t.Errorf("GetPerson() = %+v, %v, want center 7", person, err)
 			}
 
+			if err := bridge.ConfirmImport(id, ImportSkip); !errors.Is(err, ErrImportDone) {
+				t.Errorf("ConfirmImport() error = %v, want %v", err, ErrImportDone)
+			}
+			if err := bridge.CancelImport(id); !errors.Is(err, ErrImportDone) {
+				t.Errorf("CancelImport() error = %v, want %v", err, ErrImportDone)
+			}
 		})
-	}
-}
 
-func TestBridge_AddPersons(t *testing.T) {
-	prepareTestDatabase()
-	tests := []struct {
-		name    string
-		persons []Person
-		want    []Person
-		wantErr bool
-	}{
-		{
-			name: "Add two persons",
-			persons: []Person{
-				{"0001", 0, 1, false},
-				{"0002", 0, 1, false},
-			},
-			want: []Person{
-				{Phone: "1230", Group: 1},
-				{Phone: "1231", Group: 1},
-				{Phone: "1232", Group: 1},
-				{"0001", 0, 1, false},
-				{"0002", 0, 1, false},
-			},
-			wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := bridge.AddPersons(tt.persons); (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.AddPersons() error = %v, wantErr %v", err, tt.wantErr)
+		t.Run("Cancel and confirm again", func(t *testing.T) {
+			prepareTestDatabase()
+
+			id := createImport(t, data)
+			if err := bridge.ConfirmImport(id, ImportSkip); err != nil {
+				t.Fatal(err)
+			}
+			if err := bridge.CancelImport(id); err != nil {
+				t.Fatal(err)
+			}
+
+			bridge.RunImports(context.Background())
+			check(t, id, ImportCancelled, ImportSummary{}, 0)
+
+			if err := bridge.ConfirmImport(id, ImportSkip); err != nil {
+				t.Fatal(err)
+			}
+			bridge.RunImports(context.Background())
+			check(t, id, ImportDone, ImportSummary{Inserted: 2, Skipped: 1}, 2)
+		})
+
+		t.Run("Cancel while running", func(t *testing.T) {
+			prepareTestDatabase()
+
+			id := createImport(t, data)
+			if err := bridge.ConfirmImport(id, ImportSkip); err != nil {
+				t.Fatal(err)
+			}
+			if err := bridge.store.StartImport(id, 3); err != nil {
+				t.Fatal(err)
+			}
+			if err := bridge.CancelImport(id); err != nil {
+				t.Fatal(err)
 			}
 
-			got, err := bridge.GetPersons()
+			// The batch in progress is not imported
+			_, err := bridge.store.ImportBatch(id, []Person{{Phone: phones[0], Group: 1}}, nil, 0, ImportSkip, time.Now())
+			if !errors.Is(err, ErrImportCancelled) {
+				t.Errorf("ImportBatch() error = %v, want %v", err, ErrImportCancelled)
+			}
+			check(t, id, ImportCancelled, ImportSummary{}, 0)
+		})
+
+		t.Run("Continue interrupted import", func(t *testing.T) {
+			prepareTestDatabase()
+
+			id := createImport(t, data)
+			if err := bridge.ConfirmImport(id, ImportSkip); err != nil {
+				t.Fatal(err)
+			}
+			if err := bridge.store.StartImport(id, 3); err != nil {
+				t.Fatal(err)
+			}
+			batch := []Person{{Phone: phones[0], Group: 1}}
+			if _, err := bridge.store.ImportBatch(id, batch, bridge.onboardingMessages(batch), 0, ImportSkip, time.Now()); err != nil {
+				t.Fatal(err)
+			}
+
+			// A cancelled context stops the worker before the next batch
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			bridge.RunImports(ctx)
+			check(t, id, ImportRunning, ImportSummary{Inserted: 1}, 1)
+
+			bridge.RunImports(context.Background())
+			check(t, id, ImportDone, ImportSummary{Inserted: 2, Skipped: 1}, 2)
+		})
+
+		t.Run("Unreadable file", func(t *testing.T) {
+			prepareTestDatabase()
+
+			id := createImport(t, "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1")
+			imp, err := bridge.store.GetImport(id)
 			if err != nil {
-				t.Errorf("GetPersons() after AddPersons() failed with error: %v \n", err)
+				t.Fatal(err)
+			}
+			if imp.Status != ImportFailed || imp.Error != "Dateiformat wird nicht unterstützt" {
+				t.Errorf("GetImport() = %s %q, want failed import", imp.Status, imp.Error)
+			}
+			if _, err := bridge.store.GetImportFile(id); !errors.Is(err, sql.ErrNoRows) {
+				t.Errorf("GetImportFile() error = %v, want %v", err, sql.ErrNoRows)
 			}
 
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Errorf("GetPersons() after AddPersons() mismatch (-want +got):\n%s", diff)
+			// Without rows there is nothing to confirm
+			if err := bridge.ConfirmImport(id, ImportSkip); !errors.Is(err, ErrImportDone) {
+				t.Errorf("ConfirmImport() error = %v, want %v", err, ErrImportDone)
 			}
 		})
-	}
+	})
 }
 
 func TestBridge_GetCallStatus(t *testing.T) {
 
-	prepareTestDatabase()
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
 
-	loc := time.FixedZone("myzone", 3600)
+		loc := time.FixedZone("myzone", 3600)
 
-	tests := []struct {
-		name    string
-		id      string
-		want    CallStatus
-		wantErr bool
-	}{
-		{
-			name: "Get a valid callstatus",
-			id:   "1",
-			want: CallStatus{
-				Call: Call{
-					ID:         1,
-					Title:      "Call number 1",
-					CenterID:   0,
-					Capacity:   1,
-					TimeStart:  time.Date(2021, time.February, 10, 12, 30, 0, 0, loc),
-					TimeEnd:    time.Date(2021, time.February, 10, 12, 35, 0, 0, loc),
-					LocName:    "loc_name1",
-					LocStreet:  "loc_street1",
-					LocHouseNr: "loc_housenr1",
-					YoungOnly:  true,
-					LocPLZ:     "loc_plz1",
-					LocCity:    "loc_city1",
-					LocOpt:     "loc_opt1",
-				},
-				Persons: []Person{
-					{"1230", 0, 1, false},
-					{"1231", 0, 1, false},
+		tests := []struct {
+			name    string
+			id      string
+			want    CallStatus
+			wantErr bool
+		}{
+			{
+				name: "Get a valid callstatus",
+				id:   "1",
+				want: CallStatus{
+					Call: Call{
+						ID:         1,
+						Title:      "Call number 1",
+						CenterID:   0,
+						Capacity:   1,
+						TimeStart:  time.Date(2021, time.February, 10, 12, 30, 0, 0, loc),
+						TimeEnd:    time.Date(2021, time.February, 10, 12, 35, 0, 0, loc),
+						LocName:    "loc_name1",
+						LocStreet:  "loc_street1",
+						LocHouseNr: "loc_housenr1",
+						YoungOnly:  true,
+						LocPLZ:     "loc_plz1",
+						LocCity:    "loc_city1",
+						LocOpt:     "loc_opt1",
+						SeatsTaken: 2,
+
+						ResponseWindow: 60,
+						Overbooking:    1,
+					},
+					Persons: []Person{
+						{Phone: "1230", CenterID: 0, Group: 1, Status: false},
+						{Phone: "1231", CenterID: 0, Group: 1, Status: false},
+					},
 				},
+				wantErr: false,
 			},
-			wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := bridge.GetCallStatus(tt.id)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.GetCallStatus() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := bridge.GetCallStatus(tt.id)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Bridge.GetCallStatus() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
 
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Errorf("Bridge.GetPersons() mismatch (-want +got):\n%s", diff)
-			}
-		})
-	}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("Bridge.GetPersons() mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
 }
 
 func TestBridge_GetActiveCalls(t *testing.T) {
 
-	prepareTestDatabase()
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
 
-	loc := time.FixedZone("myzone", 3600)
+		loc := time.FixedZone("myzone", 3600)
 
-	tests := []struct {
-		name    string
-		want    []Call
-		wantErr bool
-	}{
-		{
-			name: "Get two active calls",
-			want: []Call{
-
-				{
-					ID:         1,
-					Title:      "Call number 1",
-					Capacity:   1,
-					TimeStart:  time.Date(2021, time.February, 10, 12, 30, 0, 0, loc),
-					TimeEnd:    time.Date(2021, time.February, 10, 12, 35, 0, 0, loc),
-					YoungOnly:  true,
-					LocName:    "loc_name1",
-					LocStreet:  "loc_street1",
-					LocHouseNr: "loc_housenr1",
-					LocPLZ:     "loc_plz1",
-					LocCity:    "loc_city1",
-					LocOpt:     "loc_opt1",
-				},
-				{
-					ID:         2,
-					Title:      "Call number 2",
-					Capacity:   2,
-					TimeStart:  time.Date(2021, time.February, 10, 12, 31, 0, 0, loc),
-					TimeEnd:    time.Date(2021, time.February, 10, 12, 36, 0, 0, loc),
-					LocName:    "loc_name2",
-					LocStreet:  "loc_street2",
-					LocHouseNr: "loc_housenr2",
-					LocPLZ:     "loc_plz2",
-					LocCity:    "loc_city2",
+		tests := []struct {
+			name    string
+			want    []Call
+			wantErr bool
+		}{
+			{
+				name: "Get two active calls",
+				want: []Call{
+
+					{
+						ID:         1,
+						Title:      "Call number 1",
+						Capacity:   1,
+						TimeStart:  time.Date(2021, time.February, 10, 12, 30, 0, 0, loc),
+						TimeEnd:    time.Date(2021, time.February, 10, 12, 35, 0, 0, loc),
+						YoungOnly:  true,
+						LocName:    "loc_name1",
+						LocStreet:  "loc_street1",
+						LocHouseNr: "loc_housenr1",
+						LocPLZ:     "loc_plz1",
+						LocCity:    "loc_city1",
+						LocOpt:     "loc_opt1",
+						SeatsTaken: 2,
+
+						ResponseWindow: 60,
+						Overbooking:    1,
+					},
+					{
+						ID:         2,
+						Title:      "Call number 2",
+						Capacity:   2,
+						TimeStart:  time.Date(2021, time.February, 10, 12, 31, 0, 0, loc),
+						TimeEnd:    time.Date(2021, time.February, 10, 12, 36, 0, 0, loc),
+						LocName:    "loc_name2",
+						LocStreet:  "loc_street2",
+						LocHouseNr: "loc_housenr2",
+						LocPLZ:     "loc_plz2",
+						LocCity:    "loc_city2",
+
+						ResponseWindow: 60,
+						Overbooking:    1,
+					},
 				},
+				wantErr: false,
 			},
-			wantErr: false,
-		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := bridge.GetActiveCalls()
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.GetActiveCalls() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := bridge.GetActiveCalls()
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Bridge.GetActiveCalls() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
 
-			if diff := cmp.Diff(tt.want, got); diff != "" {
-				t.Errorf("Bridge.GetActive() mismatch (-want +got):\n%s", diff)
-			}
-		})
-	}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("Bridge.GetActive() mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
 }
 
 func TestBridge_GetNextPersonsForCall(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
-	}
-	type args struct {
-		num    int
-		callID int
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    []Person
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
-			}
-			got, err := b.GetNextPersonsForCall(tt.args.num, tt.args.callID)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.GetNextPersonsForCall() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Bridge.GetNextPersonsForCall() = %v, want %v", got, tt.want)
-			}
-		})
-	}
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		type args struct {
+			num    int
+			callID int
+		}
+		tests := []struct {
+			name    string
+			args    args
+			want    []Person
+			wantErr bool
+		}{
+			{
+				name: "Skip persons with accepted or previous invitations",
+				args: args{num: 10, callID: 3},
+				want: []Person{
+					{Phone: "1232", Group: 1},
+					{Phone: "1235", Group: 2},
+				},
+				wantErr: false,
+			},
+			{
+				name: "Skip persons invited to call",
+				args: args{num: 10, callID: 2},
+				want: []Person{
+					{Phone: "1235", Group: 2},
+				},
+				wantErr: false,
+			},
+			{
+				name: "Limit number of persons",
+				args: args{num: 1, callID: 3},
+				want: []Person{
+					{Phone: "1232", Group: 1},
+				},
+				wantErr: false,
+			},
+			{
+				name:    "Zero persons",
+				args:    args{num: 0, callID: 3},
+				want:    []Person{},
+				wantErr: false,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := bridge.GetNextPersonsForCall(tt.args.num, tt.args.callID)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Bridge.GetNextPersonsForCall() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("Bridge.GetNextPersonsForCall() mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
 }
 
 func TestNewBridge(t *testing.T) {
@@ -419,8 +760,8 @@ func TestNewBridge(t *testing.T) {
 
 func TestBridge_DeleteOldCalls(t *testing.T) {
 	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
+		store  Store
+		sender MessageSender
 	}
 	tests := []struct {
 		name   string
@@ -431,7 +772,7 @@ func TestBridge_DeleteOldCalls(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			b := Bridge{
-				db:     tt.fields.db,
+				store:  tt.fields.store,
 				sender: tt.fields.sender,
 			}
 			b.DeleteOldCalls()
@@ -440,61 +781,382 @@ func TestBridge_DeleteOldCalls(t *testing.T) {
 }
 
 func TestBridge_SendNotifications(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
-	}
-	tests := []struct {
-		name   string
-		fields fields
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
-			}
-			b.SendNotifications()
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		bridge.SendNotifications()
+
+		// Invitations past their deadline have expired
+		assertInvitationsUnchangedExcept(t, map[int]InvitationStatus{
+			4: InvitationExpired,
+			6: InvitationExpired,
 		})
-	}
-}
 
-func TestBridge_NotifyCall(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
-	}
-	type args struct {
-		id         int
-		numPersons int
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
+		// The seat of the expired invitation is given to the next
+		// person in a new wave. Call 1 is full and gets no invitations
+		got, err := bridge.store.GetInvitationsByPhone("1235")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Invitation{{
+			Phone:     "1235",
+			CallID:    2,
+			Status:    InvitationNotified,
+			Time:      time.Now(),
+			Wave:      2,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}}
+
+		if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Invitation{}, "ID")); diff != "" {
+			t.Errorf("GetInvitationsByPhone() mismatch (-want +got):\n%s", diff)
+		}
+
+		// The time of the wave is recorded on the call
+		call, err := bridge.store.GetCall(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !call.LastWaveAt.Valid || !call.LastWaveAt.Time.Equal(time.Now()) {
+			t.Errorf("GetCall().LastWaveAt = %v, want %v", call.LastWaveAt, time.Now())
+		}
+
+		// Running again does not invite anyone else
+		bridge.SendNotifications()
+
+		if got, err := bridge.store.NextWave(2); err != nil || got != 3 {
+			t.Errorf("NextWave() = %v, %v, want 3", got, err)
+		}
+	})
+}
+
+func TestBridge_nextWakeup(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		// Invitation 4 is past its deadline, but not yet expired
+		want := time.Date(2021, 1, 1, 10, 0, 0, 0, time.UTC)
+		if got := bridge.nextWakeup(time.Now()); !got.Equal(want) {
+			t.Errorf("Bridge.nextWakeup() = %v, want %v", got, want)
+		}
+
+		// After expiring, the earliest deadline is that of the open
+		// invitations
+		bridge.SendNotifications()
+
+		want = time.Date(2021, 1, 1, 21, 0, 0, 0, time.UTC)
+		if got := bridge.nextWakeup(time.Now()); !got.Equal(want) {
+			t.Errorf("Bridge.nextWakeup() = %v, want %v", got, want)
+		}
+
+		// Nothing to wake up for without open invitations
+		if _, err := testDB(bridge.store).Exec("UPDATE invitations SET status = 'declined'"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := bridge.nextWakeup(time.Now()); !got.IsZero() {
+			t.Errorf("Bridge.nextWakeup() = %v, want zero time", got)
+		}
+
+		// Calls with free seats wake up for their next wave, full calls
+		// and calls which have ended don't
+		db := testDB(bridge.store)
+		for id, lastWave := range map[int]time.Time{
+			1: time.Now().Add(-time.Minute),
+			2: time.Now().Add(-2 * time.Minute),
+			3: time.Now().Add(-time.Minute),
+		} {
+			if _, err := db.Exec("UPDATE calls SET wave_spacing = 5, last_wave_at = $1 WHERE id = $2", lastWave, id); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		want = time.Now().Add(3 * time.Minute)
+		if got := bridge.nextWakeup(time.Now()); !got.Equal(want) {
+			t.Errorf("Bridge.nextWakeup() = %v, want %v", got, want)
+		}
+
+		// A wave which is due already is sent by the current run
+		if got := bridge.nextWakeup(time.Now().Add(5 * time.Minute)); !got.IsZero() {
+			t.Errorf("Bridge.nextWakeup() = %v, want zero time", got)
+		}
+	})
+}
+
+func TestBridge_SchedulerTriggers(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		// The scheduler is not started, triggers stay in its channel
+		bridge.scheduler = NewScheduler(time.Hour, nil, nil)
+		defer func() { bridge.scheduler = nil }()
+
+		if err := bridge.AddCall(Call{Title: "Call", Capacity: 1, ResponseWindow: 60, Overbooking: 1}); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case got := <-bridge.scheduler.trigger:
+			if got != triggerCallCreated {
+				t.Errorf("trigger = %q, want %q", got, triggerCallCreated)
 			}
-			if err := b.NotifyCall(tt.args.id, tt.args.numPersons); (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.NotifyCall() error = %v, wantErr %v", err, tt.wantErr)
+		default:
+			t.Errorf("scheduler not triggered, want %q", triggerCallCreated)
+		}
+
+		if err := bridge.PersonCancelCall("1230"); err != nil {
+			t.Fatal(err)
+		}
+
+		select {
+		case got := <-bridge.scheduler.trigger:
+			if got != triggerCancellation {
+				t.Errorf("trigger = %q, want %q", got, triggerCancellation)
 			}
-		})
-	}
+		default:
+			t.Errorf("scheduler not triggered, want %q", triggerCancellation)
+		}
+	})
+}
+
+func TestBridge_SendNotificationsStrategy(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		// Call 2 has two free seats and one open invitation. Besides 1235,
+		// these persons are available to be invited
+		persons := []Person{
+			{Phone: "1236", CenterID: 0, Group: 2},
+			{Phone: "1237", CenterID: 0, Group: 2},
+			{Phone: "1238", CenterID: 0, Group: 2},
+		}
+
+		tests := []struct {
+			name       string
+			update     string
+			wantPhones []string
+		}{
+			{
+				name:       "Overbooking invites more persons than seats left",
+				update:     "UPDATE calls SET overbooking = 2 WHERE id = 2",
+				wantPhones: []string{"1235", "1236", "1237"},
+			},
+			{
+				name:       "Max outstanding limits open invitations",
+				update:     "UPDATE calls SET overbooking = 3, max_outstanding = 3 WHERE id = 2",
+				wantPhones: []string{"1235", "1236"},
+			},
+			{
+				name:       "No wave within spacing of the last one",
+				update:     "UPDATE calls SET wave_spacing = 30, last_wave_at = '2021-01-01 19:45:00' WHERE id = 2",
+				wantPhones: nil,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				if _, err := bridge.store.AddPersons(persons, ImportFail); err != nil {
+					t.Fatal(err)
+				}
+
+				if _, err := testDB(bridge.store).Exec(tt.update); err != nil {
+					t.Fatal(err)
+				}
+
+				bridge.SendNotifications()
+
+				var phones []string
+				if err := testDB(bridge.store).Select(&phones,
+					`SELECT phone FROM invitations
+					WHERE call_id = 2 AND status = 'notified' AND wave = 2
+					ORDER BY phone`); err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(tt.wantPhones, phones); diff != "" {
+					t.Errorf("invited persons mismatch (-want +got):\n%s", diff)
+				}
+			})
+		}
+	})
+}
+
+func TestBridge_NotifyCall(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		if err := bridge.NotifyCall(2, 5); err != nil {
+			t.Errorf("Bridge.NotifyCall() error = %v", err)
+		}
+
+		// Invitations are recorded together with their messages, which
+		// are delivered from the outbox afterwards
+		var phones []string
+		if err := testDB(bridge.store).Select(&phones,
+			`SELECT invitations.phone FROM invitations
+			JOIN outbox ON outbox.invitation_id = invitations.id
+			WHERE invitations.call_id = 2 AND invitations.status = 'notified'
+			ORDER BY invitations.phone`); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff([]string{"1235"}, phones); diff != "" {
+			t.Errorf("invitations with message mismatch (-want +got):\n%s", diff)
+		}
+
+		pending, err := bridge.store.PendingMessages(10, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 1 {
+			t.Errorf("PendingMessages() = %v, want 1 message", pending)
+		}
+	})
+}
+
+func TestBridge_NotifyCall_Language(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		if _, err := bridge.store.SetPersonLanguage("1235", "en"); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bridge.NotifyCall(2, 5); err != nil {
+			t.Fatalf("Bridge.NotifyCall() error = %v", err)
+		}
+
+		msg, err := bridge.store.GetMessages(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msg) != 1 || msg[0].Phone != "1235" || !strings.HasPrefix(msg[0].Body, "You can get a Corona vaccination") {
+			t.Errorf("invitation = %+v, want English invitation to 1235", msg)
+		}
+	})
+}
+
+func TestBridge_DeliverOutbox(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		// Invitations saved, but the application stopped before the
+		// messages were delivered
+		messages := []OutboxMessage{
+			{Phone: "1232", Body: "Invitation 1"},
+			{Phone: "1235", Body: "Invitation 2"},
+		}
+		if err := bridge.store.AddInvitations(3, 1, messages, time.Now(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		bridge.DeliverOutbox()
+
+		pending, err := bridge.store.PendingMessages(10, time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pending) != 0 {
+			t.Errorf("PendingMessages() = %v, want none", pending)
+		}
+
+		var numSent int
+		if err := testDB(bridge.store).Get(&numSent, "SELECT COUNT(id) FROM outbox WHERE status='sent'"); err != nil {
+			t.Fatal(err)
+		}
+		if numSent != 2 {
+			t.Errorf("sent messages = %d, want 2", numSent)
+		}
+
+		// The ID the provider assigned is saved for delivery receipts
+		messagesSent, err := bridge.store.GetMessages(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, msg := range messagesSent {
+			if !strings.HasPrefix(msg.ProviderID, "test-") {
+				t.Errorf("message %d has provider ID %q, want test-<n>", msg.ID, msg.ProviderID)
+			}
+		}
+	})
+}
+
+func TestBridge_DeliverOutboxRetry(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		defer sender.setFail(false)
+		sender.setFail(true)
+
+		if err := bridge.store.QueueMessage(OutboxMessage{Phone: "1232", Body: "Hallo"}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+
+		getMessage := func() OutboxMessage {
+			messages, err := bridge.store.GetMessages(1)
+			if err != nil || len(messages) != 1 {
+				t.Fatalf("GetMessages() = %v, %v", messages, err)
+			}
+			return messages[0]
+		}
+
+		// A failed message is retried after a delay
+		bridge.DeliverOutbox()
+
+		msg := getMessage()
+		wantNext := time.Now().Add(outboxRetryBase)
+		if msg.Status != MessagePending || msg.Attempts != 1 || msg.LastError != "sending failed" ||
+			!msg.NextAttemptAt.Valid || !msg.NextAttemptAt.Time.Equal(wantNext) {
+			t.Errorf("message after first attempt = %+v, want retry at %v", msg, wantNext)
+		}
+
+		if next := bridge.nextDelivery(time.Now()); !next.Equal(wantNext) {
+			t.Errorf("Bridge.nextDelivery() = %v, want %v", next, wantNext)
+		}
+
+		// Not sent again before it is due
+		bridge.DeliverOutbox()
+		if got := getMessage().Attempts; got != 1 {
+			t.Errorf("attempts = %d, want 1", got)
+		}
+
+		// The last attempt fails, the message is given up
+		if _, err := testDB(bridge.store).Exec("UPDATE outbox SET attempts = $1, next_attempt_at = NULL",
+			outboxMaxAttempts-1); err != nil {
+			t.Fatal(err)
+		}
+
+		bridge.DeliverOutbox()
+
+		msg = getMessage()
+		if msg.Status != MessageFailed || msg.Attempts != outboxMaxAttempts || msg.NextAttemptAt.Valid {
+			t.Errorf("message after last attempt = %+v, want failed", msg)
+		}
+
+		// Failed messages can be queued again
+		if err := bridge.RequeueMessage(msg.ID); err != nil {
+			t.Errorf("Bridge.RequeueMessage() error = %v", err)
+		}
+
+		sender.setFail(false)
+		bridge.DeliverOutbox()
+
+		msg = getMessage()
+		if msg.Status != MessageSent || msg.Attempts != 1 {
+			t.Errorf("message after requeue = %+v, want sent", msg)
+		}
+
+		// Only failed messages can be queued again
+		if err := bridge.RequeueMessage(msg.ID); !errors.Is(err, ErrMessageNotFailed) {
+			t.Errorf("Bridge.RequeueMessage() error = %v, want %v", err, ErrMessageNotFailed)
+		}
+	})
 }
 
 func TestBridge_CallFull(t *testing.T) {
 	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
+		store  Store
+		sender MessageSender
 	}
 	type args struct {
 		call Call
@@ -511,7 +1173,7 @@ func TestBridge_CallFull(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			b := &Bridge{
-				db:     tt.fields.db,
+				store:  tt.fields.store,
 				sender: tt.fields.sender,
 			}
 			got, err := b.CallFull(tt.args.call)
@@ -526,124 +1188,429 @@ func TestBridge_CallFull(t *testing.T) {
 	}
 }
 
-func TestBridge_LastCallNotified(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
-	}
-	type args struct {
-		person Person
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    Call
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
-			}
-			got, err := b.LastCallNotified(tt.args.person)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.LastCallNotified() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("Bridge.LastCallNotified() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
 func TestBridge_PersonAcceptLastCall(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
-	}
-	type args struct {
-		phoneNumber string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
-			}
-			if err := b.PersonAcceptLastCall(tt.args.phoneNumber); (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.PersonAcceptLastCall() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
+	forEachBackend(t, func(t *testing.T) {
+
+		tests := []struct {
+			name           string
+			phoneNumber    string
+			wantInvitation int
+			wantStatus     InvitationStatus
+			wantMessage    string
+			wantErr        error
+		}{
+			{
+				name:           "Accept open invitation",
+				phoneNumber:    "1230",
+				wantInvitation: 3,
+				wantStatus:     InvitationAccepted,
+				wantMessage:    "Termin bestätigt",
+			},
+			{
+				name:           "Accept invitation of full call",
+				phoneNumber:    "1233",
+				wantInvitation: 5,
+				wantStatus:     InvitationRejected,
+				wantMessage:    "Leider wurden zwischenzeitlich schon alle Termine vergeben",
+			},
+			{
+				name:        "Invitation past its deadline",
+				phoneNumber: "1234",
+				wantErr:     ErrNoOpenInvitation,
+			},
+			{
+				name:        "Only invitation is for ended call",
+				phoneNumber: "1231",
+				wantErr:     ErrNoOpenInvitation,
+			},
+			{
+				name:        "Unknown number",
+				phoneNumber: "9999",
+				wantErr:     ErrNoOpenInvitation,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				if err := bridge.PersonAcceptLastCall(tt.phoneNumber); !errors.Is(err, tt.wantErr) {
+					t.Errorf("Bridge.PersonAcceptLastCall() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				assertInvitationsUnchangedExcept(t, map[int]InvitationStatus{tt.wantInvitation: tt.wantStatus})
+
+				// The reply to the person is queued in the outbox
+				pending, err := bridge.store.PendingMessages(10, time.Now())
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if tt.wantMessage == "" {
+					if len(pending) != 0 {
+						t.Errorf("PendingMessages() = %v, want none", pending)
+					}
+					return
+				}
+
+				if len(pending) != 1 || pending[0].Phone != tt.phoneNumber ||
+					!strings.HasPrefix(pending[0].Body, tt.wantMessage) ||
+					pending[0].InvitationID.Int64 != int64(tt.wantInvitation) {
+					t.Errorf("PendingMessages() = %+v, want message %q for invitation %d", pending, tt.wantMessage, tt.wantInvitation)
+				}
+			})
+		}
+	})
 }
 
 func TestBridge_PersonCancelCall(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
-	}
-	type args struct {
-		phoneNumber string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
+	forEachBackend(t, func(t *testing.T) {
+
+		tests := []struct {
+			name           string
+			phoneNumber    string
+			wantInvitation int
+			wantStatus     InvitationStatus
+			wantErr        error
+		}{
+			{
+				name:           "Decline open invitation",
+				phoneNumber:    "1233",
+				wantInvitation: 5,
+				wantStatus:     InvitationDeclined,
+			},
+			{
+				name:           "Cancel accepted invitation",
+				phoneNumber:    "1231",
+				wantInvitation: 1,
+				wantStatus:     InvitationCancelled,
+			},
+			{
+				name:        "No open invitation",
+				phoneNumber: "1232",
+				wantErr:     ErrNoOpenInvitation,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				if err := bridge.PersonCancelCall(tt.phoneNumber); !errors.Is(err, tt.wantErr) {
+					t.Errorf("Bridge.PersonCancelCall() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				assertInvitationsUnchangedExcept(t, map[int]InvitationStatus{tt.wantInvitation: tt.wantStatus})
+			})
+		}
+	})
+}
+
+func TestBridge_PersonSetLanguage(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		tests := []struct {
+			name        string
+			phoneNumber string
+			lang        string
+			wantReply   string
+			wantErr     error
+		}{
+			{
+				name:        "English",
+				phoneNumber: "1232",
+				lang:        "en",
+				wantReply:   "in English",
+			},
+			{
+				name:        "Turkish",
+				phoneNumber: "1232",
+				lang:        "tr",
+				wantReply:   "Türkçe",
+			},
+			{
+				name:        "Unknown language",
+				phoneNumber: "1232",
+				lang:        "fr",
+				wantErr:     ErrUnknownLanguage,
+			},
+			{
+				name:        "Unknown number",
+				phoneNumber: "9999",
+				lang:        "en",
+				wantErr:     sql.ErrNoRows,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				if err := bridge.PersonSetLanguage(tt.phoneNumber, tt.lang); !errors.Is(err, tt.wantErr) {
+					t.Fatalf("Bridge.PersonSetLanguage() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if tt.wantErr != nil {
+					return
+				}
+
+				if got := bridge.recipient(tt.phoneNumber).MessageLanguage(); got != tt.lang {
+					t.Errorf("language of %s = %q, want %q", tt.phoneNumber, got, tt.lang)
+				}
+
+				msg, err := bridge.store.GetMessages(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg[0].Phone != tt.phoneNumber || !strings.Contains(msg[0].Body, tt.wantReply) {
+					t.Errorf("reply = %q to %s, want %q", msg[0].Body, msg[0].Phone, tt.wantReply)
+				}
+			})
+		}
+	})
+}
+
+// fixtureInvitationStatus is the status of each invitation in the fixtures
+var fixtureInvitationStatus = map[int]InvitationStatus{
+	0: InvitationAccepted,
+	1: InvitationAccepted,
+	2: InvitationRejected,
+	3: InvitationNotified,
+	4: InvitationNotified,
+	5: InvitationNotified,
+	6: InvitationNotified,
+}
+
+// assertInvitationsUnchangedExcept checks that all invitations of the
+// fixtures still have their original status, except the invitations in
+// changed, which have to have the status they are mapped to. An empty status
+// is ignored.
+func assertInvitationsUnchangedExcept(t *testing.T, changed map[int]InvitationStatus) {
+	t.Helper()
+
+	for _, phone := range []string{"1230", "1231", "1232", "1233", "1234"} {
+
+		invitations, err := bridge.store.GetInvitationsByPhone(phone)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, v := range invitations {
+			want := fixtureInvitationStatus[v.ID]
+			if status := changed[v.ID]; status != "" {
+				want = status
 			}
-			if err := b.PersonCancelCall(tt.args.phoneNumber); (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.PersonCancelCall() error = %v, wantErr %v", err, tt.wantErr)
+			if v.Status != want {
+				t.Errorf("invitation %d has status %v, want %v", v.ID, v.Status, want)
 			}
-		})
+		}
 	}
 }
 
 func TestBridge_PersonDelete(t *testing.T) {
-	type fields struct {
-		db     *sqlx.DB
-		sender *TwillioSender
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		const phone = "+4915100000001"
+		person := Person{Phone: phone, Group: 1}
+
+		if err := bridge.AddPerson(person); err != nil {
+			t.Fatal(err)
+		}
+		if err := bridge.PersonDelete(phone); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := bridge.store.GetPerson(phone); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetPerson() after PersonDelete() error = %v, want %v", err, sql.ErrNoRows)
+		}
+
+		// Only the hash of the number is kept
+		var stored int
+		if err := testDB(bridge.store).Get(&stored, "SELECT COUNT(*) FROM suppressions WHERE hash LIKE $1", "%15100000001%"); err != nil {
+			t.Fatal(err)
+		}
+		if stored != 0 {
+			t.Error("phone number stored in suppression list")
+		}
+
+		// The person can't be added again, also not in another spelling
+		if err := bridge.AddPerson(Person{Phone: "0151 00000001", Group: 1}); !errors.Is(err, ErrPersonSuppressed) {
+			t.Errorf("AddPerson() error = %v, want %v", err, ErrPersonSuppressed)
+		}
+
+		summary, err := bridge.AddPersons([]Person{person, {Phone: "+4915100000002", Group: 1}}, ImportSkip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := (ImportSummary{Inserted: 1, Suppressed: 1}); summary != want {
+			t.Errorf("AddPersons() = %+v, want %+v", summary, want)
+		}
+
+		// Imports list the person as invalid row
+		id, err := bridge.CreateImport("test.csv", "tester", 0, []byte("015100000001;1\n015100000003;1\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		bridge.RunImports(context.Background())
+		_, rows, err := bridge.GetImport(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 2 || rows[0].Error != "Rufnummer gesperrt, Löschung verlangt" || rows[1].Error != "" {
+			t.Errorf("GetImport() rows = %+v, want first row suppressed", rows)
+		}
+
+		// Lifting the suppression records the user
+		if err := bridge.LiftSuppression(phone, "admin"); err != nil {
+			t.Fatal(err)
+		}
+		if err := bridge.LiftSuppression(phone, "admin"); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("LiftSuppression() again error = %v, want %v", err, sql.ErrNoRows)
+		}
+		suppression, err := bridge.GetSuppression(phone)
+		if err != nil || suppression.Active() || suppression.LiftedBy != "admin" || !suppression.LiftedAt.Time.Equal(time.Now()) {
+			t.Errorf("GetSuppression() = %+v, %v, want lifted by admin", suppression, err)
+		}
+		if err := bridge.AddPerson(person); err != nil {
+			t.Errorf("AddPerson() after LiftSuppression() error = %v", err)
+		}
+
+		// Deleting the person again suppresses it again
+		if err := bridge.PersonDelete(phone); err != nil {
+			t.Fatal(err)
+		}
+		if err := bridge.AddPerson(person); !errors.Is(err, ErrPersonSuppressed) {
+			t.Errorf("AddPerson() error = %v, want %v", err, ErrPersonSuppressed)
+		}
+	})
+}
+
+func TestBridge_TransitionInvitation(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		type args struct {
+			id    int
+			to    InvitationStatus
+			actor string
+		}
+		tests := []struct {
+			name       string
+			args       args
+			wantStatus InvitationStatus
+			wantEvents []InvitationEvent
+			wantErr    error
+		}{
+			{
+				name:       "Allowed transition",
+				args:       args{id: 1, to: InvitationCheckedIn, actor: "admin"},
+				wantStatus: InvitationCheckedIn,
+				wantEvents: []InvitationEvent{{
+					InvitationID: 1,
+					FromStatus:   InvitationAccepted,
+					ToStatus:     InvitationCheckedIn,
+					Actor:        "admin",
+					Time:         time.Now(),
+				}},
+			},
+			{
+				name:       "Transition from final status",
+				args:       args{id: 2, to: InvitationAccepted, actor: "1232"},
+				wantStatus: InvitationRejected,
+				wantEvents: []InvitationEvent{},
+				wantErr:    ErrInvalidTransition,
+			},
+			{
+				name:       "Transition not in table",
+				args:       args{id: 0, to: InvitationExpired, actor: "system"},
+				wantStatus: InvitationAccepted,
+				wantEvents: []InvitationEvent{},
+				wantErr:    ErrInvalidTransition,
+			},
+			{
+				name:       "Invitation does not exist",
+				args:       args{id: 99, to: InvitationAccepted, actor: "system"},
+				wantEvents: []InvitationEvent{},
+				wantErr:    ErrInvitationNotFound,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				err := bridge.TransitionInvitation(tt.args.id, tt.args.to, tt.args.actor)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("Bridge.TransitionInvitation() error = %v, wantErr %v", err, tt.wantErr)
+				}
+
+				events, err := bridge.store.GetInvitationEvents(tt.args.id)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(tt.wantEvents, events, cmpopts.IgnoreFields(InvitationEvent{}, "ID")); diff != "" {
+					t.Errorf("GetInvitationEvents() mismatch (-want +got):\n%s", diff)
+				}
+
+				assertInvitationsUnchangedExcept(t, map[int]InvitationStatus{tt.args.id: tt.wantStatus})
+			})
+		}
+	})
+}
+
+func TestBridge_Close(t *testing.T) {
+
+	path := t.TempDir() + "/close.db"
+
+	store, err := NewStore("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
 	}
-	type args struct {
-		phoneNumber string
+
+	if _, err := testDB(store).Exec(
+		"INSERT INTO outbox (phone, body, status, created_at) VALUES ('1230', 'Hello', 'pending', $1)",
+		time.Now()); err != nil {
+		t.Fatal(err)
 	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
+
+	b := &Bridge{store: store, sender: &testSender{}}
+	b.scheduler = NewScheduler(time.Hour, b.runScheduled, nil)
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Bridge.Close() error = %v", err)
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bridge{
-				db:     tt.fields.db,
-				sender: tt.fields.sender,
-			}
-			if err := b.PersonDelete(tt.args.phoneNumber); (err != nil) != tt.wantErr {
-				t.Errorf("Bridge.PersonDelete() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
+
+	// Messages left in the outbox have been sent before closing
+	store, err = NewStore("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	pending, err := store.PendingMessages(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("PendingMessages() = %v, want none", pending)
 	}
 }
+
+func TestBridge_GetUndeliverablePersons(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		if _, err := testDB(bridge.store).Exec(
+			"UPDATE persons SET undelivered = CASE phone WHEN '1232' THEN 3 WHEN '1233' THEN 5 WHEN '1234' THEN 2 ELSE 0 END"); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := bridge.GetUndeliverablePersons()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := []Person{
+			{Phone: "1233", Group: 1, Undelivered: 5},
+			{Phone: "1232", Group: 1, Undelivered: 3},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("Bridge.GetUndeliverablePersons() mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
+	"math"
 	"net/url"
 	"strconv"
 	"time"
@@ -26,11 +28,90 @@ type Call struct {
 	LocPLZ     string    `db:"loc_plz"`
 	LocCity    string    `db:"loc_city"`
 	LocOpt     string    `db:"loc_opt"`
+	SeatsTaken int       `db:"seats_taken"` // Number of accepted invitations
+
+	// Minutes a person has to reply to an invitation, before it expires and
+	// the next person is invited
+	ResponseWindow int `db:"response_window"`
+
+	// Strategy for sending invitations. Overbooking is the factor of free
+	// seats persons are invited for, as not everyone replies. MaxOutstanding
+	// limits the number of invitations waiting for a reply (0 for no limit)
+	// and WaveSpacing is the minimum number of minutes between two waves.
+	Overbooking    float64      `db:"overbooking"`
+	MaxOutstanding int          `db:"max_outstanding"`
+	WaveSpacing    int          `db:"wave_spacing"`
+	LastWaveAt     sql.NullTime `db:"last_wave_at"`
+
+	// Replace characters outside of GSM-7 in the messages about the call,
+	// so they are not sent as more expensive UCS-2
+	Transliterate bool `db:"transliterate"`
 }
 
+// defaultResponseWindow is the response window in minutes suggested for new
+// calls
+const defaultResponseWindow = 60
+
+// Defaults of the invitation strategy suggested for new calls
+const (
+	defaultOverbooking    = 1.0
+	defaultMaxOutstanding = 0
+	defaultWaveSpacing    = 0
+)
+
+// InvitationDeadline returns the time an invitation for the call sent at t
+// expires
+func (c Call) InvitationDeadline(t time.Time) time.Time {
+	return t.Add(time.Duration(c.ResponseWindow) * time.Minute)
+}
+
+// NextWaveAt returns the time WaveSpacing allows the next wave of the call,
+// the zero time if waves are not spaced or the call has no free seats
+func (c Call) NextWaveAt() time.Time {
+	if !c.LastWaveAt.Valid || c.WaveSpacing <= 0 || c.SeatsTaken >= c.Capacity {
+		return time.Time{}
+	}
+	return c.LastWaveAt.Time.Add(time.Duration(c.WaveSpacing) * time.Minute)
+}
+
+// InvitationsToSend returns how many persons should be invited for the call
+// at now, given the number of invitations still waiting for a reply. Free
+// seats are multiplied by the overbooking factor, the result is capped by
+// MaxOutstanding. No invitations are sent while the last wave is less than
+// WaveSpacing minutes ago.
+func (c Call) InvitationsToSend(numOpen int, now time.Time) int {
+
+	if now.Before(c.NextWaveAt()) {
+		return 0
+	}
+
+	free := c.Capacity - c.SeatsTaken
+	if free <= 0 {
+		return 0
+	}
+
+	overbooking := c.Overbooking
+	if overbooking < 1 {
+		overbooking = 1
+	}
+
+	num := int(math.Ceil(float64(free)*overbooking)) - numOpen
+	if c.MaxOutstanding > 0 && num > c.MaxOutstanding-numOpen {
+		num = c.MaxOutstanding - numOpen
+	}
+
+	if num < 0 {
+		return 0
+	}
+	return num
+}
+
+// todayAt returns the time of the form input "15:04" today. Times are
+// entered in the zone of the vaccination centers, independent of the zone of
+// the server, see callZone.
 func todayAt(input string) (time.Time, error) {
 
-	now := time.Now()
+	now := time.Now().In(callZone)
 
 	year, month, day := now.Date()
 
@@ -40,11 +121,11 @@ func todayAt(input string) (time.Time, error) {
 	}
 
 	hour, min, _ := tmp.Clock()
-	return time.Date(year, month, day, hour, min, 0, 0, now.Location()), nil
+	return time.Date(year, month, day, hour, min, 0, 0, callZone), nil
 }
 
-// NewCall creates a new call
-func NewCall(data url.Values) (Call, []string, error) {
+// NewCall creates a new call of a center
+func NewCall(data url.Values, centerID int) (Call, []string, error) {
 
 	var errorStrings []string
 	var retError error
@@ -56,6 +137,32 @@ func NewCall(data url.Values) (Call, []string, error) {
 		retError = err
 	}
 
+	// Validate response window > 0
+	responseWindow, err := strconv.Atoi(data.Get("response_window"))
+	if err != nil || responseWindow < 1 {
+		errorStrings = append(errorStrings, "Ungültige Antwortzeit")
+		retError = err
+	}
+
+	// Validate invitation strategy
+	overbooking, err := strconv.ParseFloat(data.Get("overbooking"), 64)
+	if err != nil || overbooking < 1 {
+		errorStrings = append(errorStrings, "Ungültiger Überbuchungsfaktor")
+		retError = err
+	}
+
+	maxOutstanding, err := strconv.Atoi(data.Get("max_outstanding"))
+	if err != nil || maxOutstanding < 0 {
+		errorStrings = append(errorStrings, "Ungültige Anzahl offener Einladungen")
+		retError = err
+	}
+
+	waveSpacing, err := strconv.Atoi(data.Get("wave_spacing"))
+	if err != nil || waveSpacing < 0 {
+		errorStrings = append(errorStrings, "Ungültiger Abstand zwischen Einladungswellen")
+		retError = err
+	}
+
 	// Validate start and end times make sense
 	log.Debug("start-time: ", data.Get("start-time"))
 	log.Debug("end-time: ", data.Get("end-time"))
@@ -94,6 +201,9 @@ func NewCall(data url.Values) (Call, []string, error) {
 		retError = err
 	}
 
+	// Checkbox, only sent if checked
+	transliterate := data.Get("transliterate") != ""
+
 	if len(errorStrings) != 0 {
 		retError = errors.New("Missing input data")
 	}
@@ -104,7 +214,7 @@ func NewCall(data url.Values) (Call, []string, error) {
 
 	return Call{
 		Title:      title,
-		CenterID:   0, //TODO set centerID from contextString
+		CenterID:   centerID,
 		Capacity:   capacity,
 		TimeStart:  timeStart,
 		TimeEnd:    timeEnd,
@@ -115,6 +225,12 @@ func NewCall(data url.Values) (Call, []string, error) {
 		LocCity:    locCity,
 		LocOpt:     locOpt,
 		YoungOnly:  youngOnly,
+
+		ResponseWindow: responseWindow,
+		Overbooking:    overbooking,
+		MaxOutstanding: maxOutstanding,
+		WaveSpacing:    waveSpacing,
+		Transliterate:  transliterate,
 	}, errorStrings, retError
 }
 
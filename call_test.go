@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"net/url"
 	"reflect"
 	"testing"
@@ -8,25 +9,36 @@ import (
 )
 
 func Test_todayAt(t *testing.T) {
+
+	// Times are entered in the zone of the centers on servers in any zone.
+	// It is 2021-01-02 10:00 in Kiritimati while it is still 2021-01-01
+	// in Berlin.
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+
 	type args struct {
 		input string
 	}
 	tests := []struct {
 		name    string
+		local   string
 		args    args
 		want    time.Time
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{"Berlin", "Europe/Berlin", args{"14:00"}, time.Date(2021, 1, 1, 14, 0, 0, 0, callZone), false},
+		{"UTC", "UTC", args{"14:00"}, time.Date(2021, 1, 1, 13, 0, 0, 0, time.UTC), false},
+		{"Other day", "Pacific/Kiritimati", args{"9:5"}, time.Date(2021, 1, 1, 9, 5, 0, 0, callZone), false},
+		{"Invalid", "UTC", args{"14 Uhr"}, time.Time{}, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			time.Local = mustLoadLocation(tt.local)
 			got, err := todayAt(tt.args.input)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("todayAt() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
-			if !reflect.DeepEqual(got, tt.want) {
+			if !tt.wantErr && !got.Equal(tt.want) {
 				t.Errorf("todayAt() = %v, want %v", got, tt.want)
 			}
 		})
@@ -35,7 +47,8 @@ func Test_todayAt(t *testing.T) {
 
 func TestNewCall(t *testing.T) {
 	type args struct {
-		data url.Values
+		data     url.Values
+		centerID int
 	}
 	tests := []struct {
 		name    string
@@ -48,7 +61,7 @@ func TestNewCall(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, err := NewCall(tt.args.data)
+			got, got1, err := NewCall(tt.args.data, tt.args.centerID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("NewCall() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -62,3 +75,84 @@ func TestNewCall(t *testing.T) {
 		})
 	}
 }
+
+func TestCall_InvitationsToSend(t *testing.T) {
+	now := time.Date(2021, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	type args struct {
+		numOpen int
+		now     time.Time
+	}
+	tests := []struct {
+		name string
+		call Call
+		args args
+		want int
+	}{
+		{
+			name: "Free seats without overbooking",
+			call: Call{Capacity: 5, SeatsTaken: 1, Overbooking: 1},
+			args: args{numOpen: 1, now: now},
+			want: 3,
+		},
+		{
+			name: "Overbooking is rounded up",
+			call: Call{Capacity: 5, SeatsTaken: 2, Overbooking: 1.5},
+			args: args{numOpen: 1, now: now},
+			want: 4,
+		},
+		{
+			name: "Overbooking below 1 is ignored",
+			call: Call{Capacity: 3, Overbooking: 0},
+			args: args{numOpen: 0, now: now},
+			want: 3,
+		},
+		{
+			name: "Capped by max outstanding",
+			call: Call{Capacity: 10, Overbooking: 2, MaxOutstanding: 8},
+			args: args{numOpen: 3, now: now},
+			want: 5,
+		},
+		{
+			name: "Max outstanding already reached",
+			call: Call{Capacity: 10, Overbooking: 2, MaxOutstanding: 8},
+			args: args{numOpen: 9, now: now},
+			want: 0,
+		},
+		{
+			name: "Call is full",
+			call: Call{Capacity: 2, SeatsTaken: 3, Overbooking: 2},
+			args: args{numOpen: 0, now: now},
+			want: 0,
+		},
+		{
+			name: "Last wave within spacing",
+			call: Call{
+				Capacity:    5,
+				Overbooking: 1,
+				WaveSpacing: 30,
+				LastWaveAt:  sql.NullTime{Time: now.Add(-10 * time.Minute), Valid: true},
+			},
+			args: args{numOpen: 0, now: now},
+			want: 0,
+		},
+		{
+			name: "Last wave before spacing",
+			call: Call{
+				Capacity:    5,
+				Overbooking: 1,
+				WaveSpacing: 30,
+				LastWaveAt:  sql.NullTime{Time: now.Add(-30 * time.Minute), Valid: true},
+			},
+			args: args{numOpen: 0, now: now},
+			want: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.call.InvitationsToSend(tt.args.numOpen, tt.args.now); got != tt.want {
+				t.Errorf("Call.InvitationsToSend() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
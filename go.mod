@@ -1,6 +1,6 @@
 module github.com/impfbruecke/backend-go
 
-go 1.15
+go 1.16
 
 require (
 	bou.ke/monkey v1.0.2
@@ -10,7 +10,8 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/sessions v1.2.1
 	github.com/jmoiron/sqlx v1.3.1
-	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/sirupsen/logrus v1.7.0
 	github.com/ttacon/builder v0.0.0-20170518171403-c099f663e1c2 // indirect
 	github.com/ttacon/libphonenumber v1.1.0
@@ -1,70 +1,288 @@
 package main
 
 import (
-	log "github.com/sirupsen/logrus"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
 
-	"encoding/json"
 	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
 )
 
+// apiVersion is the version of the request and response schema of handlerAPI.
+// Requests may state the version they were written for, other versions are
+// rejected.
+const apiVersion = 1
+
+// Error codes of handlerAPI
+const (
+	apiErrInvalidRequest     = "invalid_request"
+	apiErrInvalidNumber      = "invalid_number"
+	apiErrUnsupportedVersion = "unsupported_version"
+	apiErrUnknownEndpoint    = "unknown_endpoint"
+	apiErrMethodNotAllowed   = "method_not_allowed"
+	apiErrNoOpenInvitation   = "no_open_invitation"
+	apiErrInternal           = "internal_error"
+)
+
+// apiRequest is the request body of handlerAPI, either as JSON or
+// form-encoded with the same field names. The phone number of the sender is
+// read from "from", "number" is accepted for older clients.
+type apiRequest struct {
+	Version int    `json:"version"`
+	From    string `json:"from"`
+	Number  string `json:"number"`
+}
+
+// apiResponse is the response body of handlerAPI. Error is only set if the
+// request failed, Action and Number only if it succeeded.
+type apiResponse struct {
+	Version int       `json:"version"`
+	Action  string    `json:"action,omitempty"`
+	Number  string    `json:"number,omitempty"`
+	Error   *apiError `json:"error,omitempty"`
+}
+
+// apiError describes why a request to handlerAPI failed. Code is one of the
+// apiErr* constants, Message is meant for humans.
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// apiActions are the endpoints of handlerAPI
+var apiActions = map[string]func(b *Bridge, phoneNumber string) error{
+	"ja":       (*Bridge).PersonAcceptLastCall,
+	"storno":   (*Bridge).PersonCancelCall,
+	"loeschen": (*Bridge).PersonDelete,
+}
+
+// handlerAPI handles the replies of persons routed by Twilio Studio to
+// /api/ja, /api/storno and /api/loeschen. The sender is read from the request,
+// see apiRequest, and normalized to E.164. The response is an apiResponse.
 func handlerAPI(w http.ResponseWriter, r *http.Request) {
 
-	if r.Method == http.MethodPost {
+	endpoint := mux.Vars(r)["endpoint"]
+
+	if r.Method != http.MethodPost {
+		writeAPIError(w, http.StatusMethodNotAllowed, apiErrMethodNotAllowed, "Only POST is allowed")
+		return
+	}
+
+	action, ok := apiActions[endpoint]
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, apiErrUnknownEndpoint, "Unknown endpoint: "+endpoint)
+		return
+	}
+
+	req, err := decodeAPIRequest(r)
+	if err != nil {
+		log.Warn("Invalid request to API: ", err)
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "Invalid request body")
+		return
+	}
 
+	if req.Version != 0 && req.Version != apiVersion {
+		writeAPIError(w, http.StatusBadRequest, apiErrUnsupportedVersion,
+			fmt.Sprintf("Unsupported version %d, supported is %d", req.Version, apiVersion))
+		return
+	}
+
+	number := req.From
+	if number == "" {
+		number = req.Number
+	}
+	if number == "" {
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidRequest, "Missing field: from")
+		return
+	}
+
+	phoneNumber, err := normalizePhone(number)
+	if err != nil {
+		log.Warnf("Invalid number %q in request to API", number)
+		writeAPIError(w, http.StatusBadRequest, apiErrInvalidNumber, "Invalid phone number: "+number)
+		return
+	}
+
+	err = action(bridge, phoneNumber)
+
+	// Replies without an invitation they could refer to are not an error of
+	// the application, tell the caller
+	switch {
+	case errors.Is(err, ErrNoOpenInvitation):
+		log.Debugf("No open invitation for number %s\n", phoneNumber)
+		writeAPIError(w, http.StatusNotFound, apiErrNoOpenInvitation, "No open invitation for this number")
+	case err != nil:
+		log.Error(err)
+		writeAPIError(w, http.StatusInternalServerError, apiErrInternal, "Internal error")
+	default:
+		writeAPIResponse(w, http.StatusOK, apiResponse{Action: endpoint, Number: phoneNumber})
+	}
+}
+
+// decodeAPIRequest reads the request body of handlerAPI. Form-encoded bodies
+// are read as such, everything else as JSON.
+func decodeAPIRequest(r *http.Request) (apiRequest, error) {
+
+	var req apiRequest
+
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
 		if err := r.ParseForm(); err != nil {
-			log.Error(err)
-			return
+			return req, err
 		}
-
-		for key, value := range r.Form {
-			log.Debugf("%s = %s\n", key, value)
+		req.From = r.PostForm.Get("from")
+		req.Number = r.PostForm.Get("number")
+		if v := r.PostForm.Get("version"); v != "" {
+			version, err := strconv.Atoi(v)
+			if err != nil {
+				return req, fmt.Errorf("invalid version %q", v)
+			}
+			req.Version = version
 		}
+		return req, nil
+	}
 
-		decoder := json.NewDecoder(r.Body)
-		var t map[string]string
-		err := decoder.Decode(&t)
-		if err != nil {
-			log.Error(err)
-			return
-		}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	return req, err
+}
 
-		header := http.StatusOK
-
-		if phoneNumber, ok := t["number"]; ok {
-			switch mux.Vars(r)["endpoint"] {
-			case "ja":
-				if err := bridge.PersonAcceptLastCall(phoneNumber); err != nil {
-					log.Error(err)
-					header = http.StatusBadRequest
-				}
-			case "storno":
-				if err := bridge.PersonCancelCall(phoneNumber); err != nil {
-					log.Error(err)
-					header = http.StatusBadRequest
-				}
-			case "loeschen":
-				if err := bridge.PersonDelete(phoneNumber); err != nil {
-					log.Error(err)
-					header = http.StatusBadRequest
-				}
-			default:
-				log.Debug("Invalid request to API recieved")
-				header = http.StatusBadRequest
-				if _, err := io.WriteString(w, "Invalid request"); err != nil {
-					log.Error(err)
-				}
-			}
+// writeAPIError writes an apiResponse with the given error
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIResponse(w, status, apiResponse{Error: &apiError{Code: code, Message: message}})
+}
+
+// writeAPIResponse writes res as JSON with the given status code
+func writeAPIResponse(w http.ResponseWriter, status int, res apiResponse) {
+	res.Version = apiVersion
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Error(err)
+	}
+}
+
+// handlerStatusCallback receives delivery receipts of the SMS provider in the
+// format of Twilio status callbacks. The form-encoded request contains the ID
+// of the message in MessageSid, its status in MessageStatus and an optional
+// ErrorCode.
+func handlerStatusCallback(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		log.Warn(err)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Older callbacks use the Sms* names
+	providerID := r.PostForm.Get("MessageSid")
+	if providerID == "" {
+		providerID = r.PostForm.Get("SmsSid")
+	}
+
+	status := r.PostForm.Get("MessageStatus")
+	if status == "" {
+		status = r.PostForm.Get("SmsStatus")
+	}
+
+	if providerID == "" {
+		log.Warnf("Invalid status callback without message ID with status %q", status)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	// Twilio reports more statuses than are tracked, e.g. accepted, scheduled
+	// or read. They are acknowledged, so Twilio doesn't retry them.
+	if !ValidDeliveryStatus(status) {
+		log.Debugf("Ignoring status %q of message %s", status, providerID)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
 
-			w.WriteHeader(header)
+	err := bridge.UpdateDeliveryStatus(providerID, status, r.PostForm.Get("ErrorCode"))
+	switch {
+	case errors.Is(err, ErrMessageNotFound):
+		log.Warnf("Status callback for unknown message %s", providerID)
+		http.Error(w, "Unknown message", http.StatusNotFound)
+	case err != nil:
+		log.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+	default:
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// handlerInbound receives all SMS persons send. Twilio posts the sender in
+// From and the text in Body, form-encoded. JSON requests with the fields
+// "from" and "body" are accepted as well, e.g. from a Studio flow. The text is
+// parsed with ParseKeyword, so a single endpoint handles all replies.
+//
+// Replies to the person are sent through the outbox, the response is an
+// empty TwiML document for form requests and the recognized action for JSON
+// requests.
+func handlerInbound(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Invalid request", http.StatusMethodNotAllowed)
+		return
+	}
+
+	isJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/json")
+
+	var from, body string
+	if isJSON {
+		var t struct {
+			From string `json:"from"`
+			Body string `json:"body"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+			log.Warn(err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
 			return
 		}
-
+		from, body = t.From, t.Body
 	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		if _, err := io.WriteString(w, "Invalid request"); err != nil {
+		if err := r.ParseForm(); err != nil {
+			log.Warn(err)
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		from, body = r.PostForm.Get("From"), r.PostForm.Get("Body")
+	}
+
+	phone, err := normalizePhone(from)
+	if err != nil {
+		log.Warnf("Inbound message from invalid number %q", from)
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	action, err := bridge.HandleInboundMessage(phone, body)
+	if err != nil {
+		log.Error(err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(map[string]Action{"action": action}); err != nil {
 			log.Error(err)
 		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	if _, err := io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?><Response></Response>`); err != nil {
+		log.Error(err)
 	}
 }
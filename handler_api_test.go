@@ -0,0 +1,744 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/mux"
+)
+
+// apiTestNumber is the person the tests of handlerAPI send requests for
+const apiTestNumber = "+4915100000001"
+
+// apiTest is a request to handlerAPI and its expected outcome
+type apiTest struct {
+	name        string
+	method      string
+	contentType string
+	body        string
+	invited     bool // Person has an open invitation
+	wantCode    int
+	want        apiResponse
+}
+
+// runAPITests sends the requests of tests to the endpoint and checks the
+// responses. check is called after each request to check the database.
+func runAPITests(t *testing.T, endpoint string, tests []apiTest, check func(t *testing.T, tt apiTest)) {
+	forEachBackend(t, func(t *testing.T) {
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				if err := bridge.AddPerson(Person{Phone: apiTestNumber, Group: 1}); err != nil {
+					t.Fatal(err)
+				}
+				if tt.invited {
+					messages := []OutboxMessage{{Phone: apiTestNumber, Body: "Invitation"}}
+					if err := bridge.store.AddInvitations(2, 2, messages, time.Now(), time.Now().Add(time.Hour)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				method := tt.method
+				if method == "" {
+					method = http.MethodPost
+				}
+
+				router := mux.NewRouter()
+				router.HandleFunc("/api/{endpoint}", handlerAPI)
+
+				req := httptest.NewRequest(method, "/api/"+endpoint, strings.NewReader(tt.body))
+				if tt.contentType != "" {
+					req.Header.Set("Content-Type", tt.contentType)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+
+				if w.Code != tt.wantCode {
+					t.Errorf("POST /api/%s = %d, want %d", endpoint, w.Code, tt.wantCode)
+				}
+				if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", ct)
+				}
+
+				var got apiResponse
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatalf("invalid response %q: %v", w.Body.String(), err)
+				}
+				if diff := cmp.Diff(tt.want, got); diff != "" {
+					t.Errorf("POST /api/%s response mismatch (-want +got):\n%s", endpoint, diff)
+				}
+
+				if check != nil {
+					check(t, tt)
+				}
+			})
+		}
+	})
+}
+
+// wantAPIError returns the expected response for an error
+func wantAPIError(code string, message string) apiResponse {
+	return apiResponse{Version: apiVersion, Error: &apiError{Code: code, Message: message}}
+}
+
+// apiTestInvitationStatus returns the status of the latest invitation of the
+// test person
+func apiTestInvitationStatus(t *testing.T) InvitationStatus {
+	invitations, err := bridge.store.GetInvitationsByPhone(apiTestNumber)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invitations) == 0 {
+		return ""
+	}
+	return invitations[0].Status
+}
+
+func TestHandlerAPI_Ja(t *testing.T) {
+
+	accepted := apiResponse{Version: apiVersion, Action: "ja", Number: apiTestNumber}
+
+	tests := []apiTest{
+		{
+			name:     "JSON with from",
+			body:     `{"from": "+4915100000001"}`,
+			invited:  true,
+			wantCode: http.StatusOK,
+			want:     accepted,
+		},
+		{
+			name:        "JSON with number",
+			contentType: "application/json",
+			body:        `{"number": "+4915100000001"}`,
+			invited:     true,
+			wantCode:    http.StatusOK,
+			want:        accepted,
+		},
+		{
+			name:        "JSON with version",
+			contentType: "application/json",
+			body:        `{"version": 1, "from": "+4915100000001"}`,
+			invited:     true,
+			wantCode:    http.StatusOK,
+			want:        accepted,
+		},
+		{
+			name:        "Form-encoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"from": {"+4915100000001"}}.Encode(),
+			invited:     true,
+			wantCode:    http.StatusOK,
+			want:        accepted,
+		},
+		{
+			name:        "Number without country code",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"number": {"0151 00000001"}}.Encode(),
+			invited:     true,
+			wantCode:    http.StatusOK,
+			want:        accepted,
+		},
+		{
+			name:     "No open invitation",
+			body:     `{"from": "+4915100000001"}`,
+			wantCode: http.StatusNotFound,
+			want:     wantAPIError(apiErrNoOpenInvitation, "No open invitation for this number"),
+		},
+		{
+			name:     "Missing number",
+			body:     `{"to": "+4915100000001"}`,
+			invited:  true,
+			wantCode: http.StatusBadRequest,
+			want:     wantAPIError(apiErrInvalidRequest, "Missing field: from"),
+		},
+		{
+			name:     "Invalid number",
+			body:     `{"from": "abc"}`,
+			invited:  true,
+			wantCode: http.StatusBadRequest,
+			want:     wantAPIError(apiErrInvalidNumber, "Invalid phone number: abc"),
+		},
+		{
+			name:     "Invalid JSON",
+			body:     `{"from": `,
+			invited:  true,
+			wantCode: http.StatusBadRequest,
+			want:     wantAPIError(apiErrInvalidRequest, "Invalid request body"),
+		},
+		{
+			name:     "Unsupported version",
+			body:     `{"version": 2, "from": "+4915100000001"}`,
+			invited:  true,
+			wantCode: http.StatusBadRequest,
+			want:     wantAPIError(apiErrUnsupportedVersion, "Unsupported version 2, supported is 1"),
+		},
+		{
+			name:     "Wrong method",
+			method:   http.MethodGet,
+			invited:  true,
+			wantCode: http.StatusMethodNotAllowed,
+			want:     wantAPIError(apiErrMethodNotAllowed, "Only POST is allowed"),
+		},
+	}
+
+	runAPITests(t, "ja", tests, func(t *testing.T, tt apiTest) {
+		want := InvitationStatus("")
+		switch {
+		case tt.wantCode == http.StatusOK:
+			want = InvitationAccepted
+		case tt.invited:
+			want = InvitationNotified
+		}
+		if got := apiTestInvitationStatus(t); got != want {
+			t.Errorf("invitation status = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHandlerAPI_Storno(t *testing.T) {
+
+	tests := []apiTest{
+		{
+			name:     "JSON",
+			body:     `{"from": "+4915100000001"}`,
+			invited:  true,
+			wantCode: http.StatusOK,
+			want:     apiResponse{Version: apiVersion, Action: "storno", Number: apiTestNumber},
+		},
+		{
+			name:        "Form-encoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"number": {"+4915100000001"}}.Encode(),
+			invited:     true,
+			wantCode:    http.StatusOK,
+			want:        apiResponse{Version: apiVersion, Action: "storno", Number: apiTestNumber},
+		},
+		{
+			name:     "No open invitation",
+			body:     `{"from": "+4915100000001"}`,
+			wantCode: http.StatusNotFound,
+			want:     wantAPIError(apiErrNoOpenInvitation, "No open invitation for this number"),
+		},
+		{
+			name:        "Invalid version",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"from": {"+4915100000001"}, "version": {"v1"}}.Encode(),
+			invited:     true,
+			wantCode:    http.StatusBadRequest,
+			want:        wantAPIError(apiErrInvalidRequest, "Invalid request body"),
+		},
+	}
+
+	runAPITests(t, "storno", tests, func(t *testing.T, tt apiTest) {
+		want := InvitationStatus("")
+		switch {
+		case tt.wantCode == http.StatusOK:
+			want = InvitationDeclined
+		case tt.invited:
+			want = InvitationNotified
+		}
+		if got := apiTestInvitationStatus(t); got != want {
+			t.Errorf("invitation status = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestHandlerAPI_Loeschen(t *testing.T) {
+
+	tests := []apiTest{
+		{
+			name:     "JSON",
+			body:     `{"from": "+4915100000001"}`,
+			wantCode: http.StatusOK,
+			want:     apiResponse{Version: apiVersion, Action: "loeschen", Number: apiTestNumber},
+		},
+		{
+			name:        "Form-encoded",
+			contentType: "application/x-www-form-urlencoded",
+			body:        url.Values{"from": {"015100000001"}}.Encode(),
+			wantCode:    http.StatusOK,
+			want:        apiResponse{Version: apiVersion, Action: "loeschen", Number: apiTestNumber},
+		},
+		{
+			name:     "Missing number",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+			want:     wantAPIError(apiErrInvalidRequest, "Missing field: from"),
+		},
+	}
+
+	runAPITests(t, "loeschen", tests, func(t *testing.T, tt apiTest) {
+		persons, err := bridge.GetPersons()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, p := range persons {
+			found = found || p.Phone == apiTestNumber
+		}
+		if deleted := !found; deleted != (tt.wantCode == http.StatusOK) {
+			t.Errorf("person deleted = %v, want %v", deleted, tt.wantCode == http.StatusOK)
+		}
+	})
+}
+
+func TestHandlerAPI_UnknownEndpoint(t *testing.T) {
+	runAPITests(t, "vielleicht", []apiTest{
+		{
+			name:     "Unknown endpoint",
+			body:     `{"from": "+4915100000001"}`,
+			wantCode: http.StatusNotFound,
+			want:     wantAPIError(apiErrUnknownEndpoint, "Unknown endpoint: vielleicht"),
+		},
+	}, nil)
+}
+
+func TestHandlerAPI_ConcurrentAccept(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		const numPersons = 25
+		db := testDB(bridge.store)
+
+		// Call with a single free seat and an open invitation for each
+		// person
+		var callID int
+		if err := db.Get(&callID,
+			`INSERT INTO calls (title, center_id, capacity, time_start, time_end, young_only,
+			loc_name, loc_street, loc_housenr, loc_plz, loc_city, loc_opt, seats_taken)
+			VALUES ('Last seat', 0, 2, $1, $2, false, '', '', '', '', '', '', 1) RETURNING id`,
+			time.Now().Add(time.Hour), time.Now().Add(2*time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		var persons []Person
+		var messages []OutboxMessage
+		for i := 0; i < numPersons; i++ {
+			phone := fmt.Sprintf("+4915100000%03d", i)
+			persons = append(persons, Person{Phone: phone, Group: 1})
+			messages = append(messages, OutboxMessage{Phone: phone, Body: "Invitation"})
+		}
+
+		if _, err := bridge.AddPersons(persons, ImportFail); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := bridge.store.AddInvitations(callID, 1, messages, time.Now(), time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+
+		router := mux.NewRouter()
+		router.HandleFunc("/api/{endpoint}", handlerAPI)
+
+		var wg sync.WaitGroup
+		codes := make(chan int, numPersons)
+
+		for i := 0; i < numPersons; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				body := fmt.Sprintf(`{"number": "+4915100000%03d"}`, i)
+				req := httptest.NewRequest(http.MethodPost, "/api/ja", strings.NewReader(body))
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				codes <- rec.Code
+			}(i)
+		}
+
+		wg.Wait()
+		close(codes)
+
+		for code := range codes {
+			if code != http.StatusOK {
+				t.Errorf("POST /api/ja returned %d, want %d", code, http.StatusOK)
+			}
+		}
+
+		var seatsTaken, accepted, rejected int
+		if err := db.Get(&seatsTaken, "SELECT seats_taken FROM calls WHERE id=$1", callID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Get(&accepted, "SELECT COUNT(id) FROM invitations WHERE call_id=$1 AND status='accepted'", callID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Get(&rejected, "SELECT COUNT(id) FROM invitations WHERE call_id=$1 AND status='rejected'", callID); err != nil {
+			t.Fatal(err)
+		}
+
+		if seatsTaken != 2 {
+			t.Errorf("seats_taken = %d, want 2", seatsTaken)
+		}
+		if accepted != 1 {
+			t.Errorf("accepted invitations = %d, want 1", accepted)
+		}
+		if rejected != numPersons-1 {
+			t.Errorf("rejected invitations = %d, want %d", rejected, numPersons-1)
+		}
+	})
+}
+
+func TestHandlerStatusCallback(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		// callback is a status callback and the response code expected
+		type callback struct {
+			form     url.Values
+			wantCode int
+		}
+
+		tests := []struct {
+			name            string
+			method          string
+			callbacks       []callback
+			wantDelivery    string
+			wantInvitation  InvitationStatus
+			wantUndelivered int
+		}{
+			{
+				name: "Message delivered",
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"sent"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}}, http.StatusOK},
+				},
+				wantDelivery:    DeliveryDelivered,
+				wantInvitation:  InvitationNotified,
+				wantUndelivered: 0,
+			},
+			{
+				name: "Message undelivered",
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"undelivered"}, "ErrorCode": {"30003"}}, http.StatusOK},
+				},
+				wantDelivery:    DeliveryUndelivered,
+				wantInvitation:  InvitationExpired,
+				wantUndelivered: 2,
+			},
+			{
+				name: "Legacy field names",
+				callbacks: []callback{
+					{url.Values{"SmsSid": {"SM1"}, "SmsStatus": {"failed"}}, http.StatusOK},
+				},
+				wantDelivery:    DeliveryFailed,
+				wantInvitation:  InvitationExpired,
+				wantUndelivered: 2,
+			},
+			{
+				name: "Receipts out of order",
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"sent"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"undelivered"}}, http.StatusOK},
+				},
+				wantDelivery:    DeliveryDelivered,
+				wantInvitation:  InvitationNotified,
+				wantUndelivered: 0,
+			},
+			{
+				name: "Duplicate receipt counted once",
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"failed"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"failed"}}, http.StatusOK},
+				},
+				wantDelivery:    DeliveryFailed,
+				wantInvitation:  InvitationExpired,
+				wantUndelivered: 2,
+			},
+			{
+				name: "Unknown message",
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM2"}, "MessageStatus": {"delivered"}}, http.StatusNotFound},
+				},
+				wantInvitation:  InvitationNotified,
+				wantUndelivered: 1,
+			},
+			{
+				name: "Untracked status ignored",
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"accepted"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"scheduled"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"read"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"canceled"}}, http.StatusOK},
+					{url.Values{"MessageSid": {"SM1"}}, http.StatusOK},
+				},
+				wantInvitation:  InvitationNotified,
+				wantUndelivered: 1,
+			},
+			{
+				name: "Missing message ID",
+				callbacks: []callback{
+					{url.Values{"MessageStatus": {"delivered"}}, http.StatusBadRequest},
+				},
+				wantInvitation:  InvitationNotified,
+				wantUndelivered: 1,
+			},
+			{
+				name:   "Wrong method",
+				method: http.MethodGet,
+				callbacks: []callback{
+					{url.Values{"MessageSid": {"SM1"}, "MessageStatus": {"delivered"}}, http.StatusMethodNotAllowed},
+				},
+				wantInvitation:  InvitationNotified,
+				wantUndelivered: 1,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				// Invitation for 1235, which the provider accepted as
+				// SM1. One message to the person failed before
+				messages := []OutboxMessage{{Phone: "1235", Body: "Invitation"}}
+				if err := bridge.store.AddInvitations(2, 2, messages, time.Now(), time.Now().Add(time.Hour)); err != nil {
+					t.Fatal(err)
+				}
+
+				db := testDB(bridge.store)
+				if _, err := db.Exec("UPDATE outbox SET status = 'sent', provider_id = 'SM1'"); err != nil {
+					t.Fatal(err)
+				}
+				if _, err := db.Exec("UPDATE persons SET undelivered = 1 WHERE phone = '1235'"); err != nil {
+					t.Fatal(err)
+				}
+
+				method := tt.method
+				if method == "" {
+					method = http.MethodPost
+				}
+
+				for _, cb := range tt.callbacks {
+					req := httptest.NewRequest(method, "/api/status", strings.NewReader(cb.form.Encode()))
+					req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+					w := httptest.NewRecorder()
+					handlerStatusCallback(w, req)
+
+					if w.Code != cb.wantCode {
+						t.Errorf("handlerStatusCallback(%v) = %d, want %d", cb.form, w.Code, cb.wantCode)
+					}
+				}
+
+				msg, err := bridge.store.GetMessages(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if msg[0].DeliveryStatus != tt.wantDelivery {
+					t.Errorf("delivery status = %q, want %q", msg[0].DeliveryStatus, tt.wantDelivery)
+				}
+
+				invitations, err := bridge.store.GetInvitationsByPhone("1235")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if invitations[0].Status != tt.wantInvitation {
+					t.Errorf("invitation status = %q, want %q", invitations[0].Status, tt.wantInvitation)
+				}
+
+				var undelivered int
+				if err := db.Get(&undelivered, "SELECT undelivered FROM persons WHERE phone = '1235'"); err != nil {
+					t.Fatal(err)
+				}
+				if undelivered != tt.wantUndelivered {
+					t.Errorf("undelivered = %d, want %d", undelivered, tt.wantUndelivered)
+				}
+			})
+		}
+	})
+}
+
+func TestHandlerInbound(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		const phone = "+4915100000001"
+
+		tests := []struct {
+			name           string
+			method         string
+			contentType    string
+			body           string
+			invited        bool
+			wantCode       int
+			wantResponse   string
+			wantAction     Action
+			wantResult     string
+			wantInvitation InvitationStatus
+			wantReply      string
+		}{
+			{
+				name:           "Accept",
+				contentType:    "application/x-www-form-urlencoded",
+				body:           url.Values{"From": {phone}, "Body": {"Ja bitte!"}}.Encode(),
+				invited:        true,
+				wantCode:       http.StatusOK,
+				wantResponse:   "<Response></Response>",
+				wantAction:     ActionAccept,
+				wantResult:     InboundOK,
+				wantInvitation: InvitationAccepted,
+				wantReply:      "Termin bestätigt",
+			},
+			{
+				name:           "Cancel as JSON",
+				contentType:    "application/json",
+				body:           `{"from": "015100000001", "body": "Stornp bitte"}`,
+				invited:        true,
+				wantCode:       http.StatusOK,
+				wantResponse:   `{"action":"cancel"}`,
+				wantAction:     ActionCancel,
+				wantResult:     InboundOK,
+				wantInvitation: InvitationDeclined,
+			},
+			{
+				name:         "Delete",
+				contentType:  "application/x-www-form-urlencoded",
+				body:         url.Values{"From": {phone}, "Body": {"Loschen"}}.Encode(),
+				wantCode:     http.StatusOK,
+				wantResponse: "<Response></Response>",
+				wantAction:   ActionDelete,
+				wantResult:   InboundOK,
+				wantReply:    "erfolgreich entfernt",
+			},
+			{
+				name:         "Language",
+				contentType:  "application/x-www-form-urlencoded",
+				body:         url.Values{"From": {phone}, "Body": {"Sprache Englisch"}}.Encode(),
+				wantCode:     http.StatusOK,
+				wantResponse: "<Response></Response>",
+				wantAction:   ActionLanguage,
+				wantResult:   InboundOK,
+				wantReply:    "in English",
+			},
+			{
+				name:         "Unknown language",
+				contentType:  "application/x-www-form-urlencoded",
+				body:         url.Values{"From": {phone}, "Body": {"Sprache Klingonisch"}}.Encode(),
+				wantCode:     http.StatusOK,
+				wantResponse: "<Response></Response>",
+				wantAction:   ActionLanguage,
+				wantResult:   InboundHelp,
+				wantReply:    "nicht verstanden",
+			},
+			{
+				name:         "Accept without invitation",
+				contentType:  "application/x-www-form-urlencoded",
+				body:         url.Values{"From": {phone}, "Body": {"JA"}}.Encode(),
+				wantCode:     http.StatusOK,
+				wantResponse: "<Response></Response>",
+				wantAction:   ActionAccept,
+				wantResult:   InboundNoInvitation,
+				wantReply:    "keine offene Einladung",
+			},
+			{
+				name:           "Unknown",
+				contentType:    "application/x-www-form-urlencoded",
+				body:           url.Values{"From": {phone}, "Body": {"Wann genau?"}}.Encode(),
+				invited:        true,
+				wantCode:       http.StatusOK,
+				wantResponse:   "<Response></Response>",
+				wantAction:     ActionUnknown,
+				wantResult:     InboundHelp,
+				wantInvitation: InvitationNotified,
+				wantReply:      "nicht verstanden",
+			},
+			{
+				name:        "Invalid number",
+				contentType: "application/x-www-form-urlencoded",
+				body:        url.Values{"From": {"abc"}, "Body": {"JA"}}.Encode(),
+				wantCode:    http.StatusBadRequest,
+			},
+			{
+				name:        "Invalid JSON",
+				contentType: "application/json",
+				body:        `{"from": `,
+				wantCode:    http.StatusBadRequest,
+			},
+			{
+				name:     "Wrong method",
+				method:   http.MethodGet,
+				wantCode: http.StatusMethodNotAllowed,
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				prepareTestDatabase()
+
+				db := testDB(bridge.store)
+				if _, err := db.Exec("DELETE FROM inbound_messages"); err != nil {
+					t.Fatal(err)
+				}
+				if err := bridge.AddPerson(Person{Phone: phone, Group: 1}); err != nil {
+					t.Fatal(err)
+				}
+				if tt.invited {
+					messages := []OutboxMessage{{Phone: phone, Body: "Invitation"}}
+					if err := bridge.store.AddInvitations(2, 2, messages, time.Now(), time.Now().Add(time.Hour)); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				method := tt.method
+				if method == "" {
+					method = http.MethodPost
+				}
+
+				req := httptest.NewRequest(method, "/api/sms", strings.NewReader(tt.body))
+				req.Header.Set("Content-Type", tt.contentType)
+				w := httptest.NewRecorder()
+				handlerInbound(w, req)
+
+				if w.Code != tt.wantCode {
+					t.Fatalf("handlerInbound() = %d, want %d", w.Code, tt.wantCode)
+				}
+				if !strings.Contains(w.Body.String(), tt.wantResponse) {
+					t.Errorf("handlerInbound() response = %q, want %q", w.Body.String(), tt.wantResponse)
+				}
+
+				logged, err := bridge.store.GetInboundMessages(10)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.wantCode != http.StatusOK {
+					if len(logged) != 0 {
+						t.Errorf("logged %d inbound messages for invalid request", len(logged))
+					}
+					return
+				}
+				if len(logged) != 1 {
+					t.Fatalf("logged %d inbound messages, want 1", len(logged))
+				}
+				if logged[0].Phone != phone || logged[0].Action != tt.wantAction || logged[0].Result != tt.wantResult {
+					t.Errorf("logged %+v, want action %s and result %s", logged[0], tt.wantAction, tt.wantResult)
+				}
+
+				if tt.wantInvitation != "" {
+					invitations, err := bridge.store.GetInvitationsByPhone(phone)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if invitations[0].Status != tt.wantInvitation {
+						t.Errorf("invitation status = %q, want %q", invitations[0].Status, tt.wantInvitation)
+					}
+				}
+
+				msg, err := bridge.store.GetMessages(1)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if tt.wantReply == "" {
+					if msg[0].Phone == phone && msg[0].Body != "Invitation" {
+						t.Errorf("unexpected reply %q", msg[0].Body)
+					}
+				} else if msg[0].Phone != phone || !strings.Contains(msg[0].Body, tt.wantReply) {
+					t.Errorf("reply = %q to %s, want %q", msg[0].Body, msg[0].Phone, tt.wantReply)
+				}
+			})
+		}
+	})
+}
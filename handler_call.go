@@ -1,8 +1,10 @@
 package main
 
 import (
+	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -19,9 +21,11 @@ func handlerSendCall(w http.ResponseWriter, r *http.Request) {
 	// we just set a predefined value of 23:00 - 23:59. This will probably
 	// not happen during normal office hours, but developing at night
 	// sometimes causes unxepeted errors ;)
+	//
+	// Times are entered in the zone of the centers, see todayAt.
 
-	startHour, startMin, _ := time.Now().Clock()
-	endHour, endMin, _ := time.Now().Add(3 * time.Hour).Clock()
+	startHour, startMin, _ := time.Now().In(callZone).Clock()
+	endHour, endMin, _ := time.Now().In(callZone).Add(3 * time.Hour).Clock()
 
 	if endHour < startHour {
 		startMin = 0
@@ -32,6 +36,7 @@ func handlerSendCall(w http.ResponseWriter, r *http.Request) {
 
 	tData := TmplData{
 		CurrentUser:           contextString(contextKeyCurrentUser, r),
+		Lang:                  uiLanguage(r),
 		DefaultTitle:          "IZ Duisburg",                 // TODO add collumn to users table
 		DefaultCapacity:       "10",                          // TODO add collumn to users table
 		DefaultLocationName:   "Impfzentrum Duisburg am TAM", // TODO add collumn to users table
@@ -43,6 +48,12 @@ func handlerSendCall(w http.ResponseWriter, r *http.Request) {
 		DefaultStartMinute:    strconv.Itoa(startMin),
 		DefaultEndHour:        strconv.Itoa(endHour),
 		DefaultEndMinute:      strconv.Itoa(endMin),
+		DefaultStartTime:      fmt.Sprintf("%02d:%02d", startHour, startMin),
+		DefaultEndTime:        fmt.Sprintf("%02d:%02d", endHour, endMin),
+		DefaultResponseWindow: strconv.Itoa(defaultResponseWindow),
+		DefaultOverbooking:    strconv.FormatFloat(defaultOverbooking, 'f', 1, 64),
+		DefaultMaxOutstanding: strconv.Itoa(defaultMaxOutstanding),
+		DefaultWaveSpacing:    strconv.Itoa(defaultWaveSpacing),
 	}
 
 	if r.Method == http.MethodGet {
@@ -61,7 +72,10 @@ func handlerSendCall(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		call, errStrings, err := NewCall(r.Form)
+		// Show the entered values again if the call is not created
+		callFormDefaults(&tData, r.Form)
+
+		call, errStrings, err := NewCall(r.Form, currentCenter(r))
 		if err != nil {
 			log.Warn(err)
 			tData.AppMessages = errStrings
@@ -71,6 +85,33 @@ func handlerSendCall(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		// Warn if the invitation gets too long, unless the warning has
+		// been confirmed
+		preview, info, err := bridge.InvitationPreview(call)
+		if err != nil {
+			log.Error(err)
+			tData.AppMessages = []string{"Einladung konnte nicht erstellt werden, Ruf nicht gespeichert"}
+			if err := templates.ExecuteTemplate(w, "newCall.html", tData); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
+		if info.Segments > smsSegmentBudget && r.Form.Get("confirm_length") == "" {
+			tData.InvitationPreview = preview
+			tData.InvitationInfo = info
+			tData.SegmentBudget = smsSegmentBudget
+			if info.Encoding == EncodingUCS2 {
+				tData.TransliteratedInfo = analyzeSMS(transliterateGSM(preview))
+			}
+			tData.AppMessages = []string{fmt.Sprintf(translate(tData.Lang,
+				"Die Einladung wird als %d SMS gesendet, erlaubt sind %d. Ruf noch nicht gespeichert"), info.Segments, smsSegmentBudget)}
+			if err := templates.ExecuteTemplate(w, "newCall.html", tData); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
 		// Add call to bridge
 		if err := bridge.AddCall(call); err != nil {
 			log.Warn(err)
@@ -93,11 +134,41 @@ func handlerSendCall(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// callFormDefaults fills the new-call form with the values of a submitted form
+func callFormDefaults(tData *TmplData, form url.Values) {
+
+	fields := map[string]*string{
+		"title":           &tData.DefaultTitle,
+		"capacity":        &tData.DefaultCapacity,
+		"response_window": &tData.DefaultResponseWindow,
+		"loc_name":        &tData.DefaultLocationName,
+		"loc_street":      &tData.DefaultLocationStreet,
+		"loc_housenr":     &tData.DefaultHouseNumber,
+		"loc_plz":         &tData.DefaultPostCode,
+		"loc_city":        &tData.DefaultCity,
+		"loc_opt":         &tData.DefaultLocationOpt,
+		"start-time":      &tData.DefaultStartTime,
+		"end-time":        &tData.DefaultEndTime,
+		"overbooking":     &tData.DefaultOverbooking,
+		"max_outstanding": &tData.DefaultMaxOutstanding,
+		"wave_spacing":    &tData.DefaultWaveSpacing,
+	}
+
+	for name, field := range fields {
+		if v := form.Get(name); v != "" {
+			*field = v
+		}
+	}
+
+	tData.DefaultTransliterate = form.Get("transliterate") != ""
+}
+
 func handlerActiveCalls(w http.ResponseWriter, r *http.Request) {
 
 	templates = parseTemplates()
 	tData := TmplData{
 		CurrentUser: contextString(contextKeyCurrentUser, r),
+		Lang:        uiLanguage(r),
 	}
 
 	callID := mux.Vars(r)["id"]
@@ -133,3 +204,20 @@ func handlerActiveCalls(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 }
+
+// handlerSchedule triggers a run of the scheduler, so invitations are sent
+// without waiting for the next interval. Redirects back to the active calls.
+func handlerSchedule(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		if _, err := io.WriteString(w, "Invalid request"); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	log.Infof("Scheduler run requested by %s", contextString(contextKeyCurrentUser, r))
+	bridge.scheduler.Trigger(triggerManual)
+
+	http.Redirect(w, r, "/auth/active", http.StatusSeeOther)
+}
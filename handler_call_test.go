@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerSendCall_SegmentBudget(t *testing.T) {
+
+	form := func(locOpt string, extra url.Values) url.Values {
+		v := url.Values{
+			"title":           {"Segment test"},
+			"capacity":        {"2"},
+			"response_window": {"30"},
+			"young_only":      {"false"},
+			"start-time":      {"21:00"},
+			"end-time":        {"22:00"},
+			"loc_name":        {"Impfzentrum"},
+			"loc_street":      {"Plessingstraße"},
+			"loc_housenr":     {"20"},
+			"loc_plz":         {"47051"},
+			"loc_city":        {"Duisburg"},
+			"loc_opt":         {locOpt},
+			"overbooking":     {"1.0"},
+			"max_outstanding": {"0"},
+			"wave_spacing":    {"0"},
+		}
+		for k, vs := range extra {
+			v[k] = vs
+		}
+		return v
+	}
+
+	longOpt := "Bitte „Eingang Süd“ nutzen – " + strings.Repeat("Wegbeschreibung ", 5)
+
+	tests := []struct {
+		name        string
+		form        url.Values
+		wantCreated bool
+		want        []string
+	}{
+		{
+			name:        "Short invitation",
+			form:        form("Eingang B", nil),
+			wantCreated: true,
+			want:        []string{"Ruf erfolgreich erstellt!"},
+		},
+		{
+			name: "Too long",
+			form: form(longOpt, nil),
+			want: []string{
+				"Ruf noch nicht gespeichert",
+				"Zeichensatz UCS-2",
+				"Mit ersetzten Sonderzeichen: 2 SMS im Zeichensatz GSM-7",
+				`name="confirm_length"`,
+				"Ruf trotzdem starten",
+				"Wegbeschreibung",
+			},
+		},
+		{
+			name:        "Too long, confirmed",
+			form:        form(longOpt, url.Values{"confirm_length": {"true"}}),
+			wantCreated: true,
+			want:        []string{"Ruf erfolgreich erstellt!"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prepareTestDatabase()
+
+			defaultBudget := smsSegmentBudget
+			smsSegmentBudget = 2
+			defer func() { smsSegmentBudget = defaultBudget }()
+
+			req := httptest.NewRequest(http.MethodPost, "/auth/call", strings.NewReader(tt.form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handlerSendCall(w, req)
+
+			body := w.Body.String()
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("handlerSendCall() body does not contain %q", want)
+				}
+			}
+
+			var count int
+			if err := testDB(bridge.store).Get(&count, "SELECT COUNT(*) FROM calls WHERE title='Segment test'"); err != nil {
+				t.Fatal(err)
+			}
+			if created := count == 1; created != tt.wantCreated {
+				t.Errorf("call created = %v, want %v", created, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestHandlerSendCall_DefaultTimes(t *testing.T) {
+
+	// The form suggests times in the zone of the centers on servers in
+	// any zone. It is 21:00 in Berlin, three hours later is the next day.
+	defer func(local *time.Location) { time.Local = local }(time.Local)
+	time.Local = time.UTC
+
+	req := httptest.NewRequest(http.MethodGet, "/auth/call", nil)
+	w := httptest.NewRecorder()
+	handlerSendCall(w, req)
+
+	body := w.Body.String()
+	for _, want := range []string{`value="23:00"`, `value="23:59"`} {
+		if !strings.Contains(body, want) {
+			t.Errorf("handlerSendCall() body does not contain %q", want)
+		}
+	}
+}
+
+func TestHandlerSendCall_Center(t *testing.T) {
+
+	tests := []struct {
+		user string
+		want int
+	}{
+		{"staff", 7},
+		{"admin", 0},
+		{"unknown", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.user, func(t *testing.T) {
+			prepareTestDatabase()
+
+			form := url.Values{
+				"title":           {"Center test"},
+				"capacity":        {"2"},
+				"response_window": {"30"},
+				"young_only":      {"false"},
+				"start-time":      {"21:00"},
+				"end-time":        {"22:00"},
+				"loc_name":        {"Impfzentrum"},
+				"loc_street":      {"Plessingstraße"},
+				"loc_housenr":     {"20"},
+				"loc_plz":         {"47051"},
+				"loc_city":        {"Duisburg"},
+				"loc_opt":         {""},
+				"overbooking":     {"1.0"},
+				"max_outstanding": {"0"},
+				"wave_spacing":    {"0"},
+			}
+			req := httptest.NewRequest(http.MethodPost, "/auth/call", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req = req.WithContext(context.WithValue(req.Context(), contextKeyCurrentUser, tt.user))
+			handlerSendCall(httptest.NewRecorder(), req)
+
+			// The call gets the center of the user
+			var centers []int
+			if err := testDB(bridge.store).Select(&centers, "SELECT center_id FROM calls WHERE title='Center test'"); err != nil {
+				t.Fatal(err)
+			}
+			if len(centers) != 1 || centers[0] != tt.want {
+				t.Errorf("centers of calls = %v, want [%d]", centers, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// messagesShown is the number of messages shown on the status page
+const messagesShown = 200
+
+// handlerMessages shows the latest messages of the outbox with their delivery
+// status. POST with the ID of a failed message queues it for delivery again.
+func handlerMessages(w http.ResponseWriter, r *http.Request) {
+
+	tData := TmplData{
+		CurrentUser: contextString(contextKeyCurrentUser, r),
+		Lang:        uiLanguage(r),
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			tData.AppMessages = append(tData.AppMessages, "Ungültige Nachricht")
+			break
+		}
+
+		if err := bridge.RequeueMessage(id); err != nil {
+			log.Warn(err)
+			tData.AppMessages = append(tData.AppMessages, "Nachricht konnte nicht erneut gesendet werden")
+			break
+		}
+
+		tData.AppMessageSuccess = "Nachricht wird erneut gesendet"
+	default:
+		if _, err := io.WriteString(w, "Invalid request"); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	messages, err := bridge.GetMessages(messagesShown)
+	if err != nil {
+		log.Warn(err)
+		tData.AppMessages = append(tData.AppMessages, "Nachrichten konnten nicht geladen werden")
+	}
+	tData.Messages = messages
+
+	// Persons whose messages could not be delivered repeatedly
+	persons, err := bridge.GetUndeliverablePersons()
+	if err != nil {
+		log.Warn(err)
+	}
+	tData.Persons = persons
+
+	if err := templates.ExecuteTemplate(w, "messages.html", tData); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandlerMessages(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		if err := bridge.store.QueueMessage(OutboxMessage{Phone: "+4915100000001", Body: "Hallo"}, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := testDB(bridge.store).Exec(
+			"UPDATE outbox SET status = 'failed', attempts = 8, last_error = 'provider returned 500'"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Failed message is shown with its error and can be sent again
+		w := httptest.NewRecorder()
+		handlerMessages(w, httptest.NewRequest(http.MethodGet, "/auth/messages", nil))
+
+		body := w.Body.String()
+		for _, want := range []string{"4915100000001", "fehlgeschlagen", "provider returned 500", "Erneut senden"} {
+			if !strings.Contains(body, want) {
+				t.Errorf("handlerMessages() body does not contain %q", want)
+			}
+		}
+
+		messages, err := bridge.GetMessages(1)
+		if err != nil || len(messages) != 1 {
+			t.Fatalf("GetMessages() = %v, %v", messages, err)
+		}
+
+		form := url.Values{"id": {strconv.Itoa(messages[0].ID)}}
+		req := httptest.NewRequest(http.MethodPost, "/auth/messages", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w = httptest.NewRecorder()
+		handlerMessages(w, req)
+
+		if !strings.Contains(w.Body.String(), "Nachricht wird erneut gesendet") {
+			t.Errorf("handlerMessages() did not confirm requeue:\n%s", w.Body.String())
+		}
+
+		if got, err := bridge.GetMessages(1); err != nil || got[0].Status != MessagePending {
+			t.Errorf("message after requeue = %+v, %v, want pending", got, err)
+		}
+	})
+}
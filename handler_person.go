@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"io"
 	"net/http"
 	"strconv"
@@ -12,6 +13,8 @@ func handlerAddPerson(w http.ResponseWriter, r *http.Request) {
 
 	tData := TmplData{
 		CurrentUser: contextString(contextKeyCurrentUser, r),
+		Lang:        uiLanguage(r),
+		Languages:   languages,
 	}
 
 	// GET requests show import page
@@ -65,7 +68,7 @@ func handlerAddPerson(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		person, err := NewPerson(0, groupNum, phone, false)
+		person, err := NewPerson(currentCenter(r), groupNum, phone, false)
 		if err != nil {
 			log.Debug(err)
 			tData.AppMessages = append(tData.AppMessages, "Eingaben ungültig")
@@ -75,12 +78,25 @@ func handlerAddPerson(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if person.Language, err = parseLanguage(data.Get("language")); err != nil {
+			log.Debug(err)
+			tData.AppMessages = append(tData.AppMessages, "Ungültige Sprache")
+			if err := templates.ExecuteTemplate(w, "importPersons.html", tData); err != nil {
+				log.Error(err)
+			}
+			return
+		}
+
 		// Add call to bridge
 		if err := bridge.AddPerson(person); err != nil {
 			log.Warn(err)
 			log.Warn(person)
 
-			tData.AppMessages = append(tData.AppMessages, "Personen konnten nicht gespeichert werden. Rufnummer schon vorhanden?")
+			message := "Personen konnten nicht gespeichert werden. Rufnummer schon vorhanden?"
+			if errors.Is(err, ErrPersonSuppressed) {
+				message = "Rufnummer gesperrt, Löschung verlangt"
+			}
+			tData.AppMessages = append(tData.AppMessages, message)
 			if err := templates.ExecuteTemplate(w, "importPersons.html", tData); err != nil {
 				log.Error(err)
 			}
@@ -88,7 +104,7 @@ func handlerAddPerson(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// Send onboarding notificatino
-		if err := bridge.sender.SendMessageOnboarding(person.Phone); err != nil {
+		if err := bridge.QueueTemplateMessage(person.Phone, messageOnboarding); err != nil {
 			log.Error(err)
 		}
 
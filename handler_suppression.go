@@ -0,0 +1,66 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// handlerSuppressions shows whether a phone number ("phone") is in the
+// suppression list. POST lifts its suppression, so the person can be added
+// again. Only admins may use it, see middlewareAdmin.
+func handlerSuppressions(w http.ResponseWriter, r *http.Request) {
+
+	tData := TmplData{
+		CurrentUser: contextString(contextKeyCurrentUser, r),
+		Lang:        uiLanguage(r),
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		if _, err := io.WriteString(w, "Invalid request"); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if phone := r.FormValue("phone"); phone != "" {
+		normalized, err := normalizePhone(phone)
+		if err != nil {
+			tData.AppMessages = append(tData.AppMessages, "Ungültige Rufnummer: "+phone)
+		} else {
+			tData.Phone = normalized
+		}
+	}
+
+	if r.Method == http.MethodPost && tData.Phone != "" {
+		err := bridge.LiftSuppression(tData.Phone, tData.CurrentUser)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			tData.AppMessages = append(tData.AppMessages, "Rufnummer ist nicht gesperrt")
+		case err != nil:
+			log.Error(err)
+			tData.AppMessages = append(tData.AppMessages, "Sperre konnte nicht aufgehoben werden")
+		default:
+			tData.AppMessageSuccess = "Sperre aufgehoben"
+		}
+	}
+
+	if tData.Phone != "" {
+		suppression, err := bridge.GetSuppression(tData.Phone)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			log.Error(err)
+			tData.AppMessages = append(tData.AppMessages, "Sperre konnte nicht geladen werden")
+		default:
+			tData.Suppression = &suppression
+		}
+	}
+
+	if err := templates.ExecuteTemplate(w, "suppressions.html", tData); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlerSuppressions(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		const phone = "+4915100000001"
+		if err := bridge.PersonDelete(phone); err != nil {
+			t.Fatal(err)
+		}
+
+		handler := middlewareAdmin(http.HandlerFunc(handlerSuppressions))
+		request := func(method, user string, form url.Values) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "/auth/suppressions?"+form.Encode(), nil)
+			req = req.WithContext(context.WithValue(req.Context(), contextKeyCurrentUser, user))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			return w
+		}
+
+		tests := []struct {
+			name     string
+			method   string
+			user     string
+			phone    string
+			wantCode int
+			want     string
+		}{
+			{"No admin", http.MethodPost, "staff", phone, http.StatusForbidden, "Forbidden"},
+			{"Unknown user", http.MethodPost, "nobody", phone, http.StatusForbidden, "Forbidden"},
+			{"Invalid phone", http.MethodGet, "admin", "abc", http.StatusOK, "Ungültige Rufnummer: abc"},
+			{"Not suppressed", http.MethodGet, "admin", "015100000002", http.StatusOK, "Rufnummer ist nicht gesperrt"},
+			{"Suppressed", http.MethodGet, "admin", "0151 00000001", http.StatusOK, "Sperre aufheben"},
+			{"Lift", http.MethodPost, "admin", phone, http.StatusOK, "Sperre aufgehoben am 01.01.2021 20:00, admin"},
+			{"Lift again", http.MethodPost, "admin", phone, http.StatusOK, "Rufnummer ist nicht gesperrt"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				w := request(tt.method, tt.user, url.Values{"phone": {tt.phone}})
+				if w.Code != tt.wantCode {
+					t.Errorf("handlerSuppressions() = %d, want %d", w.Code, tt.wantCode)
+				}
+				if !strings.Contains(w.Body.String(), tt.want) {
+					t.Errorf("handlerSuppressions() does not contain %q", tt.want)
+				}
+			})
+		}
+
+		if err := bridge.AddPerson(Person{Phone: phone, Group: 1}); err != nil {
+			t.Errorf("AddPerson() after lifting the suppression error = %v", err)
+		}
+	})
+}
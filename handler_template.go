@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MessageTemplateView is a message template as shown on the templates page
+type MessageTemplateView struct {
+	Name    string
+	Title   string
+	Body    string
+	Source  string
+	Custom  bool    // Center has its own template in the database
+	Preview string  // Rendered with sampleMessageData
+	Info    SMSInfo // Encoding and length of the preview
+	Error   string
+}
+
+// handlerTemplates shows the message templates of a center in a language,
+// selected with the parameters "center" and "language". Center 0 is used for
+// all centers without templates of their own. POST with "name", "body" and
+// "action" previews ("preview"), saves ("save") or resets ("reset") a
+// template.
+func handlerTemplates(w http.ResponseWriter, r *http.Request) {
+
+	tData := TmplData{
+		CurrentUser:  contextString(contextKeyCurrentUser, r),
+		Lang:         uiLanguage(r),
+		Language:     defaultLanguage,
+		Languages:    languages,
+		Placeholders: messagePlaceholders(),
+	}
+
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		if _, err := io.WriteString(w, "Invalid request"); err != nil {
+			log.Error(err)
+		}
+		return
+	}
+
+	if c := r.FormValue("center"); c != "" {
+		center, err := strconv.Atoi(c)
+		if err != nil || center < 0 {
+			tData.AppMessages = append(tData.AppMessages, "Ungültiges Zentrum")
+		} else {
+			tData.Center = center
+		}
+	}
+
+	if l := r.FormValue("language"); l != "" {
+		if _, ok := languageNames[l]; ok {
+			tData.Language = l
+		} else {
+			tData.AppMessages = append(tData.AppMessages, "Ungültige Sprache")
+		}
+	}
+
+	// Template the request is about. Previews and invalid templates show the
+	// submitted text instead of the saved one
+	name := r.PostFormValue("name")
+	var edited *MessageTemplateView
+
+	if r.Method == http.MethodPost && len(tData.AppMessages) == 0 {
+
+		body := r.PostFormValue("body")
+
+		switch r.PostFormValue("action") {
+		case "preview":
+			edited = &MessageTemplateView{Body: body}
+			if tmpl, err := parseMessage(name, body); err != nil {
+				edited.Error = err.Error()
+			} else if edited.Preview, err = executeMessage(tmpl, sampleMessageData(tData.Language)); err != nil {
+				edited.Error = err.Error()
+			}
+		case "save":
+			err := bridge.messages.Save(MessageTemplate{
+				CenterID:  tData.Center,
+				Language:  tData.Language,
+				Name:      name,
+				Body:      body,
+				UpdatedAt: time.Now(),
+				UpdatedBy: tData.CurrentUser,
+			})
+			if err != nil {
+				log.Warn(err)
+				edited = &MessageTemplateView{Body: body, Error: err.Error()}
+				tData.AppMessages = append(tData.AppMessages, "Vorlage konnte nicht gespeichert werden")
+				break
+			}
+			log.Infof("Message template %s/%s of center %d changed by %s", tData.Language, name, tData.Center, tData.CurrentUser)
+			tData.AppMessageSuccess = "Vorlage gespeichert"
+		case "reset":
+			if err := bridge.messages.Reset(tData.Center, tData.Language, name); err != nil {
+				log.Warn(err)
+				tData.AppMessages = append(tData.AppMessages, "Vorlage konnte nicht zurückgesetzt werden")
+				break
+			}
+			log.Infof("Message template %s/%s of center %d reset by %s", tData.Language, name, tData.Center, tData.CurrentUser)
+			tData.AppMessageSuccess = "Vorlage zurückgesetzt"
+		default:
+			tData.AppMessages = append(tData.AppMessages, "Ungültige Eingaben")
+		}
+	}
+
+	for _, n := range messageNames() {
+
+		view := MessageTemplateView{
+			Name:   n,
+			Title:  messageTitles[n],
+			Custom: bridge.messages.Custom(tData.Center, tData.Language, n),
+		}
+
+		body, source, err := bridge.messages.Source(tData.Center, tData.Language, n)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		view.Body, view.Source = body, source
+
+		if edited != nil && n == name {
+			view.Body, view.Preview, view.Error = edited.Body, edited.Preview, edited.Error
+		} else if view.Preview, err = bridge.messages.Render(tData.Center, tData.Language, n, sampleMessageData(tData.Language)); err != nil {
+			view.Error = err.Error()
+		}
+
+		view.Info = analyzeSMS(view.Preview)
+		tData.MessageTemplates = append(tData.MessageTemplates, view)
+	}
+
+	if err := templates.ExecuteTemplate(w, "messageTemplates.html", tData); err != nil {
+		log.Error(err)
+	}
+}
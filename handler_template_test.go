@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHandlerTemplates(t *testing.T) {
+
+	post := func(form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, "/auth/templates", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handlerTemplates(w, req)
+		return w.Body.String()
+	}
+
+	defer func() {
+		if err := bridge.messages.Reset(3, defaultLanguage, messageReject); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	tests := []struct {
+		name    string
+		form    url.Values
+		want    []string
+		wantMsg string // Text of the reject message of center 3 afterwards
+	}{
+		{
+			name: "Show",
+			form: url.Values{"center": {"3"}},
+			want: []string{"Ruf bereits voll", "Standard", "Impfzentrum"},
+		},
+		{
+			name: "Preview",
+			form: url.Values{"center": {"3"}, "name": {messageReject}, "action": {"preview"}, "body": {"Voll in {{.LocName}}"}},
+			want: []string{"Voll in Impfzentrum"},
+		},
+		{
+			name: "Preview invalid",
+			form: url.Values{"center": {"3"}, "name": {messageAccept}, "action": {"preview"}, "body": {"Bestätigt"}},
+			want: []string{"Fehlende Platzhalter"},
+		},
+		{
+			name:    "Save",
+			form:    url.Values{"center": {"3"}, "name": {messageReject}, "action": {"save"}, "body": {"Zentrum 3 ist voll."}},
+			want:    []string{"Vorlage gespeichert", "Zentrum 3 ist voll.", "Zurücksetzen"},
+			wantMsg: "Zentrum 3 ist voll.",
+		},
+		{
+			name:    "Save invalid",
+			form:    url.Values{"center": {"3"}, "name": {messageReject}, "action": {"save"}, "body": {"{{.Foo}}"}},
+			want:    []string{"Vorlage konnte nicht gespeichert werden", "can&#39;t evaluate field Foo"},
+			wantMsg: "Zentrum 3 ist voll.",
+		},
+		{
+			name:    "Reset",
+			form:    url.Values{"center": {"3"}, "name": {messageReject}, "action": {"reset"}},
+			want:    []string{"Vorlage zurückgesetzt"},
+			wantMsg: "Leider wurden zwischenzeitlich schon alle Termine vergeben.",
+		},
+		{
+			name: "Invalid center",
+			form: url.Values{"center": {"drei"}, "name": {messageReject}, "action": {"save"}, "body": {"Voll."}},
+			want: []string{"Ungültiges Zentrum"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := post(tt.form)
+			for _, want := range tt.want {
+				if !strings.Contains(body, want) {
+					t.Errorf("handlerTemplates() body does not contain %q", want)
+				}
+			}
+
+			if tt.wantMsg != "" {
+				got, err := bridge.messages.Render(3, defaultLanguage, messageReject, MessageData{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(got, tt.wantMsg) {
+					t.Errorf("reject message of center 3 = %q, want %q", got, tt.wantMsg)
+				}
+			}
+		})
+	}
+}
@@ -1,16 +1,42 @@
 package main
 
 import (
-	"encoding/csv"
-	log "github.com/sirupsen/logrus"
+	"database/sql"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// handlerUpload imports lists of persons. Uploaded files are saved as import,
+// which is read and validated in the background. GET requests show the status
+// of an import with its preview, or the last imports without "id". The
+// preview allows to choose the sheet of spreadsheets ("action" sheet), to
+// change the mapping of the columns ("action" columns) and to import the valid
+// rows ("action" confirm) in the chosen "mode". Imports in progress can be
+// stopped ("action" cancel).
 func handlerUpload(w http.ResponseWriter, r *http.Request) {
 
-	if r.Method != http.MethodPost {
+	tData := TmplData{
+		CurrentUser: contextString(contextKeyCurrentUser, r),
+		Lang:        uiLanguage(r),
+		Languages:   languages,
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			executeImports(w, tData)
+			return
+		}
+		executeImportPreview(w, tData, id)
+		return
+	case http.MethodPost:
+	default:
 		if _, err := io.WriteString(w, "Invalid request"); err != nil {
 			log.Error(err)
 		}
@@ -18,17 +44,27 @@ func handlerUpload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse our multipart form, 10 << 20 specifies a maximum
-	// upload of 200 MB files.
-	if err := r.ParseMultipartForm(200 << 20); err != nil {
+	// upload of 200 MB files. The forms of the preview are not multipart.
+	if err := r.ParseMultipartForm(200 << 20); err != nil && !errors.Is(err, http.ErrNotMultipart) {
 		log.Warn(err)
+		tData.AppMessages = append(tData.AppMessages, "Ungültige Eingaben")
+		executeImportPersons(w, tData)
+		return
+	}
+
+	if action := r.FormValue("action"); action != "" {
+		handlerImportAction(w, r, tData, action)
 		return
 	}
-	// FormFile returns the first file for the given key `myFile`
+
+	// FormFile returns the first file for the given key `datei`
 	// it also returns the FileHeader so we can get the Filename,
 	// the Header and the size of the file
 	file, handler, err := r.FormFile("datei")
 	if err != nil {
 		log.Warn("Error Retrieving the File: ", err)
+		tData.AppMessages = append(tData.AppMessages, "Keine Datei ausgewählt")
+		executeImportPersons(w, tData)
 		return
 	}
 
@@ -38,46 +74,212 @@ func handlerUpload(w http.ResponseWriter, r *http.Request) {
 	log.Debugf("File Size: %+v\n", handler.Size)
 	log.Debugf("MIME Header: %+v\n", handler.Header)
 
-	csvReader := csv.NewReader(file)
+	// The file is read by the import worker
+	data, err := io.ReadAll(file)
+	if err != nil {
+		log.Warn(err)
+		tData.AppMessages = append(tData.AppMessages, "Datei konnte nicht gelesen werden: "+err.Error())
+		executeImportPersons(w, tData)
+		return
+	}
 
-	// List of new persons
-	var persons []Person
+	id, err := bridge.CreateImport(handler.Filename, tData.CurrentUser, currentCenter(r), data)
+	if err != nil {
+		log.Error(err)
+		tData.AppMessages = append(tData.AppMessages, "Import konnte nicht gespeichert werden")
+		executeImportPersons(w, tData)
+		return
+	}
 
-	for {
-		// Read each record from csv
-		record, err := csvReader.Read()
-		if err == io.EOF {
-			break
+	log.Infof("Import %d of %s created by %s", id, handler.Filename, tData.CurrentUser)
+	http.Redirect(w, r, "/auth/upload?id="+strconv.Itoa(id), http.StatusSeeOther)
+}
+
+// handlerImportAction changes the sheet or the mapping of the columns of an
+// import, confirms or cancels it
+func handlerImportAction(w http.ResponseWriter, r *http.Request, tData TmplData, action string) {
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		tData.AppMessages = append(tData.AppMessages, "Ungültiger Import")
+		executeImportPersons(w, tData)
+		return
+	}
+
+	switch action {
+	case "sheet":
+		sheet, err := strconv.Atoi(r.FormValue("sheet"))
+		if err == nil {
+			err = bridge.SelectImportSheet(id, sheet)
 		}
 		if err != nil {
-			log.Println(err)
-			return
+			log.Warn(err)
+			tData.AppMessages = append(tData.AppMessages, importErrorMessage(err, "Ungültiges Tabellenblatt"))
+			break
 		}
+		http.Redirect(w, r, "/auth/upload?id="+strconv.Itoa(id), http.StatusSeeOther)
+		return
 
-		// Parse the group number into an integer and return on errors
-		groupNum, err := strconv.Atoi(record[1])
-		if err != nil {
+	case "columns":
+		columns := ImportColumns{
+			Phone:    formColumn(r, "phone_column"),
+			Group:    formColumn(r, "group_column"),
+			Language: formColumn(r, "language_column"),
+		}
+		if err := bridge.MapImportColumns(id, columns, r.FormValue("header") == "true"); err != nil {
 			log.Warn(err)
-			return
+			tData.AppMessages = append(tData.AppMessages, importErrorMessage(err, "Import konnte nicht gespeichert werden"))
+			break
 		}
+		http.Redirect(w, r, "/auth/upload?id="+strconv.Itoa(id), http.StatusSeeOther)
+		return
 
-		// Try to create a new persion object from the data and return on
-		// errors
-		p, err := NewPerson(0, groupNum, record[1], false)
+	case "confirm":
+		mode, err := parseImportMode(r.FormValue("mode"))
 		if err != nil {
 			log.Warn(err)
-			return
+			tData.AppMessages = append(tData.AppMessages, "Ungültige Eingaben")
+			break
+		}
+		if err := bridge.ConfirmImport(id, mode); err != nil {
+			log.Warn(err)
+			tData.AppMessages = append(tData.AppMessages, importErrorMessage(err, "Import konnte nicht gestartet werden"))
+			break
 		}
+		log.Infof("Import %d confirmed in mode %s by %s", id, mode, tData.CurrentUser)
+		tData.AppMessageSuccess = "Import wurde gestartet"
 
-		// If everything went well until here, add the persion to the list of new persons
-		persons = append(persons, p)
+	case "cancel":
+		if err := bridge.CancelImport(id); err != nil {
+			log.Warn(err)
+			tData.AppMessages = append(tData.AppMessages, importErrorMessage(err, "Import konnte nicht abgebrochen werden"))
+			break
+		}
+		log.Infof("Import %d cancelled by %s", id, tData.CurrentUser)
+		tData.AppMessageSuccess = "Import wurde abgebrochen"
 
+	default:
+		tData.AppMessages = append(tData.AppMessages, "Ungültige Eingaben")
 	}
 
-	// Add the list of new persons to the bridge/database
-	if err := bridge.AddPersons(persons); err != nil {
+	executeImportPreview(w, tData, id)
+}
+
+// handlerUploadErrors downloads the rows of an import with errors as CSV
+func handlerUploadErrors(w http.ResponseWriter, r *http.Request) {
+
+	id, err := strconv.Atoi(r.FormValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid import", http.StatusBadRequest)
+		return
+	}
+
+	imp, rows, err := bridge.GetImport(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
 		log.Error(err)
-		// TODO show an error in the UI
+		http.Error(w, "Failed to load import", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"fehler-import-%d.csv\"", id))
+
+	// The byte order mark makes spreadsheets read the file as UTF-8
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		log.Error(err)
+		return
+	}
+	if err := writeImportReport(w, imp, rows); err != nil {
+		log.Error(err)
+	}
+}
+
+// formColumn returns the column of a file chosen in a form, -1 for none
+func formColumn(r *http.Request, key string) int {
+	column, err := strconv.Atoi(r.FormValue(key))
+	if err != nil || column < 0 {
+		return -1
 	}
+	return column
+}
+
+// importErrorMessage returns the message shown for errors of imports, other
+// for unexpected errors
+func importErrorMessage(err error, other string) string {
+	switch {
+	case errors.Is(err, ErrImportDone):
+		return "Import wurde bereits bestätigt"
+	case errors.Is(err, sql.ErrNoRows):
+		return "Ungültiger Import"
+	default:
+		return other
+	}
+}
+
+// executeImportPreview shows the preview of an import
+func executeImportPreview(w http.ResponseWriter, tData TmplData, id int) {
 
+	preview, err := bridge.GetImportPreview(id)
+	if err != nil {
+		log.Warn(err)
+		tData.AppMessages = append(tData.AppMessages, importErrorMessage(err, "Import konnte nicht geladen werden"))
+		executeImportPersons(w, tData)
+		return
+	}
+
+	// The status page reloads itself while the import is in progress
+	tData.Import = preview
+	if preview.Status.Active() {
+		tData.Refresh = importRefreshSeconds
+	}
+	if err := templates.ExecuteTemplate(w, "importPreview.html", tData); err != nil {
+		log.Error(err)
+	}
+}
+
+// importRefreshSeconds is the interval the status page of an import in
+// progress reloads in
+const importRefreshSeconds = 5
+
+// importListLimit is the number of imports listed
+const importListLimit = 50
+
+// executeImports shows the last imports with their status
+func executeImports(w http.ResponseWriter, tData TmplData) {
+
+	imports, err := bridge.GetImports(importListLimit)
+	if err != nil {
+		log.Warn(err)
+		tData.AppMessages = append(tData.AppMessages, "Importe konnten nicht geladen werden")
+	}
+
+	for _, imp := range imports {
+		if imp.Status.Active() {
+			tData.Refresh = importRefreshSeconds
+		}
+	}
+
+	tData.Imports = imports
+	if err := templates.ExecuteTemplate(w, "imports.html", tData); err != nil {
+		log.Error(err)
+	}
+}
+
+// executeImportPersons shows the page for adding persons with the list of all
+// persons
+func executeImportPersons(w http.ResponseWriter, tData TmplData) {
+
+	persons, err := bridge.GetPersons()
+	if err != nil {
+		log.Warn(err)
+	}
+
+	tData.Persons = persons
+	if err := templates.ExecuteTemplate(w, "importPersons.html", tData); err != nil {
+		log.Error(err)
+	}
 }
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// uploadFile posts a file to handlerUpload, lets the worker read it and
+// returns the ID of the created import
+func uploadFile(t *testing.T, path string) int {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("datei", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(part, f); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/auth/upload", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	w := httptest.NewRecorder()
+	handlerUpload(w, req)
+
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("handlerUpload() = %d, want %d: %s", w.Code, http.StatusSeeOther, w.Body.String())
+	}
+
+	location := w.Header().Get("Location")
+	id, err := strconv.Atoi(strings.TrimPrefix(location, "/auth/upload?id="))
+	if err != nil {
+		t.Fatalf("handlerUpload() redirects to %q", location)
+	}
+
+	bridge.RunImports(context.Background())
+	return id
+}
+
+func TestHandlerUpload(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		get := func(path string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			handlerUpload(w, httptest.NewRequest(http.MethodGet, path, nil))
+			return w
+		}
+		post := func(form url.Values) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, "/auth/upload", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			handlerUpload(w, req)
+			return w
+		}
+		assertContains := func(t *testing.T, body string, want ...string) {
+			t.Helper()
+			for _, s := range want {
+				if !strings.Contains(body, s) {
+					t.Errorf("body does not contain %q", s)
+				}
+			}
+		}
+
+		id := uploadFile(t, "tests/import_example.csv")
+		idValue := strconv.Itoa(id)
+
+		// The header is detected, the row with group 0 is invalid
+		w := get("/auth/upload?id=" + idValue)
+		assertContains(t, w.Body.String(), "Gültige Zeilen: 12", "Fehlerhafte Zeilen: 1", "Ungültige Gruppe: 0", "4911234995")
+
+		// Nothing is imported before the import is confirmed
+		if _, err := bridge.store.GetPerson("+4911234995"); err == nil {
+			t.Error("person imported before confirmation")
+		}
+
+		// Mapping the columns the other way round makes all rows invalid
+		w = post(url.Values{"id": {idValue}, "action": {"columns"}, "phone_column": {"1"}, "group_column": {"0"}, "header": {"true"}})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("handlerUpload() columns = %d, want %d", w.Code, http.StatusSeeOther)
+		}
+		assertContains(t, get("/auth/upload?id="+idValue).Body.String(), "Gültige Zeilen: 0", "Fehlerhafte Zeilen: 13")
+
+		w = post(url.Values{"id": {idValue}, "action": {"columns"}, "phone_column": {"0"}, "group_column": {"1"}, "language_column": {"-1"}, "header": {"true"}})
+		if w.Code != http.StatusSeeOther {
+			t.Errorf("handlerUpload() columns = %d, want %d", w.Code, http.StatusSeeOther)
+		}
+
+		// The error report lists the invalid row
+		w = httptest.NewRecorder()
+		handlerUploadErrors(w, httptest.NewRequest(http.MethodGet, "/auth/upload/errors?id="+idValue, nil))
+		want := "\ufeffZeile;Fehler;Rufnummer;Gruppe\n3;Ungültige Gruppe: 0;11234993;0\n"
+		if w.Body.String() != want {
+			t.Errorf("handlerUploadErrors() = %q, want %q", w.Body.String(), want)
+		}
+
+		// Only the valid rows are imported by the worker, and only once
+		w = post(url.Values{"id": {idValue}, "action": {"confirm"}})
+		assertContains(t, w.Body.String(), "Import wurde gestartet", "Wartet auf Start", `http-equiv="refresh"`)
+
+		bridge.RunImports(context.Background())
+		assertContains(t, get("/auth/upload?id="+idValue).Body.String(),
+			"Abgeschlossen", "12 / 12 (100 %)", "Neue Personen: 12", "Begrüßungsnachrichten eingereiht: 12")
+
+		person, err := bridge.store.GetPerson("+4911234995")
+		if err != nil || person.Group != 3 {
+			t.Errorf("GetPerson() = %+v, %v, want person of group 3", person, err)
+		}
+		if _, err := bridge.store.GetPerson("+4911234993"); err == nil {
+			t.Error("invalid row imported")
+		}
+
+		w = post(url.Values{"id": {idValue}, "action": {"confirm"}})
+		assertContains(t, w.Body.String(), "Import wurde bereits bestätigt")
+
+		w = post(url.Values{"id": {idValue}, "action": {"columns"}, "phone_column": {"1"}, "group_column": {"0"}})
+		assertContains(t, w.Body.String(), "Import wurde bereits bestätigt")
+
+		assertContains(t, get("/auth/upload?id=999").Body.String(), "Ungültiger Import")
+
+		// The import is listed with its status
+		assertContains(t, get("/auth/upload").Body.String(), "import_example.csv", "Abgeschlossen")
+	})
+}
+
+func TestHandlerUpload_Modes(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		tests := []struct {
+			mode        string
+			want        string
+			wantStatus  ImportStatus
+			wantSummary ImportSummary
+			wantError   string
+			wantGroup   int
+		}{
+			{"", "Import wurde gestartet", ImportDone, ImportSummary{Inserted: 1, Skipped: 11}, "", 4},
+			{"skip", "Import wurde gestartet", ImportDone, ImportSummary{Inserted: 1, Skipped: 11}, "", 4},
+			{"update", "Import wurde gestartet", ImportDone, ImportSummary{Inserted: 1, Updated: 11}, "", 1},
+			{"fail", "Import wurde gestartet", ImportFailed, ImportSummary{}, "Rufnummer schon vorhanden: +4912373", 4},
+			{"other", "Ungültige Eingaben", ImportReady, ImportSummary{}, "", 4},
+		}
+		for _, tt := range tests {
+			t.Run(tt.mode, func(t *testing.T) {
+				prepareTestDatabase()
+
+				// All persons of the file but one exist already, in another group
+				id := uploadFile(t, "tests/import_example.csv")
+				_, rows, err := bridge.GetImport(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var persons []Person
+				for _, row := range rows[1:] {
+					if row.Error == "" && row.Phone != "+4911234995" {
+						persons = append(persons, Person{Phone: row.Phone, Group: 4})
+					}
+				}
+				if _, err := bridge.AddPersons(persons, ImportFail); err != nil {
+					t.Fatal(err)
+				}
+
+				form := url.Values{"id": {strconv.Itoa(id)}, "action": {"confirm"}, "mode": {tt.mode}}
+				req := httptest.NewRequest(http.MethodPost, "/auth/upload", strings.NewReader(form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				w := httptest.NewRecorder()
+				handlerUpload(w, req)
+
+				if body := w.Body.String(); !strings.Contains(body, tt.want) {
+					t.Errorf("handlerUpload() does not contain %q", tt.want)
+				}
+
+				bridge.RunImports(context.Background())
+
+				imp, _, err := bridge.GetImport(id)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if imp.Status != tt.wantStatus || imp.ImportSummary != tt.wantSummary || imp.Error != tt.wantError {
+					t.Errorf("GetImport() = %s %+v %q, want %s %+v %q",
+						imp.Status, imp.ImportSummary, imp.Error, tt.wantStatus, tt.wantSummary, tt.wantError)
+				}
+
+				person, err := bridge.store.GetPerson(persons[0].Phone)
+				if err != nil || person.Group != tt.wantGroup {
+					t.Errorf("GetPerson() = %+v, %v, want group %d", person, err, tt.wantGroup)
+				}
+
+				// Nothing is imported if the import fails
+				_, err = bridge.store.GetPerson("+4911234995")
+				if failed := tt.wantStatus != ImportDone; failed != (err != nil) {
+					t.Errorf("GetPerson() error = %v", err)
+				}
+			})
+		}
+	})
+}
+
+func TestHandlerUpload_Spreadsheets(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+		prepareTestDatabase()
+
+		preview := func(id int) string {
+			w := httptest.NewRecorder()
+			handlerUpload(w, httptest.NewRequest(http.MethodGet, "/auth/upload?id="+strconv.Itoa(id), nil))
+			return w.Body.String()
+		}
+
+		tests := []struct {
+			path       string
+			want       []string
+			sheet      string
+			wantSheet  []string
+			wantPhones []string
+		}{
+			{
+				path:      "tests/import_example.xlsx",
+				want:      []string{"import_example.xlsx, Personen", "Gültige Zeilen: 3", "Fehlerhafte Zeilen: 1", "Fehlende Gruppe", "Spalte 2: Impfgruppe"},
+				sheet:     "1",
+				wantSheet: []string{"import_example.xlsx, Nachzügler", "Gültige Zeilen: 1", "Fehlerhafte Zeilen: 0"},
+				// Only the rows of the selected sheet are imported
+				wantPhones: []string{"+4915100000005"},
+			},
+			{
+				path:       "tests/import_example.ods",
+				want:       []string{"import_example.ods, Personen", "Gültige Zeilen: 1", "Fehlerhafte Zeilen: 2", "Fehlende Gruppe", "Ungültige Rufnummer: 1"},
+				wantPhones: []string{"+4915100000001"},
+			},
+		}
+		for _, tt := range tests {
+			t.Run(tt.path, func(t *testing.T) {
+				id := uploadFile(t, tt.path)
+				body := preview(id)
+				for _, s := range tt.want {
+					if !strings.Contains(body, s) {
+						t.Errorf("preview does not contain %q", s)
+					}
+				}
+
+				if tt.sheet != "" {
+					form := url.Values{"id": {strconv.Itoa(id)}, "action": {"sheet"}, "sheet": {tt.sheet}}
+					req := httptest.NewRequest(http.MethodPost, "/auth/upload", strings.NewReader(form.Encode()))
+					req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+					w := httptest.NewRecorder()
+					handlerUpload(w, req)
+					if w.Code != http.StatusSeeOther {
+						t.Fatalf("handlerUpload() sheet = %d, want %d", w.Code, http.StatusSeeOther)
+					}
+
+					body := preview(id)
+					for _, s := range tt.wantSheet {
+						if !strings.Contains(body, s) {
+							t.Errorf("preview of sheet does not contain %q", s)
+						}
+					}
+				}
+
+				if err := bridge.ConfirmImport(id, ImportFail); err != nil {
+					t.Fatal(err)
+				}
+				bridge.RunImports(context.Background())
+
+				persons, err := bridge.GetPersons()
+				if err != nil {
+					t.Fatal(err)
+				}
+				var phones []string
+				for _, p := range persons {
+					if strings.HasPrefix(p.Phone, "+49151") {
+						phones = append(phones, p.Phone)
+					}
+				}
+				if diff := cmp.Diff(tt.wantPhones, phones); diff != "" {
+					t.Errorf("imported persons mismatch (-want +got):\n%s", diff)
+				}
+
+				prepareTestDatabase()
+			})
+		}
+	})
+}
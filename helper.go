@@ -10,10 +10,16 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func parseTemplates() *template.Template {
-	templ := template.New("")
+	templ := template.New("").Funcs(template.FuncMap{
+		"formatDate":    formatDate,
+		"formatTime":    formatTime,
+		"formatWeekday": formatWeekday,
+		"languageName":  func(lang string) string { return languageNames[lang] },
+	})
 	err := filepath.Walk("./templates", func(path string, info os.FileInfo, err error) error {
 		if strings.Contains(path, ".html") {
 			_, err = templ.ParseFiles(path)
@@ -52,3 +58,34 @@ func genOTP(phone string, callID int) string {
 	}
 	return hex.EncodeToString(h.Sum(nil))[1:5]
 }
+
+// durationFromEnv reads a duration like "15m" or "30s" from the environment
+// variable name. If it is not set, def is returned. Invalid or non-positive
+// values exit the application, as they are configuration errors.
+func durationFromEnv(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		log.Fatalf("Invalid %s: %s", name, v)
+	}
+	return d
+}
+
+// intFromEnv reads a positive integer from the environment variable name. If
+// it is not set, def is returned. Invalid values exit the application.
+func intFromEnv(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i <= 0 {
+		log.Fatalf("Invalid %s: %s", name, v)
+	}
+	return i
+}
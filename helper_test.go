@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"reflect"
 	"testing"
+	"time"
 )
 
 func Test_parseTemplates(t *testing.T) {
@@ -64,3 +65,44 @@ func Test_genOTP(t *testing.T) {
 		})
 	}
 }
+
+func Test_durationFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		def   time.Duration
+		want  time.Duration
+	}{
+		{name: "Not set", value: "", def: time.Minute, want: time.Minute},
+		{name: "Seconds", value: "30s", def: time.Minute, want: 30 * time.Second},
+		{name: "Minutes", value: "15m", def: time.Minute, want: 15 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IMPF_TEST_DURATION", tt.value)
+			if got := durationFromEnv("IMPF_TEST_DURATION", tt.def); got != tt.want {
+				t.Errorf("durationFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_intFromEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		def   int
+		want  int
+	}{
+		{name: "Not set", value: "", def: 4, want: 4},
+		{name: "Set", value: "8", def: 4, want: 8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("IMPF_TEST_INT", tt.value)
+			if got := intFromEnv("IMPF_TEST_INT", tt.def); got != tt.want {
+				t.Errorf("intFromEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Persons get their messages in their preferred language, see
+// Person.Language. The web UI for the staff of the centers is available in
+// fewer languages, see uiLanguages. Texts without translation are shown in the
+// default language.
+
+// defaultLanguage is used for persons without a preferred language and for
+// texts without translation
+const defaultLanguage = "de"
+
+// languages are the languages of messages to persons as ISO 639-1 codes. Each
+// of them has built-in message templates in ./messages.
+var languages = []string{"de", "en", "tr", "ar"}
+
+// languageNames are the names of the languages in the languages themselves
+var languageNames = map[string]string{
+	"de": "Deutsch",
+	"en": "English",
+	"tr": "Türkçe",
+	"ar": "العربية",
+}
+
+// languageAliases maps normalized names of languages in German, English and
+// the language itself to their codes, see normalizeKeyword
+var languageAliases = map[string]string{
+	"deutsch":   "de",
+	"german":    "de",
+	"almanca":   "de",
+	"الألمانية": "de",
+
+	"english":    "en",
+	"englisch":   "en",
+	"ingilizce":  "en",
+	"الإنجليزية": "en",
+
+	"tuerkçe":   "tr",
+	"turkce":    "tr",
+	"turkish":   "tr",
+	"tuerkisch": "tr",
+	"التركية":   "tr",
+
+	"العربية":  "ar",
+	"عربي":     "ar",
+	"arabic":   "ar",
+	"arabisch": "ar",
+	"arapça":   "ar",
+}
+
+// ErrUnknownLanguage is returned for languages not in languages
+var ErrUnknownLanguage = errors.New("unknown language")
+
+// parseLanguage returns the code of a language given as code or name, e.g.
+// "en", "English" or "Türkçe". An empty string is the default language.
+func parseLanguage(s string) (string, error) {
+
+	normalized := normalizeKeyword(s)
+	if normalized == "" {
+		return defaultLanguage, nil
+	}
+
+	if _, ok := languageNames[normalized]; ok {
+		return normalized, nil
+	}
+	if lang, ok := languageAliases[normalized]; ok {
+		return lang, nil
+	}
+
+	return "", fmt.Errorf("%w: %s", ErrUnknownLanguage, s)
+}
+
+// uiLanguages are the languages of the web UI, see uiTranslations
+var uiLanguages = []string{"de", "en"}
+
+// uiTranslations holds the translations of the texts of the web UI. Texts are
+// looked up by their German original.
+var uiTranslations = map[string]map[string]string{
+	"en": uiTranslationsEN,
+}
+
+// languageCookie stores the language of the web UI chosen by the user
+const languageCookie = "impf-lang"
+
+// uiLanguage returns the language of the web UI for a request: the language
+// chosen by the user, else the first supported language the browser accepts
+func uiLanguage(r *http.Request) string {
+
+	if c, err := r.Cookie(languageCookie); err == nil && isUILanguage(c.Value) {
+		return c.Value
+	}
+
+	// E.g. "en-US,en;q=0.9,de;q=0.8", which are sorted by preference
+	for _, tag := range strings.Split(r.Header.Get("Accept-Language"), ",") {
+		tag = strings.TrimSpace(strings.SplitN(tag, ";", 2)[0])
+		lang := strings.ToLower(strings.SplitN(tag, "-", 2)[0])
+		if isUILanguage(lang) {
+			return lang
+		}
+	}
+
+	return defaultLanguage
+}
+
+// isUILanguage returns true if the web UI is available in lang
+func isUILanguage(lang string) bool {
+	for _, l := range uiLanguages {
+		if l == lang {
+			return true
+		}
+	}
+	return false
+}
+
+// translate returns the translation of a text of the web UI. Texts with
+// details after a colon, e.g. "Ungültige Rufnummer: 0123", are translated
+// before the colon. Texts without translation are returned unchanged.
+func translate(lang, text string) string {
+
+	catalog := uiTranslations[lang]
+	if catalog == nil {
+		return text
+	}
+
+	if t, ok := catalog[text]; ok {
+		return t
+	}
+
+	if i := strings.Index(text, ": "); i > 0 {
+		if t, ok := catalog[text[:i]]; ok {
+			return t + text[i:]
+		}
+	}
+
+	return text
+}
+
+// T translates a text of a html template into the language of the web UI,
+// e.g. {{$.T "Neuer Ruf"}}
+func (d TmplData) T(text string) string {
+	return translate(d.Lang, text)
+}
+
+// handlerLanguage sets the language of the web UI to the parameter "lang" and
+// returns to the page the user came from
+func handlerLanguage(w http.ResponseWriter, r *http.Request) {
+
+	lang := r.FormValue("lang")
+	if !isUILanguage(lang) {
+		http.Error(w, "Invalid language", http.StatusBadRequest)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     languageCookie,
+		Value:    lang,
+		Path:     "/",
+		Expires:  time.Now().AddDate(1, 0, 0),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	// Only the path of the referer is used, to stay on this site
+	target := "/"
+	ref, err := url.Parse(r.Referer())
+	if err != nil {
+		log.Debug(err)
+	} else if strings.HasPrefix(ref.Path, "/") && !strings.HasPrefix(ref.Path, "//") {
+		target = ref.Path
+		if ref.RawQuery != "" {
+			target += "?" + ref.RawQuery
+		}
+	}
+
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
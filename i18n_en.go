@@ -0,0 +1,226 @@
+package main
+
+// uiTranslationsEN are the English texts of the web UI, see translate
+var uiTranslationsEN = map[string]string{
+
+	// Navigation and layout
+	"Neuer Ruf":              "New call",
+	"Aktive Rufe":            "Active calls",
+	"Rufnummern importieren": "Import numbers",
+	"Nachrichten":            "Messages",
+	"Vorlagen":               "Templates",
+	"Eingeloggt als":         "Logged in as",
+	"Login Fehlgeschlagen":   "Login failed",
+
+	// Calls
+	"Ihre Rufe":                "Your calls",
+	"Einladungen jetzt senden": "Send invitations now",
+	"Titel":                    "Title",
+	"Ruf Erstellen":            "Create call",
+	"Ruf":                      "Call",
+	"Impfstoff":                "Vaccine",
+	"von":                      "of",
+	"Zusagen":                  "Acceptances",
+	"Datum":                    "Date",
+	"Beginn":                   "Start",
+	"Ende":                     "End",
+	"Aktualisieren":            "Refresh",
+	"Letzte Aktualisierung":    "Last refresh",
+	"Suche...":                 "Search...",
+	"Erhalten um":              "Received at",
+	"Rufnummer":                "Phone number",
+	"Gruppe":                   "Group",
+	"Status":                   "Status",
+	"geimpft":                  "vaccinated",
+	"ungeimpft":                "not vaccinated",
+	"Noch keine Zusagen":       "No acceptances yet",
+	"Ruf Abschließen":          "Close call",
+	"Kein Ruf ausgewählt":      "No call selected",
+	"Wird ein Ruf abgeschlossen, werden keine neuen Personen mehr informiert. Ein Ruf wird automatisch nach Ablauf der Endzeit geschlossen.": "No more persons are notified once a call is closed. Calls are closed automatically when their end time has passed.",
+
+	// New call
+	"Anzahl":             "Seats",
+	"Antwortzeit (Min.)": "Response time (min.)",
+	"Anfang":             "Start",
+	"Name":               "Name",
+	"Straße":             "Street",
+	"Hausnummer":         "House number",
+	"Postleitzahl":       "Postcode",
+	"Stadt":              "City",
+	"Optional":           "Optional",
+	"Zusatzinfo - z.B. Ansprechpartner, Weghinweis": "Additional information - e.g. contact person, directions",
+	"Überbuchungsfaktor":                            "Overbooking factor",
+	"Max. offene Einladungen (0 = unbegrenzt)":      "Max. open invitations (0 = unlimited)",
+	"Abstand der Wellen (Min.)":                     "Spacing of waves (min.)",
+	"Sonderzeichen ersetzen, damit die SMS günstiger im GSM-7-Zeichensatz gesendet werden": "Replace special characters, so the SMS are sent in the cheaper GSM-7 character set",
+	"Einladung zu lang":           "Invitation too long",
+	"Zeichen im Zeichensatz":      "characters in character set",
+	"SMS pro Person":              "SMS per person",
+	"erlaubt":                     "allowed",
+	"Nicht im GSM-7-Zeichensatz":  "Not in the GSM-7 character set",
+	"Mit ersetzten Sonderzeichen": "With replaced special characters",
+	"SMS im Zeichensatz":          "SMS in character set",
+	"Kürzen Sie die Angaben zum Ort oder starten Sie den Ruf trotzdem.": "Shorten the location or start the call anyway.",
+	"Ruf trotzdem starten": "Start call anyway",
+	"Ruf Starten":          "Start call",
+
+	"Eingaben ungültig, Ruf nicht gespeichert":                                            "Invalid input, call not saved",
+	"Einladung konnte nicht erstellt werden, Ruf nicht gespeichert":                       "Could not create the invitation, call not saved",
+	"Die Einladung wird als %d SMS gesendet, erlaubt sind %d. Ruf noch nicht gespeichert": "The invitation is sent as %d SMS, %d are allowed. Call not saved yet",
+	"Ruf konnte nicht gespeichert werden":                                                 "Could not save the call",
+	"Ruf erfolgreich erstellt!":                                                           "Call created!",
+	"Ungültige Kapazität":                                                                 "Invalid number of seats",
+	"Ungültige Antwortzeit":                                                               "Invalid response time",
+	"Ungültiger Überbuchungsfaktor":                                                       "Invalid overbooking factor",
+	"Ungültige Anzahl offener Einladungen":                                                "Invalid number of open invitations",
+	"Ungültiger Abstand zwischen Einladungswellen":                                        "Invalid spacing of invitation waves",
+	"Ungültige Startzeit":                                                                 "Invalid start time",
+	"Ungültige Endzezeit":                                                                 "Invalid end time",
+	"Endzezeit ist nicht nach Startzeit":                                                  "End time is not after start time",
+	"Ungültige Angabe für Impfstoff":                                                      "Invalid vaccine",
+	"Ungültige Eingabe für":                                                               "Invalid input for",
+
+	// Persons
+	"Import Datei":           "Import file",
+	"Datei auswählen":        "Choose file",
+	"Hochladen":              "Upload",
+	"Import einzelne Person": "Import single person",
+	"Impfgruppe":             "Vaccination group",
+	"Sprache":                "Language",
+	"Hinzufügen":             "Add",
+	"Aktive Rufnummern":      "Active numbers",
+	"Zentrum":                "Center",
+	"CSV-, Excel- (XLSX) oder ODS-Datei mit Rufnummer, Gruppe und optional der Sprache der Nachrichten je Zeile, z.B. en oder English. Die Spalten werden anhand der Überschriften erkannt. Vor dem Import wird eine Vorschau angezeigt.": "CSV, Excel (XLSX) or ODS file with the phone number, group and optionally the language of the messages in each row, e.g. en or English. The columns are detected by their headers. A preview is shown before the import.",
+
+	"Ungültige Eingaben":  "Invalid input",
+	"Ungültige Gruppe":    "Invalid group",
+	"Ungültige Rufnummer": "Invalid phone number",
+	"Ungültige Sprache":   "Invalid language",
+	"Fehlende Rufnummer":  "Missing phone number",
+	"Eingaben ungültig":   "Invalid input",
+	"Personen konnten nicht gespeichert werden. Rufnummer schon vorhanden?": "Could not save the persons. Does the number exist already?",
+	"Import Erfolgreich!": "Import successful!",
+
+	// Imports
+	"Import":                           "Import",
+	"Hochgeladen":                      "Uploaded",
+	"Gültige Zeilen":                   "Valid rows",
+	"Fehlerhafte Zeilen":               "Invalid rows",
+	"Fehlerbericht herunterladen":      "Download error report",
+	"Importiert am":                    "Imported on",
+	"Gültige Zeilen importieren":       "Import valid rows",
+	"Neue Personen":                    "New persons",
+	"Aktualisierte Personen":           "Updated persons",
+	"Übersprungene Personen":           "Skipped persons",
+	"Vorhandene Rufnummern":            "Existing phone numbers",
+	"Überspringen":                     "Skip",
+	"Gruppe und Zentrum aktualisieren": "Update group and center",
+	"Import abbrechen":                 "Cancel import",
+	"Nichts importieren":               "Import nothing",
+	"Begrüßungsnachrichten eingereiht": "Onboarding messages queued",
+	"Importe":                          "Imports",
+	"Datei":                            "File",
+	"Datei hochladen":                  "Upload file",
+	"Fortschritt":                      "Progress",
+	"Letzte Importe anzeigen":          "Show last imports",
+	"Datei wird gelesen":               "Reading file",
+	"Wartet auf Bestätigung":           "Waiting for confirmation",
+	"Wartet auf Start":                 "Waiting to start",
+	"Läuft":                            "Running",
+	"Abgeschlossen":                    "Completed",
+	"Fehlgeschlagen":                   "Failed",
+	"Abgebrochen":                      "Cancelled",
+	"Spalten":                          "Columns",
+	"Tabellenblatt":                    "Sheet",
+	"Tabellenblatt wählen":             "Choose sheet",
+	"Spalte":                           "Column",
+	"Erste Zeile enthält die Namen der Spalten": "First row holds the names of the columns",
+	"Zuordnung übernehmen":                      "Apply mapping",
+	"Diese Zeilen werden nicht importiert.":     "These rows are not imported.",
+	"Zeile":                                     "Row",
+	"Fehler":                                    "Error",
+	"Inhalt":                                    "Content",
+
+	"Fehlende Gruppe":                        "Missing group",
+	"Keine Datei ausgewählt":                 "No file chosen",
+	"Datei konnte nicht gelesen werden":      "Could not read the file",
+	"Import konnte nicht gespeichert werden": "Could not save the import",
+	"Import konnte nicht geladen werden":     "Could not load the import",
+	"Ungültiger Import":                      "Invalid import",
+	"Ungültiges Tabellenblatt":               "Invalid sheet",
+	"Dateiformat wird nicht unterstützt":     "File format is not supported",
+	"Import wurde bereits bestätigt":         "Import has been confirmed already",
+	"Rufnummer schon vorhanden":              "Phone number exists already",
+	"Import konnte nicht gestartet werden":   "Could not start the import",
+	"Import konnte nicht abgebrochen werden": "Could not cancel the import",
+	"Importe konnten nicht geladen werden":   "Could not load the imports",
+	"Import wurde gestartet":                 "Import has been started",
+	"Import wurde abgebrochen":               "Import has been cancelled",
+	"Rufnummer gesperrt, Löschung verlangt":  "Phone number blocked, deletion requested",
+	"Gesperrte Personen":                     "Blocked persons",
+	"Gesperrte Rufnummern":                   "Blocked phone numbers",
+	"Personen, die ihre Löschung verlangt haben, werden nicht wieder hinzugefügt. Die Sperre kann aufgehoben werden, z.B. wenn sich die Person erneut anmeldet.": "Persons who requested their deletion are not added again. The block can be lifted, e.g. when the person registers again.",
+	"Prüfen":                                "Check",
+	"Gesperrt seit":                         "Blocked since",
+	"Sperre aufgehoben am":                  "Block lifted on",
+	"Sperre aufheben":                       "Lift block",
+	"Sperre aufgehoben":                     "Block lifted",
+	"Rufnummer ist nicht gesperrt":          "Phone number is not blocked",
+	"Sperre konnte nicht aufgehoben werden": "Could not lift the block",
+	"Sperre konnte nicht geladen werden":    "Could not load the block",
+	"Rufnummer schon in Zeile":              "Phone number already in row",
+
+	// Messages
+	"Nicht erreichbare Rufnummern": "Unreachable numbers",
+	"An diese Rufnummern konnten die letzten Nachrichten nicht zugestellt werden. Sie sollten überprüft oder entfernt werden.": "The last messages to these numbers could not be delivered. They should be checked or removed.",
+	"Nicht zugestellt":            "Not delivered",
+	"Erstellt":                    "Created",
+	"Versuche":                    "Attempts",
+	"Gesendet / Nächster Versuch": "Sent / Next attempt",
+	"Letzter Fehler":              "Last error",
+	"Zustellung":                  "Delivery",
+	"gesendet":                    "sent",
+	"fehlgeschlagen":              "failed",
+	"wartend":                     "pending",
+	"zugestellt":                  "delivered",
+	"nicht zugestellt":            "not delivered",
+	"Erneut senden":               "Send again",
+	"Keine Nachrichten":           "No messages",
+
+	"Ungültige Nachricht":                           "Invalid message",
+	"Nachricht konnte nicht erneut gesendet werden": "Could not send the message again",
+	"Nachricht wird erneut gesendet":                "Message is sent again",
+	"Nachrichten konnten nicht geladen werden":      "Could not load the messages",
+
+	// Message templates
+	"Nachrichtenvorlagen":        "Message templates",
+	"Anzeigen":                   "Show",
+	"Verfügbare Platzhalter":     "Available placeholders",
+	"Vorlage":                    "Template",
+	"angepasst":                  "customized",
+	"angepasst für alle Zentren": "customized for all centers",
+	"aus Datei":                  "from file",
+	"Standard":                   "default",
+	"Vorschau":                   "Preview",
+	"Speichern":                  "Save",
+	"Zurücksetzen":               "Reset",
+	"nicht im GSM-7-Zeichensatz": "not in the GSM-7 character set",
+	"Zentrum 0 gilt für alle Zentren ohne eigene Vorlage. Vorlagen ohne Übersetzung werden auf Deutsch gesendet.": "Center 0 applies to all centers without a template of their own. Templates without translation are sent in German.",
+
+	"Begrüßung":                     "Welcome",
+	"Einladung":                     "Invitation",
+	"Ruf bereits voll":              "Call already full",
+	"Terminbestätigung":             "Appointment confirmation",
+	"Rufnummer gelöscht":            "Number deleted",
+	"Hilfe bei unbekannter Antwort": "Help on unknown reply",
+	"Keine offene Einladung":        "No open invitation",
+	"Sprache geändert":              "Language changed",
+
+	"Ungültiges Zentrum":                        "Invalid center",
+	"Ungültige Vorlage":                         "Invalid template",
+	"Fehlende Platzhalter":                      "Missing placeholders",
+	"Vorlage gespeichert":                       "Template saved",
+	"Vorlage konnte nicht gespeichert werden":   "Could not save the template",
+	"Vorlage zurückgesetzt":                     "Template reset",
+	"Vorlage konnte nicht zurückgesetzt werden": "Could not reset the template",
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func Test_parseLanguage(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    string
+		wantErr error
+	}{
+		{"", "de", nil},
+		{"en", "en", nil},
+		{"EN", "en", nil},
+		{"English", "en", nil},
+		{"Englisch", "en", nil},
+		{"Türkçe", "tr", nil},
+		{"türkisch", "tr", nil},
+		{"العربية", "ar", nil},
+		{"Deutsch", "de", nil},
+		{"fr", "", ErrUnknownLanguage},
+		{"Klingonisch", "", ErrUnknownLanguage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			got, err := parseLanguage(tt.s)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("parseLanguage(%q) error = %v, wantErr %v", tt.s, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseLanguage(%q) = %q, want %q", tt.s, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_translate(t *testing.T) {
+	tests := []struct {
+		name string
+		lang string
+		text string
+		want string
+	}{
+		{"German", "de", "Neuer Ruf", "Neuer Ruf"},
+		{"English", "en", "Neuer Ruf", "New call"},
+		{"With details", "en", "Ungültige Rufnummer: 0123", "Invalid phone number: 0123"},
+		{"Without translation", "en", "Unbekannter Text", "Unbekannter Text"},
+		{"Unknown language", "xx", "Neuer Ruf", "Neuer Ruf"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := translate(tt.lang, tt.text); got != tt.want {
+				t.Errorf("translate(%s, %q) = %q, want %q", tt.lang, tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_uiLanguage(t *testing.T) {
+	tests := []struct {
+		name           string
+		cookie         string
+		acceptLanguage string
+		want           string
+	}{
+		{"Default", "", "", "de"},
+		{"Cookie", "en", "de-DE,de", "en"},
+		{"Invalid cookie", "tr", "", "de"},
+		{"Accept-Language", "", "en-US,en;q=0.9,de;q=0.8", "en"},
+		{"Accept-Language by preference", "", "fr-FR,de;q=0.9,en;q=0.8", "de"},
+		{"Unsupported Accept-Language", "", "fr-FR,fr", "de"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/auth/call", nil)
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: languageCookie, Value: tt.cookie})
+			}
+			if tt.acceptLanguage != "" {
+				r.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+			if got := uiLanguage(r); got != tt.want {
+				t.Errorf("uiLanguage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerLanguage(t *testing.T) {
+	tests := []struct {
+		name         string
+		lang         string
+		referer      string
+		wantCode     int
+		wantLocation string
+	}{
+		{"English", "en", "http://localhost/auth/templates?center=3", http.StatusSeeOther, "/auth/templates?center=3"},
+		{"German", "de", "", http.StatusSeeOther, "/"},
+		{"Other site", "en", "http://localhost//example.com/auth/call", http.StatusSeeOther, "/"},
+		{"Invalid", "xx", "http://localhost/auth/call", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/lang?lang="+tt.lang, nil)
+			if tt.referer != "" {
+				r.Header.Set("Referer", tt.referer)
+			}
+			w := httptest.NewRecorder()
+			handlerLanguage(w, r)
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("handlerLanguage() = %d, want %d", w.Code, tt.wantCode)
+			}
+			if tt.wantCode != http.StatusSeeOther {
+				return
+			}
+
+			if got := w.Header().Get("Location"); got != tt.wantLocation {
+				t.Errorf("handlerLanguage() location = %q, want %q", got, tt.wantLocation)
+			}
+
+			cookies := w.Result().Cookies()
+			if len(cookies) != 1 || cookies[0].Name != languageCookie || cookies[0].Value != tt.lang {
+				t.Errorf("handlerLanguage() cookies = %v, want %s=%s", cookies, languageCookie, tt.lang)
+			}
+		})
+	}
+}
+
+// TestTemplates_Translated checks that all texts translated in the html
+// templates have a translation for every language of the web UI
+func TestTemplates_Translated(t *testing.T) {
+
+	texts := regexp.MustCompile(`\$\.T "([^"]+)"`)
+
+	err := filepath.Walk("./templates", func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, match := range texts.FindAllStringSubmatch(string(content), -1) {
+			for lang, catalog := range uiTranslations {
+				if _, ok := catalog[match[1]]; !ok {
+					t.Errorf("%s: no %s translation of %q", path, lang, match[1])
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTmplData_T(t *testing.T) {
+	d := TmplData{Lang: "en"}
+	if got := d.T("Aktive Rufe"); got != "Active calls" {
+		t.Errorf("TmplData.T() = %q, want %q", got, "Active calls")
+	}
+}
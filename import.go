@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Lists of persons are imported in two steps. An uploaded file is read into
+// rows, which are validated and saved as Import. The staff checks the preview
+// of the import, corrects the mapping of the columns if needed and confirms
+// it. Only then the persons of the valid rows are added, rows with errors are
+// skipped and can be downloaded as report.
+//
+// Both steps run as job in the background, see Bridge.RunImports, as large
+// files take a while. The status of an import tells which step it is in.
+
+// ImportColumns maps the columns of an uploaded file to the fields of a
+// person. Columns are counted from 0, -1 if the file has no such column.
+type ImportColumns struct {
+	Phone    int `db:"phone_column"`
+	Group    int `db:"group_column"`
+	Language int `db:"language_column"`
+}
+
+// defaultImportColumns is the mapping of files without header
+var defaultImportColumns = ImportColumns{Phone: 0, Group: 1, Language: 2}
+
+// importHeaders maps normalized column headers to the fields of a person, see
+// normalizeKeyword
+var importHeaders = map[string]string{
+	"rufnummer":       "phone",
+	"telefon":         "phone",
+	"telefonnummer":   "phone",
+	"telefon nr":      "phone",
+	"tel":             "phone",
+	"tel nr":          "phone",
+	"handy":           "phone",
+	"handynummer":     "phone",
+	"mobil":           "phone",
+	"mobilnummer":     "phone",
+	"mobilfunknummer": "phone",
+	"nummer":          "phone",
+	"phone":           "phone",
+	"phone number":    "phone",
+	"mobile":          "phone",
+
+	"gruppe":            "group",
+	"impfgruppe":        "group",
+	"prioritaet":        "group",
+	"prioritaetsgruppe": "group",
+	"prio":              "group",
+	"group":             "group",
+	"priority":          "group",
+
+	"sprache":  "language",
+	"language": "language",
+}
+
+// Import is an uploaded list of persons
+type Import struct {
+	ID        int       `db:"id"`
+	Filename  string    `db:"filename"`
+	Header    bool      `db:"header"` // The first row holds the names of the columns
+	CreatedAt time.Time `db:"created_at"`
+	CreatedBy string    `db:"created_by"`
+	CenterID  int       `db:"center_id"` // Center of the user who uploaded it
+	Sheets    string    `db:"sheets"`    // Names of all sheets as JSON array, see SheetNames
+	Sheet     int       `db:"sheet"`     // Index of the sheet to import
+
+	// Time the import was completed and number of persons added or updated
+	ImportedAt sql.NullTime `db:"imported_at"`
+	Imported   int          `db:"imported"`
+	Mode       ImportMode   `db:"mode"` // Mode the import was confirmed with
+
+	// Progress of the job. Total and Processed count the persons to import,
+	// Queued the onboarding messages sent to new persons. Error tells why the
+	// import failed.
+	Status    ImportStatus `db:"status"`
+	Total     int          `db:"total"`
+	Processed int          `db:"processed"`
+	Queued    int          `db:"queued"`
+	Error     string       `db:"error"`
+
+	ImportColumns
+	ImportSummary
+}
+
+// ImportStatus is the step an import is in
+type ImportStatus string
+
+// Statuses of imports. Uploaded files are read by the worker and wait for the
+// confirmation of the staff when ready. Confirmed imports are queued until the
+// worker runs them. Imports which failed or have been cancelled can be
+// confirmed again.
+const (
+	ImportUploaded  ImportStatus = "uploaded"
+	ImportReady     ImportStatus = "ready"
+	ImportQueued    ImportStatus = "queued"
+	ImportRunning   ImportStatus = "running"
+	ImportDone      ImportStatus = "done"
+	ImportFailed    ImportStatus = "failed"
+	ImportCancelled ImportStatus = "cancelled"
+)
+
+// importStatusLabels are shown to the staff
+var importStatusLabels = map[ImportStatus]string{
+	ImportUploaded:  "Datei wird gelesen",
+	ImportReady:     "Wartet auf Bestätigung",
+	ImportQueued:    "Wartet auf Start",
+	ImportRunning:   "Läuft",
+	ImportDone:      "Abgeschlossen",
+	ImportFailed:    "Fehlgeschlagen",
+	ImportCancelled: "Abgebrochen",
+}
+
+// Label returns the status as shown to the staff
+func (s ImportStatus) Label() string {
+	if label, ok := importStatusLabels[s]; ok {
+		return label
+	}
+	return string(s)
+}
+
+// Active returns true while the worker has to process the import
+func (s ImportStatus) Active() bool {
+	return s == ImportUploaded || s == ImportQueued || s == ImportRunning
+}
+
+// Confirmable returns true if imports with the status can be confirmed.
+// Imports which have failed or have been cancelled can be confirmed again,
+// persons imported already are then handled by the mode. This requires that
+// their file has been read, see ImportPreview.Confirmable.
+func (s ImportStatus) Confirmable() bool {
+	return s == ImportReady || s == ImportFailed || s == ImportCancelled
+}
+
+// Progress returns the percentage of persons processed
+func (imp Import) Progress() int {
+	if imp.Total == 0 {
+		if imp.Status == ImportDone {
+			return 100
+		}
+		return 0
+	}
+	return imp.Processed * 100 / imp.Total
+}
+
+// ImportMode tells how persons are imported whose phone number exists already
+type ImportMode string
+
+// Modes of imports
+const (
+	ImportSkip   ImportMode = "skip"   // Keep the existing person
+	ImportUpdate ImportMode = "update" // Update group and center of the existing person
+	ImportFail   ImportMode = "fail"   // Import nobody
+)
+
+// parseImportMode returns the mode of an import chosen by the staff, skipping
+// existing persons by default
+func parseImportMode(s string) (ImportMode, error) {
+	switch mode := ImportMode(s); mode {
+	case "":
+		return ImportSkip, nil
+	case ImportSkip, ImportUpdate, ImportFail:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid import mode %q", s)
+	}
+}
+
+// ImportSummary counts the persons of an import by outcome
+type ImportSummary struct {
+	Inserted   int `db:"inserted"`
+	Updated    int `db:"updated"`
+	Skipped    int `db:"skipped"`
+	Suppressed int `db:"suppressed"` // Not added because they asked to be deleted
+}
+
+// ImportRow is a row of an uploaded list of persons with the result of its
+// validation. Rows without error are imported.
+type ImportRow struct {
+	ImportID int    `db:"import_id"`
+	Sheet    int    `db:"sheet"`  // Index of the sheet
+	Line     int    `db:"line"`   // Line in the sheet, starting at 1
+	Record   string `db:"record"` // Fields of the row as JSON array, see Fields
+	Phone    string `db:"phone"`
+	Group    int    `db:"group_num"`
+	Language string `db:"language"`
+	Error    string `db:"error"` // Reason the row can't be imported
+}
+
+// ImportPreview bundles an import with the rows shown to the staff before it
+// is confirmed
+type ImportPreview struct {
+	Import
+	Columns []ImportColumn // Columns to choose from for the mapping
+	Valid   int            // Number of rows which can be imported
+	Invalid int            // Number of rows with errors
+	Rows    []ImportRow    // First valid rows
+	Errors  []ImportRow    // First rows with errors
+}
+
+// Confirmable returns true if the import can be confirmed. Imports whose file
+// could not be read or which were cancelled before have no rows to import.
+func (p ImportPreview) Confirmable() bool {
+	return p.Status == ImportReady || (p.Status.Confirmable() && p.Valid+p.Invalid > 0)
+}
+
+// ImportColumn is a column of an uploaded file, named by the header if the file
+// has one
+type ImportColumn struct {
+	Index int
+	Name  string
+}
+
+// Number returns the number of the column as shown to the staff
+func (c ImportColumn) Number() int {
+	return c.Index + 1
+}
+
+// Limits of the rows shown in the preview of an import. All rows with errors
+// are in the report.
+const (
+	importPreviewRows   = 20
+	importPreviewErrors = 100
+)
+
+// ErrImportDone is returned when an import is changed or confirmed after it
+// has been confirmed already
+var ErrImportDone = errors.New("import already confirmed")
+
+// ErrImportCancelled is returned when an import has been cancelled while the
+// worker processes it
+var ErrImportCancelled = errors.New("import cancelled")
+
+// ErrPersonExists is returned when persons are added in mode ImportFail and
+// one of them exists already, wrapped in a PersonExistsError
+var ErrPersonExists = errors.New("person exists already")
+
+// PersonExistsError tells which person made an import in mode ImportFail fail
+type PersonExistsError struct {
+	Phone string
+}
+
+func (e *PersonExistsError) Error() string {
+	return ErrPersonExists.Error() + ": " + e.Phone
+}
+
+// Unwrap returns ErrPersonExists, so errors.Is matches it
+func (e *PersonExistsError) Unwrap() error {
+	return ErrPersonExists
+}
+
+// readCSV returns the records of a CSV file. The separator is detected from
+// the first line, as spreadsheets with German settings separate the columns
+// with semicolons. Files which are not UTF-8 are read as Windows-1252, which
+// spreadsheets on Windows use by default.
+func readCSV(r io.Reader) ([][]string, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data = bytes.TrimPrefix(data, []byte("\ufeff"))
+	if !utf8.Valid(data) {
+		data = []byte(decodeWindows1252(data))
+	}
+
+	first, err := bufio.NewReader(bytes.NewReader(data)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.Comma = detectSeparator(first)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	reader.TrimLeadingSpace = true
+
+	return reader.ReadAll()
+}
+
+// detectSeparator returns the separator used most in a line of a CSV file,
+// comma by default
+func detectSeparator(line string) rune {
+	separator, max := ',', strings.Count(line, ",")
+	for _, r := range []rune{';', '\t'} {
+		if n := strings.Count(line, string(r)); n > max {
+			separator, max = r, n
+		}
+	}
+	return separator
+}
+
+// windows1252 holds the characters of the bytes 0x80 to 0x9F in Windows-1252,
+// all other bytes are the same as in Latin-1
+var windows1252 = []rune("€\u0081‚ƒ„…†‡ˆ‰Š‹Œ\u008DŽ\u008F\u0090‘’“”•–—˜™š›œ\u009DžŸ")
+
+// decodeWindows1252 converts text in Windows-1252 to a string
+func decodeWindows1252(data []byte) string {
+	var b strings.Builder
+	for _, c := range data {
+		if c >= 0x80 && c <= 0x9F {
+			b.WriteRune(windows1252[c-0x80])
+		} else {
+			b.WriteRune(rune(c))
+		}
+	}
+	return b.String()
+}
+
+// detectColumns returns the mapping of the columns of a file by the names in
+// its first row. If the first row is no header, the default mapping is
+// returned.
+func detectColumns(first []string) (ImportColumns, bool) {
+
+	columns := ImportColumns{Phone: -1, Group: -1, Language: -1}
+	header := false
+
+	for i, name := range first {
+		switch importHeaders[normalizeKeyword(name)] {
+		case "phone":
+			columns.Phone, header = i, true
+		case "group":
+			columns.Group, header = i, true
+		case "language":
+			columns.Language, header = i, true
+		}
+	}
+
+	if header {
+		return columns, true
+	}
+
+	// A first row without digits where the phone number is expected holds
+	// unknown names of the columns
+	phone := field(first, defaultImportColumns.Phone)
+	return defaultImportColumns, phone != "" && !strings.ContainsAny(phone, "0123456789")
+}
+
+// detectColumns sets the mapping of the columns of an import by the first line
+// of the rows of its sheet
+func (imp *Import) detectColumns(rows []ImportRow) {
+	imp.ImportColumns, imp.Header = defaultImportColumns, false
+	if len(rows) > 0 && rows[0].Line == 1 {
+		imp.ImportColumns, imp.Header = detectColumns(rows[0].Fields())
+	}
+}
+
+// SheetNames returns the names of all sheets of the uploaded file
+func (imp Import) SheetNames() []string {
+	var names []string
+	if err := json.Unmarshal([]byte(imp.Sheets), &names); err != nil {
+		return nil
+	}
+	return names
+}
+
+// SheetName returns the name of the selected sheet, empty if the file has not
+// been read yet
+func (imp Import) SheetName() string {
+	if names := imp.SheetNames(); imp.Sheet < len(names) {
+		return names[imp.Sheet]
+	}
+	return ""
+}
+
+// field returns the trimmed field of a record in a column, empty if the record
+// has no such column
+func field(record []string, column int) string {
+	if column < 0 || column >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[column])
+}
+
+// newImportRow encodes the fields of a row in the given sheet and line of a
+// file
+func newImportRow(sheet, line int, record []string) (ImportRow, error) {
+	encoded, err := json.Marshal(record)
+	return ImportRow{Sheet: sheet, Line: line, Record: string(encoded)}, err
+}
+
+// Fields returns the fields of the row as in the file
+func (r ImportRow) Fields() []string {
+	var fields []string
+	if err := json.Unmarshal([]byte(r.Record), &fields); err != nil {
+		return []string{r.Record}
+	}
+	return fields
+}
+
+// emptyRecord returns true if all fields of a record are empty, as in lines at
+// the end of files exported by spreadsheets
+func emptyRecord(record []string) bool {
+	for _, f := range record {
+		if strings.TrimSpace(f) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// validate parses the fields of the row into a person with the given mapping
+// of the columns and records the first error found
+func (r *ImportRow) validate(columns ImportColumns) {
+
+	fields := r.Fields()
+	r.Phone, r.Group, r.Language, r.Error = "", 0, "", ""
+
+	phone := field(fields, columns.Phone)
+	if phone == "" {
+		r.Error = "Fehlende Rufnummer"
+		return
+	}
+
+	group := field(fields, columns.Group)
+	if group == "" {
+		r.Error = "Fehlende Gruppe"
+		return
+	}
+	groupNum, err := strconv.Atoi(group)
+	if err != nil || groupNum < 1 {
+		r.Error = "Ungültige Gruppe: " + group
+		return
+	}
+
+	person, err := NewPerson(0, groupNum, phone, false)
+	if err != nil {
+		r.Error = err.Error()
+		return
+	}
+
+	language, err := parseLanguage(field(fields, columns.Language))
+	if err != nil {
+		r.Error = "Ungültige Sprache: " + field(fields, columns.Language)
+		return
+	}
+
+	r.Phone, r.Group, r.Language = person.Phone, person.Group, language
+}
+
+// Person returns the person of a valid row, added by a center
+func (r ImportRow) Person(centerID int) Person {
+	return Person{Phone: r.Phone, CenterID: centerID, Group: r.Group, Language: r.Language}
+}
+
+// validateImport validates all rows of an import except the header. Rows with
+// a phone number of a previous row are invalid, so each person is imported
+// once.
+func validateImport(imp Import, rows []ImportRow) {
+
+	lines := map[string]int{}
+
+	for k := range rows {
+		if imp.IsHeader(rows[k]) {
+			rows[k].Phone, rows[k].Group, rows[k].Language, rows[k].Error = "", 0, "", ""
+			continue
+		}
+		rows[k].validate(imp.ImportColumns)
+		if rows[k].Error != "" {
+			continue
+		}
+
+		if line, ok := lines[rows[k].Phone]; ok {
+			rows[k].Phone, rows[k].Group, rows[k].Language = "", 0, ""
+			rows[k].Error = "Rufnummer schon in Zeile: " + strconv.Itoa(line)
+			continue
+		}
+		lines[rows[k].Phone] = rows[k].Line
+	}
+}
+
+// IsHeader returns true if the row holds the names of the columns
+func (imp Import) IsHeader(row ImportRow) bool {
+	return imp.Header && row.Line == 1
+}
+
+// writeImportReport writes the rows of an import with errors as CSV, with the
+// line and the error in front of the fields of the file
+func writeImportReport(w io.Writer, imp Import, rows []ImportRow) error {
+
+	writer := csv.NewWriter(w)
+	writer.Comma = ';'
+
+	header := []string{"Zeile", "Fehler"}
+	if len(rows) > 0 && imp.IsHeader(rows[0]) {
+		header = append(header, rows[0].Fields()...)
+	}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		if row.Error == "" || imp.IsHeader(row) {
+			continue
+		}
+		record := append([]string{strconv.Itoa(row.Line), row.Error}, row.Fields()...)
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
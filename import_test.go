@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_readCSV(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want [][]string
+	}{
+		{
+			name: "Comma",
+			data: "Rufnummer,Gruppe\n015100000001,1\n",
+			want: [][]string{{"Rufnummer", "Gruppe"}, {"015100000001", "1"}},
+		},
+		{
+			name: "Semicolon with byte order mark",
+			data: "\ufeffRufnummer;Gruppe;Sprache\r\n015100000001;1;Türkçe\r\n",
+			want: [][]string{{"Rufnummer", "Gruppe", "Sprache"}, {"015100000001", "1", "Türkçe"}},
+		},
+		{
+			name: "Tab",
+			data: "015100000001\t1\n015100000002\t2\ten\n",
+			want: [][]string{{"015100000001", "1"}, {"015100000002", "2", "en"}},
+		},
+		{
+			name: "Windows-1252",
+			data: "Rufnummer;Gruppe;Sprache\n015100000001;1;T\xfcrk\xe7e\n",
+			want: [][]string{{"Rufnummer", "Gruppe", "Sprache"}, {"015100000001", "1", "Türkçe"}},
+		},
+		{
+			name: "Quotes",
+			data: "\"0151 000 000 01\", 1\n",
+			want: [][]string{{"0151 000 000 01", "1"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readCSV(strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatalf("readCSV() error = %v", err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("readCSV() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_detectColumns(t *testing.T) {
+	tests := []struct {
+		name       string
+		first      []string
+		want       ImportColumns
+		wantHeader bool
+	}{
+		{"No header", []string{"015100000001", "1"}, defaultImportColumns, false},
+		{"Header", []string{"Rufnummer", "Gruppe"}, ImportColumns{Phone: 0, Group: 1, Language: -1}, true},
+		{"Other order", []string{"Name", "Impfgruppe", "Sprache", "Handynummer"}, ImportColumns{Phone: 3, Group: 1, Language: 2}, true},
+		{"Spelling", []string{" TELEFON-NR. ", "Priorität"}, ImportColumns{Phone: 0, Group: 1, Language: -1}, true},
+		{"Unknown header", []string{"Kontakt", "Klasse"}, defaultImportColumns, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, header := detectColumns(tt.first)
+			if got != tt.want || header != tt.wantHeader {
+				t.Errorf("detectColumns() = %+v, %v, want %+v, %v", got, header, tt.want, tt.wantHeader)
+			}
+		})
+	}
+}
+
+func TestImportRow_validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  []string
+		columns ImportColumns
+		want    ImportRow
+	}{
+		{
+			name:    "Valid",
+			record:  []string{"0151 00000001", "2"},
+			columns: defaultImportColumns,
+			want:    ImportRow{Phone: "+4915100000001", Group: 2, Language: "de"},
+		},
+		{
+			name:    "Language",
+			record:  []string{"Müller", "+4915100000001", "en", "1"},
+			columns: ImportColumns{Phone: 1, Group: 3, Language: 2},
+			want:    ImportRow{Phone: "+4915100000001", Group: 1, Language: "en"},
+		},
+		{
+			name:    "Missing phone",
+			record:  []string{"", "1"},
+			columns: defaultImportColumns,
+			want:    ImportRow{Error: "Fehlende Rufnummer"},
+		},
+		{
+			name:    "Invalid phone",
+			record:  []string{"abc", "1"},
+			columns: defaultImportColumns,
+			want:    ImportRow{Error: "Ungültige Rufnummer: abc"},
+		},
+		{
+			name:    "Missing group",
+			record:  []string{"015100000001"},
+			columns: defaultImportColumns,
+			want:    ImportRow{Error: "Fehlende Gruppe"},
+		},
+		{
+			name:    "Invalid group",
+			record:  []string{"015100000001", "0"},
+			columns: defaultImportColumns,
+			want:    ImportRow{Error: "Ungültige Gruppe: 0"},
+		},
+		{
+			name:    "Invalid language",
+			record:  []string{"015100000001", "1", "Klingonisch"},
+			columns: defaultImportColumns,
+			want:    ImportRow{Error: "Ungültige Sprache: Klingonisch"},
+		},
+		{
+			name:    "No column for the phone",
+			record:  []string{"015100000001", "1"},
+			columns: ImportColumns{Phone: -1, Group: 1, Language: -1},
+			want:    ImportRow{Error: "Fehlende Rufnummer"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row, err := newImportRow(0, 2, tt.record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			row.validate(tt.columns)
+
+			tt.want.Line, tt.want.Record = row.Line, row.Record
+			if diff := cmp.Diff(tt.want, row); diff != "" {
+				t.Errorf("ImportRow.validate() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_validateImport(t *testing.T) {
+
+	imp := Import{Header: true, ImportColumns: defaultImportColumns}
+	records := [][]string{
+		{"Rufnummer", "Gruppe"},
+		{"015100000001", "1"},
+		{"015100000002", "1"},
+		{"0151 000 000 01", "2"},
+		{"015100000003", "x"},
+		{"015100000003", "2"},
+	}
+
+	var rows []ImportRow
+	for k, record := range records {
+		row, err := newImportRow(0, k+1, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	validateImport(imp, rows)
+
+	var got []string
+	for _, row := range rows {
+		got = append(got, row.Phone+" "+row.Error)
+	}
+
+	// Only the first row of a phone number is imported, invalid rows don't
+	// count
+	want := []string{
+		" ",
+		"+4915100000001 ",
+		"+4915100000002 ",
+		" Rufnummer schon in Zeile: 2",
+		" Ungültige Gruppe: x",
+		"+4915100000003 ",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("validateImport() mismatch (-want +got):\n%s", diff)
+	}
+}
+
+func Test_writeImportReport(t *testing.T) {
+
+	f, err := os.Open("tests/import_example.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	records, err := readCSV(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imp := Import{Header: true, ImportColumns: defaultImportColumns}
+	var rows []ImportRow
+	for k, record := range records {
+		row, err := newImportRow(0, k+1, record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+	validateImport(imp, rows)
+
+	var buf bytes.Buffer
+	if err := writeImportReport(&buf, imp, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "Zeile;Fehler;Rufnummer;Gruppe\n3;Ungültige Gruppe: 0;11234993;0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writeImportReport() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import "time"
+
+// InboundMessage is a SMS received from a person. Every inbound message is
+// logged together with the action it was understood as and the result of
+// handling it.
+type InboundMessage struct {
+	ID         int       `db:"id"`
+	Phone      string    `db:"phone"`
+	Body       string    `db:"body"`
+	Action     Action    `db:"action"`
+	Result     string    `db:"result"`
+	ReceivedAt time.Time `db:"received_at"`
+}
+
+// Result of handling an inbound message
+const (
+	InboundOK           = "ok"            // Action was carried out
+	InboundNoInvitation = "no_invitation" // Accept or cancel without open invitation
+	InboundHelp         = "help"          // Not understood, help text sent
+	InboundError        = "error"         // Handling failed
+)
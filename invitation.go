@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// InvitationStatus is the status of an invitation. Changes of the status have
+// to follow the transitions defined in invitationTransitions
+type InvitationStatus string
+
+// Possible states of an invitation
+const (
+	// Invitation has been sent, waiting for a reply
+	InvitationNotified InvitationStatus = "notified"
+	// Person has accepted and got a spot
+	InvitationAccepted InvitationStatus = "accepted"
+	// Person has replied to not take the offer
+	InvitationDeclined InvitationStatus = "declined"
+	// Person did not reply in time
+	InvitationExpired InvitationStatus = "expired"
+	// Person tried to accept, but the call was already full
+	InvitationRejected InvitationStatus = "rejected"
+	// Person has cancelled an accepted appointment
+	InvitationCancelled InvitationStatus = "cancelled"
+	// Person showed up at the appointment
+	InvitationCheckedIn InvitationStatus = "checked-in"
+	// Person did not show up at the appointment
+	InvitationNoShow InvitationStatus = "no-show"
+)
+
+// actorSystem is recorded in the invitation history for changes which are not
+// caused by a person or user, e.g. expiry of invitations
+const actorSystem = "system"
+
+// actorProvider is recorded for changes caused by delivery receipts of the SMS
+// provider
+const actorProvider = "provider"
+
+// invitationTransitions maps each status to the statuses it may change to.
+// Statuses without entry are final.
+var invitationTransitions = map[InvitationStatus][]InvitationStatus{
+	InvitationNotified: {
+		InvitationAccepted,
+		InvitationDeclined,
+		InvitationExpired,
+		InvitationRejected,
+	},
+	InvitationAccepted: {
+		InvitationCancelled,
+		InvitationCheckedIn,
+		InvitationNoShow,
+	},
+}
+
+var (
+	// ErrInvitationNotFound is returned when acting on an invitation that
+	// does not exist
+	ErrInvitationNotFound = errors.New("invitation not found")
+
+	// ErrInvalidTransition is returned when trying to change the status of
+	// an invitation in a way that is not allowed
+	ErrInvalidTransition = errors.New("invalid invitation status transition")
+
+	// ErrNoOpenInvitation is returned when a person replies, but has no
+	// invitation for an active call the reply could refer to
+	ErrNoOpenInvitation = errors.New("no open invitation")
+
+	// ErrCallFull is returned when a person accepts an invitation, but all
+	// spots of the call have already been taken
+	ErrCallFull = errors.New("call is already full")
+)
+
+// CanTransition returns true if an invitation with status s may change to
+// status to
+func (s InvitationStatus) CanTransition(to InvitationStatus) bool {
+	for _, allowed := range invitationTransitions[s] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Final returns true if no further transitions are possible from s
+func (s InvitationStatus) Final() bool {
+	return len(invitationTransitions[s]) == 0
+}
+
+// transitionError creates an error for a transition that is not allowed. It
+// wraps ErrInvalidTransition, so it can be checked with errors.Is()
+func transitionError(id int, from, to InvitationStatus) error {
+	return fmt.Errorf("%w: invitation %d from %q to %q", ErrInvalidTransition, id, from, to)
+}
+
+// Invitation is a row of the invitations table. It is created when a person
+// is notified about a call and tracks the reply of the person. Invitations
+// sent for a call at the same time form a wave, which are numbered starting
+// at 1.
+type Invitation struct {
+	ID        int              `db:"id"`
+	Phone     string           `db:"phone"`
+	CallID    int              `db:"call_id"`
+	Status    InvitationStatus `db:"status"`
+	Time      time.Time        `db:"time"`
+	Wave      int              `db:"wave"`
+	ExpiresAt time.Time        `db:"expires_at"`
+}
+
+// InvitationEvent records a single status change of an invitation, together
+// with who triggered it. Actor is the phone number for replies of persons,
+// the username for changes in the web interface or "system" for automatic
+// changes.
+type InvitationEvent struct {
+	ID           int              `db:"id"`
+	InvitationID int              `db:"invitation_id"`
+	FromStatus   InvitationStatus `db:"from_status"`
+	ToStatus     InvitationStatus `db:"to_status"`
+	Actor        string           `db:"actor"`
+	Time         time.Time        `db:"time"`
+}
@@ -0,0 +1,57 @@
+package main
+
+import "testing"
+
+func TestInvitationStatus_CanTransition(t *testing.T) {
+	tests := []struct {
+		name string
+		from InvitationStatus
+		to   InvitationStatus
+		want bool
+	}{
+		{"Accept invitation", InvitationNotified, InvitationAccepted, true},
+		{"Decline invitation", InvitationNotified, InvitationDeclined, true},
+		{"Expire invitation", InvitationNotified, InvitationExpired, true},
+		{"Reject when full", InvitationNotified, InvitationRejected, true},
+		{"Cancel accepted", InvitationAccepted, InvitationCancelled, true},
+		{"Check-in accepted", InvitationAccepted, InvitationCheckedIn, true},
+		{"No-show accepted", InvitationAccepted, InvitationNoShow, true},
+		{"Cancel without accepting", InvitationNotified, InvitationCancelled, false},
+		{"Check-in without accepting", InvitationNotified, InvitationCheckedIn, false},
+		{"Expire accepted", InvitationAccepted, InvitationExpired, false},
+		{"Accept twice", InvitationAccepted, InvitationAccepted, false},
+		{"Accept expired", InvitationExpired, InvitationAccepted, false},
+		{"Accept cancelled", InvitationCancelled, InvitationAccepted, false},
+		{"Unknown status", InvitationStatus("foo"), InvitationAccepted, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.from.CanTransition(tt.to); got != tt.want {
+				t.Errorf("InvitationStatus.CanTransition() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInvitationStatus_Final(t *testing.T) {
+	tests := []struct {
+		status InvitationStatus
+		want   bool
+	}{
+		{InvitationNotified, false},
+		{InvitationAccepted, false},
+		{InvitationDeclined, true},
+		{InvitationExpired, true},
+		{InvitationRejected, true},
+		{InvitationCancelled, true},
+		{InvitationCheckedIn, true},
+		{InvitationNoShow, true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.status), func(t *testing.T) {
+			if got := tt.status.Final(); got != tt.want {
+				t.Errorf("InvitationStatus.Final() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
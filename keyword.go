@@ -0,0 +1,267 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Action is what a person wants to do with an inbound message
+type Action string
+
+// Actions of inbound messages
+const (
+	ActionUnknown Action = "unknown"
+	ActionAccept  Action = "accept" // Accept the open invitation
+	ActionCancel  Action = "cancel" // Decline or cancel the open invitation
+	ActionDelete  Action = "delete" // Delete the number
+
+	// Change the language of the messages, see ParseLanguage
+	ActionLanguage Action = "language"
+)
+
+// keywords maps the normalized keywords to their actions. Messages are
+// normalized before, see normalizeKeyword, so umlauts are written as "ae",
+// "oe" and "ue". Besides German, the keywords of the help texts in the other
+// languages are understood.
+var keywords = map[string]Action{
+	"ja":      ActionAccept,
+	"jaa":     ActionAccept,
+	"jo":      ActionAccept,
+	"yes":     ActionAccept,
+	"ok":      ActionAccept,
+	"okay":    ActionAccept,
+	"zusage":  ActionAccept,
+	"zusagen": ActionAccept,
+	"evet":    ActionAccept,
+	"tamam":   ActionAccept,
+	"نعم":     ActionAccept,
+	"موافق":   ActionAccept,
+
+	"storno":      ActionCancel,
+	"stornieren":  ActionCancel,
+	"stornierung": ActionCancel,
+	"nein":        ActionCancel,
+	"no":          ActionCancel,
+	"absage":      ActionCancel,
+	"absagen":     ActionCancel,
+	"cancel":      ActionCancel,
+	"iptal":       ActionCancel,
+	"إلغاء":       ActionCancel,
+	"الغاء":       ActionCancel,
+
+	"loeschen":  ActionDelete,
+	"loesche":   ActionDelete,
+	"loeschung": ActionDelete,
+	"stop":      ActionDelete,
+	"stopp":     ActionDelete,
+	"abmelden":  ActionDelete,
+	"delete":    ActionDelete,
+	"sil":       ActionDelete,
+	"حذف":       ActionDelete,
+}
+
+// languageKeywords start messages changing the language, e.g. "Sprache
+// Englisch" or "Language DE"
+var languageKeywords = map[string]bool{
+	"sprache":  true,
+	"language": true,
+	"lang":     true,
+	"dil":      true,
+	"لغة":      true,
+	"اللغة":    true,
+}
+
+// negations make a message ambiguous, e.g. "Bitte nicht löschen"
+var negations = map[string]bool{
+	"nicht": true,
+	"not":   true,
+}
+
+// negationPrefixes are negations which are inflected, e.g. "keine" or
+// "keinen"
+var negationPrefixes = []string{"kein"}
+
+// minTypoLength is the minimum length of keywords for which typos are
+// accepted. Short keywords like "ja" are too close to other words.
+const minTypoLength = 5
+
+// ParseKeyword returns the action of an inbound message. Case, umlauts and
+// punctuation are ignored and longer keywords with a single typo are
+// recognized, e.g. "ja!", "Ja bitte", "Loschen" or "Stormo". The first word of
+// the message is used. If it is not a keyword, the message is still understood
+// if all keywords in it accept or all cancel, e.g. "Ich muss leider absagen".
+// Deleting the number can't be undone, so it is only done if the message
+// starts with the keyword. Messages with a negation like "nicht" have no
+// action. Messages starting with a language keyword or consisting of the name
+// of a language change the language, see ParseLanguage.
+func ParseKeyword(body string) Action {
+
+	words := strings.Fields(normalizeKeyword(body))
+	if len(words) == 0 || negated(words) {
+		return ActionUnknown
+	}
+
+	if action := matchKeyword(words[0]); action != ActionUnknown {
+		return action
+	}
+
+	if languageKeywords[words[0]] {
+		return ActionLanguage
+	}
+	if _, ok := languageAliases[strings.Join(words, " ")]; ok {
+		return ActionLanguage
+	}
+
+	found := ActionUnknown
+	for _, word := range words[1:] {
+		action := matchKeyword(word)
+		if action == ActionUnknown {
+			continue
+		}
+		if action == ActionDelete {
+			return ActionUnknown
+		}
+		if found != ActionUnknown && found != action {
+			return ActionUnknown
+		}
+		found = action
+	}
+
+	return found
+}
+
+// ParseLanguage returns the language a message changing the language asks
+// for, e.g. "en" for "Sprache Englisch", "Language EN" or "English". Returns an
+// empty string if the language is unknown or missing.
+func ParseLanguage(body string) string {
+
+	words := strings.Fields(normalizeKeyword(body))
+	if len(words) > 0 && languageKeywords[words[0]] {
+		words = words[1:]
+	}
+	if len(words) == 0 {
+		return ""
+	}
+
+	lang, err := parseLanguage(strings.Join(words, " "))
+	if err != nil {
+		return ""
+	}
+	return lang
+}
+
+// negated reports whether one of the normalized words is a negation
+func negated(words []string) bool {
+
+	for _, word := range words {
+		if negations[word] {
+			return true
+		}
+		for _, prefix := range negationPrefixes {
+			if strings.HasPrefix(word, prefix) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// matchKeyword returns the action of a single normalized word
+func matchKeyword(word string) Action {
+
+	if action, ok := keywords[word]; ok {
+		return action
+	}
+
+	// Umlauts written without "e", e.g. "loschen"
+	for keyword, action := range keywords {
+		if strings.Contains(keyword, "e") && utf8.RuneCountInString(keyword) >= minTypoLength &&
+			stripUmlautE(keyword) == word {
+			return action
+		}
+	}
+
+	// A single typo in longer keywords. Only accepted if it is unambiguous
+	found := ActionUnknown
+	for keyword, action := range keywords {
+		if utf8.RuneCountInString(keyword) < minTypoLength || editDistance(keyword, word) > 1 {
+			continue
+		}
+		if found != ActionUnknown && found != action {
+			return ActionUnknown
+		}
+		found = action
+	}
+
+	return found
+}
+
+// normalizeKeyword lowercases the message, replaces umlauts and removes
+// everything except letters, digits and single spaces. The dot the Turkish
+// "İ" keeps when lowercased is removed as well.
+func normalizeKeyword(body string) string {
+
+	replacer := strings.NewReplacer("ä", "ae", "ö", "oe", "ü", "ue", "ß", "ss", "\u0307", "")
+	body = replacer.Replace(strings.ToLower(body))
+
+	var b strings.Builder
+	for _, r := range body {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			b.WriteRune(r)
+		default:
+			b.WriteRune(' ')
+		}
+	}
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// stripUmlautE turns the replaced umlauts "ae", "oe" and "ue" of a keyword
+// into plain vowels
+func stripUmlautE(keyword string) string {
+	return strings.NewReplacer("ae", "a", "oe", "o", "ue", "u").Replace(keyword)
+}
+
+// editDistance returns the optimal string alignment distance of a and b, the
+// number of inserted, deleted or replaced characters and swapped adjacent
+// characters needed to turn a into b
+func editDistance(a, b string) int {
+
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min3(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && d[i-2][j-2]+1 < d[i][j] {
+				d[i][j] = d[i-2][j-2] + 1
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
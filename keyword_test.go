@@ -0,0 +1,106 @@
+package main
+
+import "testing"
+
+func TestParseKeyword(t *testing.T) {
+	tests := []struct {
+		body string
+		want Action
+	}{
+		{"JA", ActionAccept},
+		{"ja", ActionAccept},
+		{"ja!", ActionAccept},
+		{" Ja. ", ActionAccept},
+		{"Ja bitte", ActionAccept},
+		{"Jaa", ActionAccept},
+		{"ok", ActionAccept},
+		{"Zusage", ActionAccept},
+		{"Zusgae", ActionAccept},
+		{"STORNO", ActionCancel},
+		{"Storno!!", ActionCancel},
+		{"stormo", ActionCancel},
+		{"Nein danke", ActionCancel},
+		{"Ich muss leider absagen", ActionCancel},
+		{"LÖSCHEN", ActionDelete},
+		{"LOESCHEN", ActionDelete},
+		{"Loschen", ActionDelete},
+		{"löschn", ActionDelete},
+		{"STOP", ActionDelete},
+		{"Bitte löschen.", ActionUnknown},
+		{"Bitte nicht löschen", ActionUnknown},
+		{"stop bitte nicht", ActionUnknown},
+		{"Ich will nicht absagen", ActionUnknown},
+		{"Keine Zusage", ActionUnknown},
+		{"Keinen Termin", ActionUnknown},
+		{"ok, not yet", ActionUnknown},
+		{"No", ActionCancel},
+		{"no thanks", ActionCancel},
+		{"ja notiert", ActionAccept},
+		{"Storno, notfalls später", ActionCancel},
+		{"", ActionUnknown},
+		{"?!", ActionUnknown},
+		{"Hallo", ActionUnknown},
+		{"jap", ActionUnknown},
+		{"Wann ist der Termin", ActionUnknown},
+		{"Bitte nicht ja sondern nein", ActionUnknown},
+		{"Evet", ActionAccept},
+		{"İPTAL", ActionCancel},
+		{"sil", ActionDelete},
+		{"نعم", ActionAccept},
+		{"إلغاء", ActionCancel},
+		{"Sprache Englisch", ActionLanguage},
+		{"LANGUAGE", ActionLanguage},
+		{"English", ActionLanguage},
+		{"Türkçe", ActionLanguage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if got := ParseKeyword(tt.body); got != tt.want {
+				t.Errorf("ParseKeyword(%q) = %v, want %v", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"SPRACHE EN", "en"},
+		{"Sprache Englisch", "en"},
+		{"dil türkçe", "tr"},
+		{"Language: Deutsch", "de"},
+		{"English", "en"},
+		{"لغة العربية", "ar"},
+		{"Sprache", ""},
+		{"Sprache Klingonisch", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			if got := ParseLanguage(tt.body); got != tt.want {
+				t.Errorf("ParseLanguage(%q) = %q, want %q", tt.body, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_editDistance(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"storno", "storno", 0},
+		{"storno", "stormo", 1},
+		{"storno", "stornoo", 1},
+		{"storno", "sotrno", 1},
+		{"storno", "sotnro", 2},
+		{"", "ja", 2},
+	}
+	for _, tt := range tests {
+		if got := editDistance(tt.a, tt.b); got != tt.want {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
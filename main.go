@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
+	"expvar"
 	"html/template"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -27,8 +34,29 @@ var (
 	apiUser     string
 	apiPass     string
 	tokenSecret string
-	disableSMS  string
+	dbDriver    string
 	dbPath      string
+	smsProvider string
+	messageDir  string
+
+	// Verification of X-Twilio-Signature on the API, see verifyTwilioRequest
+	verifySignature bool
+	twilioAuthToken string
+	publicURL       string
+
+	// Salt of the hashes of phone numbers in the suppression list, see
+	// suppressionHash
+	suppressionSalt string
+
+	// Interval the scheduler runs in when no event triggers it earlier
+	schedulerInterval time.Duration
+
+	// HTTP server configuration
+	bindAddress     string
+	readTimeout     time.Duration
+	writeTimeout    time.Duration
+	idleTimeout     time.Duration
+	shutdownTimeout time.Duration
 )
 
 // User holds a users account information
@@ -60,14 +88,78 @@ func init() {
 	apiUser = os.Getenv("IMPF_TWILIO_USER")
 	apiPass = os.Getenv("IMPF_TWILIO_PASS")
 	tokenSecret = os.Getenv("IMPF_TOKEN_SECRET")
-	disableSMS = os.Getenv("IMPF_DISABLE_SMS")
-	dbPath = os.Getenv("IMPF_DB_FILE")
 
-	// Add default if not set
+	// Database backend, "sqlite3" (default) or "postgres". IMPF_DB_DSN is
+	// the connection string, for SQLite IMPF_DB_FILE is accepted as well
+	dbDriver = os.Getenv("IMPF_DB_DRIVER")
+	dbPath = os.Getenv("IMPF_DB_DSN")
+
+	if dbDriver == "" {
+		dbDriver = "sqlite3"
+	}
+
 	if dbPath == "" {
+		dbPath = os.Getenv("IMPF_DB_FILE")
+	}
+
+	// Add default if not set
+	if dbPath == "" && dbDriver == "sqlite3" {
 		dbPath = "./data.db"
 	}
 
+	// SMS provider, see NewMessageSender. IMPF_DISABLE_SMS from older
+	// configurations writes messages to stdout instead of sending them
+	smsProvider = os.Getenv("IMPF_SMS_PROVIDER")
+	if smsProvider == "" && os.Getenv("IMPF_DISABLE_SMS") != "" {
+		log.Warn("IMPF_DISABLE_SMS is deprecated, use IMPF_SMS_PROVIDER=file")
+		smsProvider = providerFile
+	}
+
+	// Directory with message templates replacing the built-in ones, see
+	// NewMessageTemplates
+	messageDir = os.Getenv("IMPF_MESSAGE_DIR")
+
+	// Requests to the API can be verified to be sent by Twilio. The auth
+	// token defaults to the one used for sending. IMPF_PUBLIC_URL is the URL
+	// the application is reached at by Twilio, if it is behind a proxy
+	verifySignature = os.Getenv("IMPF_TWILIO_VERIFY_SIGNATURE") == "true"
+	twilioAuthToken = os.Getenv("IMPF_TWILIO_AUTH_TOKEN")
+	if twilioAuthToken == "" {
+		twilioAuthToken = os.Getenv("IMPF_TWILIO_API_PASS")
+	}
+	publicURL = os.Getenv("IMPF_PUBLIC_URL")
+
+	if verifySignature && twilioAuthToken == "" {
+		log.Fatal("IMPF_TWILIO_VERIFY_SIGNATURE is set, but no auth token. Set IMPF_TWILIO_AUTH_TOKEN")
+	}
+
+	// Salt of the suppression list. If not set, a random salt is saved in
+	// the database, see loadSuppressionSalt
+	suppressionSalt = os.Getenv("IMPF_SUPPRESSION_SALT")
+
+	// Delivery of the outbox
+	outboxWorkers = intFromEnv("IMPF_OUTBOX_WORKERS", outboxWorkers)
+	outboxMaxAttempts = intFromEnv("IMPF_OUTBOX_MAX_ATTEMPTS", outboxMaxAttempts)
+	undeliveredThreshold = intFromEnv("IMPF_UNDELIVERED_THRESHOLD", undeliveredThreshold)
+
+	// Length and encoding of messages, see analyzeSMS
+	smsSegmentBudget = intFromEnv("IMPF_SMS_SEGMENT_BUDGET", smsSegmentBudget)
+	smsTransliterate = os.Getenv("IMPF_SMS_TRANSLITERATE") == "true"
+
+	// Scheduler interval as duration, e.g. "15m"
+	schedulerInterval = durationFromEnv("IMPF_SCHEDULER_INTERVAL", defaultSchedulerInterval)
+
+	// HTTP server. Timeouts are durations, e.g. "30s"
+	bindAddress = os.Getenv("IMPF_BIND_ADDRESS")
+	if bindAddress == "" {
+		bindAddress = "localhost:12000"
+	}
+
+	readTimeout = durationFromEnv("IMPF_HTTP_READ_TIMEOUT", 15*time.Second)
+	writeTimeout = durationFromEnv("IMPF_HTTP_WRITE_TIMEOUT", 30*time.Second)
+	idleTimeout = durationFromEnv("IMPF_HTTP_IDLE_TIMEOUT", 60*time.Second)
+	shutdownTimeout = durationFromEnv("IMPF_SHUTDOWN_TIMEOUT", 30*time.Second)
+
 	// Intial setup. Instanciate bridge and parse html templates
 	log.Info("Parsing templates")
 	templates = parseTemplates()
@@ -91,6 +183,7 @@ func main() {
 	router.HandleFunc("/", loginHandler)            // Login
 	router.HandleFunc("/authenticate", authHandler) // Authenticate
 	router.HandleFunc("/forbidden", forbiddenHandler)
+	router.HandleFunc("/lang", handlerLanguage) // Language of the web UI
 
 	// Router for all routes under https://domain.tld/auth/ will have to pass
 	// through the authentication middleware. Put any routes here, that should
@@ -98,22 +191,90 @@ func main() {
 
 	subRouterAPI := router.PathPrefix("/api").Subrouter()
 	subRouterAPI.Use(middlewareAPI)
+	subRouterAPI.HandleFunc("/status", handlerStatusCallback) // Delivery receipts
+	subRouterAPI.HandleFunc("/sms", handlerInbound)           // Inbound SMS
 	subRouterAPI.HandleFunc("/{endpoint}", handlerAPI)
 
 	subRouterAuth := router.PathPrefix("/auth").Subrouter()
 	subRouterAuth.Use(middlewareAuth)
-	subRouterAuth.HandleFunc("/call", handlerSendCall)           // Send a call
-	subRouterAuth.HandleFunc("/active/{id}", handlerActiveCalls) // Get call details
-	subRouterAuth.HandleFunc("/active", handlerActiveCalls)      // List active calls
-	subRouterAuth.HandleFunc("/add", handlerAddPerson)           // Add single person
-	subRouterAuth.HandleFunc("/upload", handlerUpload)           // CSV upload
+	subRouterAuth.HandleFunc("/call", handlerSendCall)              // Send a call
+	subRouterAuth.HandleFunc("/active/{id}", handlerActiveCalls)    // Get call details
+	subRouterAuth.HandleFunc("/active", handlerActiveCalls)         // List active calls
+	subRouterAuth.HandleFunc("/add", handlerAddPerson)              // Add single person
+	subRouterAuth.HandleFunc("/upload", handlerUpload)              // Import of persons
+	subRouterAuth.HandleFunc("/upload/errors", handlerUploadErrors) // Error report of an import
+	subRouterAuth.HandleFunc("/schedule", handlerSchedule)          // Run scheduler now
+	subRouterAuth.HandleFunc("/messages", handlerMessages)          // Outbox status
+	subRouterAuth.HandleFunc("/templates", handlerTemplates)        // Message templates
+
+	// Only admins may lift suppressions and read the counters, which include
+	// the command line and memory statistics
+	subRouterAuth.Handle("/suppressions", middlewareAdmin(http.HandlerFunc(handlerSuppressions)))
+	subRouterAuth.Handle("/debug/vars", middlewareAdmin(expvar.Handler()))
 
 	handler := middlewareLog(router)
 
+	server := &http.Server{
+		Addr:         bindAddress,
+		Handler:      handler,
+		ReadTimeout:  readTimeout,
+		WriteTimeout: writeTimeout,
+		IdleTimeout:  idleTimeout,
+	}
+
 	// Bind to addrerss, with specified routing
-	bindAddress := "localhost:12000"
 	log.Info("Starting server on: ", bindAddress)
-	log.Fatal(http.ListenAndServe(bindAddress, handler))
+	listener, err := net.Listen("tcp", bindAddress)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGTERM, os.Interrupt)
+
+	if err := runServer(server, listener, stop, shutdownTimeout); err != nil {
+		log.Error(err)
+	}
+
+	// No requests are handled anymore. Stop the scheduler, send what is
+	// left in the outbox and close the database
+	if err := bridge.Close(); err != nil {
+		log.Error(err)
+	}
+
+	log.Info("Shutdown complete")
+}
+
+// runServer serves requests on listener until a signal is received on stop.
+// Then the server stops accepting new connections and waits up to timeout for
+// requests in progress to finish. Returns an error if serving fails or the
+// requests could not be drained in time.
+func runServer(server *http.Server, listener net.Listener, stop <-chan os.Signal, timeout time.Duration) error {
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-stop:
+		log.Infof("Received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	// Serve returns http.ErrServerClosed after a shutdown
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
 func middlewareAPI(h http.Handler) http.Handler {
@@ -134,6 +295,23 @@ func middlewareAPI(h http.Handler) http.Handler {
 			return
 		}
 
+		// Check the request was sent by Twilio, if enabled
+		if verifySignature {
+			err := verifyTwilioRequest(w, r, twilioAuthToken, publicURL)
+			if err != nil && !errors.Is(err, ErrSignatureMissing) && !errors.Is(err, ErrSignatureInvalid) {
+				log.Warnf("Failed to read API request to %s from %s: %v", r.URL.Path, r.RemoteAddr, err)
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				signatureRejected.Add(1)
+				log.Warnf("Rejected API request to %s from %s (%d rejected so far): %v",
+					r.URL.Path, r.RemoteAddr, signatureRejected.Value(), err)
+				http.Error(w, "Forbidden.", http.StatusForbidden)
+				return
+			}
+		}
+
 		h.ServeHTTP(w, r)
 	})
 }
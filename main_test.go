@@ -0,0 +1,117 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func Test_runServer(t *testing.T) {
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Handler which is still busy when the shutdown starts
+	started := make(chan struct{})
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-time.After(200 * time.Millisecond)
+			w.Write([]byte("done"))
+		}),
+	}
+
+	stop := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- runServer(server, listener, stop, 5*time.Second)
+	}()
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		res, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+
+	<-started
+	stop <- syscall.SIGTERM
+
+	// The request in progress is completed before the server stops
+	res := <-responses
+	if res.err != nil || res.body != "done" {
+		t.Errorf("response = %q, %v, want %q", res.body, res.err, "done")
+	}
+
+	if err := <-result; err != nil {
+		t.Errorf("runServer() error = %v", err)
+	}
+
+	// New connections are not accepted anymore
+	if _, err := http.Get("http://" + listener.Addr().String()); err == nil {
+		t.Error("Server still accepting requests after shutdown")
+	}
+}
+
+func Test_middlewareAPISignature(t *testing.T) {
+
+	defer func(v bool, token, u, user, pass string) {
+		verifySignature, twilioAuthToken, publicURL, apiUser, apiPass = v, token, u, user, pass
+	}(verifySignature, twilioAuthToken, publicURL, apiUser, apiPass)
+
+	recorded := loadSignedRequests(t)
+	verifySignature = true
+	twilioAuthToken = recorded.Token
+	apiUser, apiPass = "twilio", "secret"
+
+	handler := middlewareAPI(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, tt := range recorded.Requests {
+		t.Run(tt.Name, func(t *testing.T) {
+			publicURL = tt.PublicURL
+
+			r := httptest.NewRequest(http.MethodPost, tt.Path, strings.NewReader(tt.Body))
+			r.SetBasicAuth(apiUser, apiPass)
+			r.Header.Set("Content-Type", tt.ContentType)
+			if tt.Signature != "" {
+				r.Header.Set("X-Twilio-Signature", tt.Signature)
+			}
+
+			rejectedBefore := signatureRejected.Value()
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			wantCode, wantRejected := http.StatusOK, rejectedBefore
+			if !tt.Valid {
+				wantCode, wantRejected = http.StatusForbidden, rejectedBefore+1
+			}
+
+			if w.Code != wantCode {
+				t.Errorf("middlewareAPI() status = %d, want %d", w.Code, wantCode)
+			}
+			if got := signatureRejected.Value(); got != wantRejected {
+				t.Errorf("rejected requests = %d, want %d", got, wantRejected)
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"text/template/parse"
+	"time"
+)
+
+// Texts of the SMS sent to persons are text/template templates. Built-in
+// defaults for every language are embedded from ./messages/<language>. They
+// can be replaced by files in the directory set with IMPF_MESSAGE_DIR and by
+// templates edited in the web UI, which are saved in the database. Each of
+// them can be set for all centers or for a single vaccination center.
+
+//go:embed messages/*/*.txt
+var defaultMessageFiles embed.FS
+
+// Names of the message templates
+const (
+	messageOnboarding   = "onboarding"    // Number has been added
+	messageNotify       = "notify"        // Invitation to a call
+	messageReject       = "reject"        // Call was full when the person accepted
+	messageAccept       = "accept"        // Person got a seat
+	messageDelete       = "delete"        // Number has been deleted
+	messageHelp         = "help"          // Reply was not understood
+	messageNoInvitation = "no_invitation" // Reply without open invitation
+	messageLanguage     = "language"      // Language of the messages changed
+)
+
+// messageRequired lists the placeholders each message template has to contain.
+// Only templates listed here exist.
+var messageRequired = map[string][]string{
+	messageOnboarding:   nil,
+	messageNotify:       {"Day", "Start", "End", "LocName"},
+	messageReject:       nil,
+	messageAccept:       {"Day", "Start", "End", "LocName", "OTP"},
+	messageDelete:       nil,
+	messageHelp:         nil,
+	messageNoInvitation: nil,
+	messageLanguage:     nil,
+}
+
+// messageTitles are the names of the message templates shown in the web UI
+var messageTitles = map[string]string{
+	messageOnboarding:   "Begrüßung",
+	messageNotify:       "Einladung",
+	messageReject:       "Ruf bereits voll",
+	messageAccept:       "Terminbestätigung",
+	messageDelete:       "Rufnummer gelöscht",
+	messageHelp:         "Hilfe bei unbekannter Antwort",
+	messageNoInvitation: "Keine offene Einladung",
+	messageLanguage:     "Sprache geändert",
+}
+
+// messageNames returns the names of all message templates, sorted
+func messageNames() []string {
+	names := make([]string, 0, len(messageRequired))
+	for name := range messageRequired {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MessageData holds the values for the placeholders of the message templates,
+// e.g. {{.Start}}. Templates not about a call only get empty values. Days are
+// written in the language of the message.
+type MessageData struct {
+	Day        string // Day of the call, "heute", "morgen" or "am Mittwoch, 10.02.2021"
+	Date       string // Date of the call, e.g. "10.02.2021"
+	Weekday    string // Weekday of the call, e.g. "Mittwoch"
+	Start      string // Start of the call, e.g. "14:00"
+	End        string // End of the call
+	LocName    string
+	LocStreet  string
+	LocHouseNr string
+	LocPLZ     string
+	LocCity    string
+	LocOpt     string
+	OTP        string // ID of the person to show on-site
+}
+
+// newMessageData returns the placeholder values for a call in a language.
+// Times are formatted in callZone, the day is relative to now.
+func newMessageData(call Call, now time.Time, lang string) MessageData {
+	return MessageData{
+		Day:        formatDay(call.TimeStart, now, lang),
+		Date:       formatDate(call.TimeStart, lang),
+		Weekday:    formatWeekday(call.TimeStart, lang),
+		Start:      formatTime(call.TimeStart),
+		End:        formatTime(call.TimeEnd),
+		LocName:    call.LocName,
+		LocStreet:  call.LocStreet,
+		LocHouseNr: call.LocHouseNr,
+		LocPLZ:     call.LocPLZ,
+		LocCity:    call.LocCity,
+		LocOpt:     call.LocOpt,
+	}
+}
+
+// sampleCall is used to validate templates and for the preview in the web UI
+var sampleCall = Call{
+	TimeStart:  time.Date(2021, 2, 10, 13, 0, 0, 0, time.UTC),
+	TimeEnd:    time.Date(2021, 2, 10, 15, 0, 0, 0, time.UTC),
+	LocName:    "Impfzentrum",
+	LocStreet:  "Musterstraße",
+	LocHouseNr: "1",
+	LocPLZ:     "47051",
+	LocCity:    "Duisburg",
+	LocOpt:     "Eingang B",
+}
+
+// sampleMessageData returns the placeholder values of sampleCall in a
+// language, e.g. "am Mittwoch, 10.02.2021" from 14:00 to 16:00
+func sampleMessageData(lang string) MessageData {
+	data := newMessageData(sampleCall, sampleCall.TimeStart.AddDate(0, 0, -7), lang)
+	data.OTP = "a1b2"
+	return data
+}
+
+// MessageTemplate is a message template saved in the database. CenterID 0
+// applies to all centers without a template of their own.
+type MessageTemplate struct {
+	CenterID  int       `db:"center_id"`
+	Language  string    `db:"language"`
+	Name      string    `db:"name"`
+	Body      string    `db:"body"`
+	UpdatedAt time.Time `db:"updated_at"`
+	UpdatedBy string    `db:"updated_by"`
+}
+
+// Sources of message templates, from lowest to highest priority
+const (
+	SourceDefault  = "default"  // Built into the application
+	SourceFile     = "file"     // From IMPF_MESSAGE_DIR
+	SourceDatabase = "database" // Edited in the web UI
+)
+
+// ErrUnknownMessage is returned for names not in messageRequired
+var ErrUnknownMessage = errors.New("unknown message template")
+
+// messageKey identifies a message template of a center in a language
+type messageKey struct {
+	centerID int
+	language string
+	name     string
+}
+
+// loadedMessage is a parsed message template and where it came from
+type loadedMessage struct {
+	tmpl   *template.Template
+	body   string
+	source string
+}
+
+// MessageTemplates holds the message templates of all centers. Templates from
+// the database are kept in memory, changes are written through.
+type MessageTemplates struct {
+	store Store
+
+	mu       sync.RWMutex
+	files    map[messageKey]loadedMessage // Built-in and from the directory
+	database map[messageKey]loadedMessage
+}
+
+// NewMessageTemplates loads the built-in templates, the templates in dir and
+// the ones saved in store. dir may be empty. Each file in dir is named like
+// the template, e.g. "notify.txt", templates for a single center are in a
+// subdirectory named by the ID of the center. Files are in the default
+// language unless they are in a subdirectory named by the language, e.g.
+// "en/notify.txt" or "2/en/notify.txt". All templates are validated, see
+// parseMessage.
+func NewMessageTemplates(dir string, store Store) (*MessageTemplates, error) {
+
+	m := &MessageTemplates{
+		store:    store,
+		files:    map[messageKey]loadedMessage{},
+		database: map[messageKey]loadedMessage{},
+	}
+
+	for _, lang := range languages {
+		for _, name := range messageNames() {
+			body, err := defaultMessageFiles.ReadFile("messages/" + lang + "/" + name + ".txt")
+			if err != nil {
+				return nil, err
+			}
+			if err := m.addFile(messageKey{0, lang, name}, string(body), SourceDefault); err != nil {
+				return nil, fmt.Errorf("built-in message %s/%s: %w", lang, name, err)
+			}
+		}
+	}
+
+	if dir != "" {
+		if err := m.loadDir(dir); err != nil {
+			return nil, err
+		}
+	}
+
+	saved, err := store.GetMessageTemplates()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range saved {
+		tmpl, err := parseMessage(t.Name, t.Body)
+		if err != nil {
+			return nil, fmt.Errorf("message %s/%s of center %d in database: %w", t.Language, t.Name, t.CenterID, err)
+		}
+		m.database[messageKey{t.CenterID, t.Language, t.Name}] = loadedMessage{tmpl, t.Body, SourceDatabase}
+	}
+
+	return m, nil
+}
+
+// loadDir reads the templates from dir and its subdirectories for centers and
+// languages
+func (m *MessageTemplates) loadDir(dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(path) != ".txt" {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		key := messageKey{language: defaultLanguage, name: strings.TrimSuffix(filepath.Base(rel), ".txt")}
+		if sub := filepath.Dir(rel); sub != "." {
+			for _, part := range strings.Split(filepath.ToSlash(sub), "/") {
+				if _, ok := languageNames[part]; ok {
+					key.language = part
+				} else if key.centerID, err = strconv.Atoi(part); err != nil {
+					return fmt.Errorf("message %s: directory is neither the ID of a center nor a language", path)
+				}
+			}
+		}
+
+		body, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err := m.addFile(key, string(body), SourceFile); err != nil {
+			return fmt.Errorf("message %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// addFile parses and adds a template from a file
+func (m *MessageTemplates) addFile(key messageKey, body, source string) error {
+	tmpl, err := parseMessage(key.name, body)
+	if err != nil {
+		return err
+	}
+	m.files[key] = loadedMessage{tmpl, body, source}
+	return nil
+}
+
+// lookup returns the template used for a center in a language. A template in
+// the language is preferred over one in the default language, then a template
+// of the center itself over one for all centers and a template from the
+// database over one from a file.
+func (m *MessageTemplates) lookup(centerID int, lang, name string) (loadedMessage, error) {
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	langs := []string{lang}
+	if lang != defaultLanguage {
+		langs = append(langs, defaultLanguage)
+	}
+
+	centers := []int{centerID}
+	if centerID != 0 {
+		centers = append(centers, 0)
+	}
+
+	for _, l := range langs {
+		for _, c := range centers {
+			if msg, ok := m.database[messageKey{c, l, name}]; ok {
+				return msg, nil
+			}
+			if msg, ok := m.files[messageKey{c, l, name}]; ok {
+				return msg, nil
+			}
+		}
+	}
+
+	return loadedMessage{}, fmt.Errorf("%w: %s", ErrUnknownMessage, name)
+}
+
+// Render returns the text of a message for a center in a language
+func (m *MessageTemplates) Render(centerID int, lang, name string, data MessageData) (string, error) {
+
+	msg, err := m.lookup(centerID, lang, name)
+	if err != nil {
+		return "", err
+	}
+
+	return executeMessage(msg.tmpl, data)
+}
+
+// Source returns the body of the template used for a center in a language and
+// where it comes from
+func (m *MessageTemplates) Source(centerID int, lang, name string) (string, string, error) {
+	msg, err := m.lookup(centerID, lang, name)
+	return msg.body, msg.source, err
+}
+
+// Custom returns true if the center has a template of its own in the language
+// in the database, which can be reset
+func (m *MessageTemplates) Custom(centerID int, lang, name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, ok := m.database[messageKey{centerID, lang, name}]
+	return ok
+}
+
+// Save validates a template and saves it to the database
+func (m *MessageTemplates) Save(t MessageTemplate) error {
+
+	if _, ok := languageNames[t.Language]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownLanguage, t.Language)
+	}
+
+	tmpl, err := parseMessage(t.Name, t.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := m.store.SaveMessageTemplate(t); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	m.database[messageKey{t.CenterID, t.Language, t.Name}] = loadedMessage{tmpl, t.Body, SourceDatabase}
+	m.mu.Unlock()
+
+	return nil
+}
+
+// Reset deletes the template of a center in a language from the database, the
+// template from the files or for all centers is used again
+func (m *MessageTemplates) Reset(centerID int, lang, name string) error {
+
+	if err := m.store.DeleteMessageTemplate(centerID, lang, name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	delete(m.database, messageKey{centerID, lang, name})
+	m.mu.Unlock()
+
+	return nil
+}
+
+// parseMessage parses the body of a message template and validates it: the
+// template has to exist, may only use the placeholders of MessageData and has
+// to contain the placeholders listed in messageRequired. Errors are shown to
+// admins in the web UI.
+func parseMessage(name, body string) (*template.Template, error) {
+
+	required, ok := messageRequired[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMessage, name)
+	}
+
+	tmpl, err := template.New(name).Parse(strings.TrimSpace(body))
+	if err != nil {
+		return nil, fmt.Errorf("Ungültige Vorlage: %w", err)
+	}
+
+	used := map[string]bool{}
+	if tmpl.Tree != nil {
+		collectFields(tmpl.Tree.Root, used)
+	}
+
+	var missing []string
+	for _, field := range required {
+		if !used[field] {
+			missing = append(missing, "{{."+field+"}}")
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("Fehlende Platzhalter: %s", strings.Join(missing, ", "))
+	}
+
+	// Unknown placeholders fail when executed
+	if _, err := executeMessage(tmpl, sampleMessageData(defaultLanguage)); err != nil {
+		return nil, fmt.Errorf("Ungültige Vorlage: %w", err)
+	}
+
+	return tmpl, nil
+}
+
+// executeMessage renders a message template
+func executeMessage(tmpl *template.Template, data MessageData) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// messagePlaceholders returns the names of all placeholders of MessageData
+func messagePlaceholders() []string {
+	t := reflect.TypeOf(MessageData{})
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i).Name
+	}
+	return fields
+}
+
+// collectFields adds the fields of the data used in the template below node to
+// used, e.g. "Start" for {{.Start}} or {{$.Start}}
+func collectFields(node parse.Node, used map[string]bool) {
+
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			collectFields(c, used)
+		}
+	case *parse.ActionNode:
+		collectFields(n.Pipe, used)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Cmds {
+			collectFields(c, used)
+		}
+	case *parse.CommandNode:
+		for _, c := range n.Args {
+			collectFields(c, used)
+		}
+	case *parse.ChainNode:
+		collectFields(n.Node, used)
+	case *parse.FieldNode:
+		used[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			used[n.Ident[1]] = true
+		}
+	case *parse.IfNode:
+		collectBranch(&n.BranchNode, used)
+	case *parse.RangeNode:
+		collectBranch(&n.BranchNode, used)
+	case *parse.WithNode:
+		collectBranch(&n.BranchNode, used)
+	case *parse.TemplateNode:
+		collectFields(n.Pipe, used)
+	}
+}
+
+// collectBranch collects the fields of if, range and with
+func collectBranch(n *parse.BranchNode, used map[string]bool) {
+	collectFields(n.Pipe, used)
+	collectFields(n.List, used)
+	collectFields(n.ElseList, used)
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// updateGolden rewrites the golden files of the tests instead of comparing
+// with them: go test -run Golden -update
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+// TestMessages_Golden renders every built-in message template in every
+// language for a call and compares the text with
+// testdata/golden/messages/<language>
+func TestMessages_Golden(t *testing.T) {
+
+	m, err := NewMessageTemplates("", bridge.store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	call := Call{
+		ID:         1,
+		TimeStart:  time.Date(2021, 1, 2, 9, 30, 0, 0, time.UTC),
+		TimeEnd:    time.Date(2021, 1, 2, 11, 0, 0, 0, time.UTC),
+		LocName:    "Impfzentrum Theater am Marientor",
+		LocStreet:  "Plessingstraße",
+		LocHouseNr: "20",
+		LocPLZ:     "47051",
+		LocCity:    "Duisburg",
+		LocOpt:     "Eingang B",
+	}
+
+	// Calls on other days and without optional location
+	today, later := call, call
+	today.TimeStart, today.TimeEnd = time.Now().Add(time.Hour), time.Now().Add(2*time.Hour)
+	today.LocOpt = ""
+	later.TimeStart, later.TimeEnd = time.Date(2021, 6, 10, 13, 5, 0, 0, time.UTC), time.Date(2021, 6, 10, 14, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		file string
+		name string
+		call Call
+	}{
+		{"accept", messageAccept, call},
+		{"delete", messageDelete, call},
+		{"help", messageHelp, call},
+		{"no_invitation", messageNoInvitation, call},
+		{"notify", messageNotify, call},
+		{"notify_later", messageNotify, later},
+		{"notify_today", messageNotify, today},
+		{"onboarding", messageOnboarding, call},
+		{"reject", messageReject, call},
+		{"language", messageLanguage, call},
+	}
+	for _, lang := range languages {
+		for _, tt := range tests {
+			t.Run(lang+"/"+tt.file, func(t *testing.T) {
+
+				data := newMessageData(tt.call, time.Now(), lang)
+				data.OTP = "a1b2"
+
+				got, err := m.Render(0, lang, tt.name, data)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				golden := filepath.Join("testdata", "golden", "messages", lang, tt.file+".txt")
+				if *updateGolden {
+					if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, []byte(got+"\n"), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != strings.TrimSuffix(string(want), "\n") {
+					t.Errorf("message %s differs from %s:\ngot:  %s\nwant: %s", tt.name, golden, got, want)
+				}
+			})
+		}
+	}
+}
+
+func Test_parseMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		body    string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "Without placeholders",
+			tmpl: messageDelete,
+			body: "Gelöscht.\n",
+			want: "Gelöscht.",
+		},
+		{
+			name: "All required placeholders",
+			tmpl: messageAccept,
+			body: "{{.LocName}} {{.Day}} {{.Start}}-{{.End}}, ID {{.OTP}}",
+			want: "Impfzentrum am Mittwoch, 10.02.2021 14:00-16:00, ID a1b2",
+		},
+		{
+			name: "Placeholders in conditions",
+			tmpl: messageNotify,
+			body: "{{if .LocName}}{{.LocName}}{{end}}{{with .Start}} {{$.Day}} {{.}}-{{$.End}}{{end}}",
+			want: "Impfzentrum am Mittwoch, 10.02.2021 14:00-16:00",
+		},
+		{
+			name:    "Missing placeholder",
+			tmpl:    messageAccept,
+			body:    "{{.LocName}} {{.Day}} {{.Start}}-{{.End}}",
+			wantErr: "Fehlende Platzhalter: {{.OTP}}",
+		},
+		{
+			name:    "Unknown placeholder",
+			tmpl:    messageReject,
+			body:    "Leider voll, {{.Name}}",
+			wantErr: "can't evaluate field Name",
+		},
+		{
+			name:    "Syntax error",
+			tmpl:    messageReject,
+			body:    "Leider voll {{.LocName",
+			wantErr: "Ungültige Vorlage",
+		},
+		{
+			name:    "Unknown template",
+			tmpl:    "reminder",
+			body:    "Erinnerung",
+			wantErr: ErrUnknownMessage.Error(),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := parseMessage(tt.tmpl, tt.body)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("parseMessage() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseMessage() error = %v", err)
+			}
+			got, err := executeMessage(tmpl, sampleMessageData(defaultLanguage))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("parseMessage() renders %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		if _, err := testDB(bridge.store).Exec("DELETE FROM message_templates"); err != nil {
+			t.Fatal(err)
+		}
+
+		// Directory with a template for all centers, one for center 2 and
+		// one in English
+		dir := t.TempDir()
+		files := map[string]string{
+			"reject.txt":    "Voll.\n",
+			"2/reject.txt":  "Zentrum 2 voll.",
+			"en/reject.txt": "Full.",
+		}
+		for name, body := range files {
+			path := filepath.Join(dir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		m, err := NewMessageTemplates(dir, bridge.store)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		save := func(center int, lang, body string) {
+			t.Helper()
+			if err := m.Save(MessageTemplate{CenterID: center, Language: lang, Name: messageReject, Body: body, UpdatedAt: time.Now(), UpdatedBy: "admin"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		check := func(center int, lang, want, wantSource string) {
+			t.Helper()
+			got, err := m.Render(center, lang, messageReject, MessageData{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != want {
+				t.Errorf("Render(%d, %s) = %q, want %q", center, lang, got, want)
+			}
+			if _, source, _ := m.Source(center, lang, messageReject); source != wantSource {
+				t.Errorf("Source(%d, %s) = %q, want %q", center, lang, source, wantSource)
+			}
+		}
+
+		check(1, "de", "Voll.", SourceFile)
+		check(2, "de", "Zentrum 2 voll.", SourceFile)
+
+		// The language wins over the center
+		check(2, "en", "Full.", SourceFile)
+		check(2, "tr", "Maalesef bu arada tüm randevular doldu. Sistemde kalıyorsunuz ve gerekirse tekrar bilgilendirileceksiniz.", SourceDefault)
+
+		// Templates from the database win over the files of the same center
+		save(0, "de", "Leider voll.")
+		check(1, "de", "Leider voll.", SourceDatabase)
+		check(2, "de", "Zentrum 2 voll.", SourceFile)
+		check(1, "en", "Full.", SourceFile)
+
+		save(2, "de", "Zentrum 2 ist leider voll.")
+		check(2, "de", "Zentrum 2 ist leider voll.", SourceDatabase)
+
+		save(0, "en", "Sorry, full.")
+		check(2, "en", "Sorry, full.", SourceDatabase)
+
+		// Saved templates are loaded again on the next start
+		m, err = NewMessageTemplates(dir, bridge.store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(2, "de", "Zentrum 2 ist leider voll.", SourceDatabase)
+		check(2, "en", "Sorry, full.", SourceDatabase)
+
+		if err := m.Reset(2, "de", messageReject); err != nil {
+			t.Fatal(err)
+		}
+		check(2, "de", "Zentrum 2 voll.", SourceFile)
+		check(2, "en", "Sorry, full.", SourceDatabase)
+
+		// Invalid templates are not saved
+		err = m.Save(MessageTemplate{CenterID: 1, Language: "de", Name: messageAccept, Body: "Bestätigt"})
+		if err == nil || !strings.Contains(err.Error(), "Fehlende Platzhalter") {
+			t.Errorf("Save() of invalid template error = %v", err)
+		}
+		err = m.Save(MessageTemplate{CenterID: 1, Language: "xx", Name: messageReject, Body: "Voll."})
+		if !errors.Is(err, ErrUnknownLanguage) {
+			t.Errorf("Save() in unknown language error = %v, want %v", err, ErrUnknownLanguage)
+		}
+
+		// Built-in templates are used without directory
+		m, err = NewMessageTemplates("", bridge.store)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(2, "de", "Leider voll.", SourceDatabase)
+		if got, err := m.Render(2, "de", messageDelete, MessageData{}); err != nil || !strings.Contains(got, "erfolgreich entfernt") {
+			t.Errorf("Render() of built-in template = %q, %v", got, err)
+		}
+		if got, err := m.Render(2, "en", messageDelete, MessageData{}); err != nil || !strings.Contains(got, "removed") {
+			t.Errorf("Render() of built-in English template = %q, %v", got, err)
+		}
+		if _, err := m.Render(2, "de", "reminder", MessageData{}); !errors.Is(err, ErrUnknownMessage) {
+			t.Errorf("Render() of unknown template error = %v, want %v", err, ErrUnknownMessage)
+		}
+
+		if _, err := testDB(bridge.store).Exec("DELETE FROM message_templates"); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestNewMessageTemplates_Invalid(t *testing.T) {
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notify.txt"), []byte("Einladung um {{.Start}}"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewMessageTemplates(dir, bridge.store); err == nil || !strings.Contains(err.Error(), "{{.End}}") {
+		t.Errorf("NewMessageTemplates() error = %v, want missing placeholder", err)
+	}
+
+	// Subdirectories are centers or languages
+	dir = t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "klingonisch"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "klingonisch", "reject.txt"), []byte("Voll."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewMessageTemplates(dir, bridge.store); err == nil || !strings.Contains(err.Error(), "neither the ID of a center nor a language") {
+		t.Errorf("NewMessageTemplates() error = %v, want invalid directory", err)
+	}
+}
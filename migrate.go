@@ -0,0 +1,159 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// All migrations are compiled into the binary. There is one directory per
+// database driver (migrations/sqlite3, migrations/postgres), which have to
+// contain the same versions. Files are named <version>_<description>.sql,
+// e.g. 0002_add_vaccine.sql. Versions have to be consecutive, starting at 1.
+// Never edit a migration once it has been released, add a new one instead.
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+var schemaVersion = `
+CREATE TABLE IF NOT EXISTS schema_version (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+);
+`
+
+// migration is a single forward migration of the database schema
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// loadMigrations reads all embedded migrations for a database driver and
+// returns them sorted by version. It fails if a file is not named correctly or
+// versions are missing or duplicate.
+func loadMigrations(driver string) ([]migration, error) {
+
+	dir := path.Join("migrations", driver)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+
+	for _, e := range entries {
+
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+
+		parts := strings.SplitN(strings.TrimSuffix(name, ".sql"), "_", 2)
+		version, err := strconv.Atoi(parts[0])
+		if err != nil || len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+
+		content, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		migrations = append(migrations, migration{
+			Version: version,
+			Name:    parts[1],
+			SQL:     string(content),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	for k := range migrations {
+		if migrations[k].Version != k+1 {
+			return nil, fmt.Errorf("migration versions not consecutive, expected %d but found %d", k+1, migrations[k].Version)
+		}
+	}
+
+	return migrations, nil
+}
+
+// currentSchemaVersion returns the version of the last migration applied to the
+// database or 0 for a database without migrations
+func currentSchemaVersion(db *sqlx.DB) (int, error) {
+	var version int
+	err := db.Get(&version, "SELECT COALESCE(MAX(version), 0) FROM schema_version")
+	return version, err
+}
+
+// migrateDB brings the database schema up to date by applying all migrations
+// that have not been applied yet. Each migration runs inside a transaction
+// together with the update of the schema_version table. If the database has a
+// higher version than the newest migration known to this binary, an error is
+// returned, since we can't know if we are able to work with that schema.
+func migrateDB(db *sqlx.DB) error {
+
+	migrations, err := loadMigrations(db.DriverName())
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.Exec(schemaVersion); err != nil {
+		return err
+	}
+
+	current, err := currentSchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	if current > len(migrations) {
+		return fmt.Errorf(
+			"database schema version %d is newer than supported version %d, refusing to start",
+			current, len(migrations))
+	}
+
+	log.Infof("Database schema version is %d, latest is %d", current, len(migrations))
+
+	for _, m := range migrations[current:] {
+
+		log.Infof("Applying migration %d: %s", m.Version, m.Name)
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(m.SQL); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Error(rbErr)
+			}
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+
+		if _, err := tx.Exec(
+			"INSERT INTO schema_version (version, name, applied_at) VALUES ($1, $2, $3)",
+			m.Version, m.Name, time.Now()); err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				log.Error(rbErr)
+			}
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func openTempDB(t *testing.T) *sqlx.DB {
+	db, err := sqlx.Connect("sqlite3", filepath.Join(t.TempDir(), "migrate.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func Test_loadMigrations(t *testing.T) {
+
+	sqliteMigrations, err := loadMigrations("sqlite3")
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+
+	postgresMigrations, err := loadMigrations("postgres")
+	if err != nil {
+		t.Fatalf("loadMigrations() error = %v", err)
+	}
+
+	if len(sqliteMigrations) == 0 {
+		t.Fatal("loadMigrations() returned no migrations")
+	}
+
+	// Both backends have to be on the same schema version
+	if len(sqliteMigrations) != len(postgresMigrations) {
+		t.Fatalf("sqlite3 has %d migrations, postgres has %d",
+			len(sqliteMigrations), len(postgresMigrations))
+	}
+
+	for k, m := range sqliteMigrations {
+		if m.Version != k+1 {
+			t.Errorf("migration %d has version %d", k, m.Version)
+		}
+		if m.Name == "" || m.SQL == "" {
+			t.Errorf("migration %d is missing name or content", m.Version)
+		}
+		if m.Name != postgresMigrations[k].Name {
+			t.Errorf("migration %d is named %s for sqlite3 but %s for postgres",
+				m.Version, m.Name, postgresMigrations[k].Name)
+		}
+	}
+}
+
+func Test_migrateDB(t *testing.T) {
+
+	migrations, err := loadMigrations("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(db *sqlx.DB)
+		want    int
+		wantErr bool
+	}{
+		{
+			name:    "Fresh database",
+			prepare: func(db *sqlx.DB) {},
+			want:    len(migrations),
+			wantErr: false,
+		},
+		{
+			name: "Already migrated database",
+			prepare: func(db *sqlx.DB) {
+				if err := migrateDB(db); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:    len(migrations),
+			wantErr: false,
+		},
+		{
+			name: "Database created before migrations existed",
+			prepare: func(db *sqlx.DB) {
+				db.MustExec(`CREATE TABLE persons (
+					phone TEXT PRIMARY KEY,
+					center_id INTEGER NOT NULL,
+					group_num INTEGER NOT NULL,
+					status INTEGER NOT NULL)`)
+				db.MustExec(`INSERT INTO persons VALUES ('123', 0, 1, 0)`)
+			},
+			want:    len(migrations),
+			wantErr: false,
+		},
+		{
+			name: "Database newer than binary",
+			prepare: func(db *sqlx.DB) {
+				if err := migrateDB(db); err != nil {
+					t.Fatal(err)
+				}
+				db.MustExec(
+					"INSERT INTO schema_version (version, name, applied_at) VALUES ($1, 'future', CURRENT_TIMESTAMP)",
+					len(migrations)+1)
+			},
+			want:    len(migrations) + 1,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTempDB(t)
+			tt.prepare(db)
+
+			if err := migrateDB(db); (err != nil) != tt.wantErr {
+				t.Errorf("migrateDB() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			got, err := currentSchemaVersion(db)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("currentSchemaVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+-- Initial schema
+
+CREATE TABLE IF NOT EXISTS calls (
+	id SERIAL PRIMARY KEY,
+	title TEXT NOT NULL,
+	center_id INTEGER NOT NULL,
+	capacity INTEGER NOT NULL,
+	time_start TIMESTAMPTZ NOT NULL,
+	time_end TIMESTAMPTZ NOT NULL,
+	young_only BOOLEAN NOT NULL,
+	loc_name TEXT NOT NULL,
+	loc_street TEXT NOT NULL,
+	loc_housenr TEXT NOT NULL,
+	loc_plz TEXT NOT NULL,
+	loc_city TEXT NOT NULL,
+	loc_opt TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS persons (
+	phone TEXT PRIMARY KEY,
+	center_id INTEGER NOT NULL,
+	group_num INTEGER NOT NULL,
+	status BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	username TEXT PRIMARY KEY,
+	password TEXT
+);
+
+CREATE TABLE IF NOT EXISTS invitations (
+	id SERIAL PRIMARY KEY,
+	phone TEXT NOT NULL,
+	call_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	time TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,15 @@
+-- Outgoing messages are written to the outbox in the same transaction as the
+-- data they belong to and delivered afterwards. If the application stops
+-- before a message is delivered, it is sent on the next start.
+
+CREATE TABLE outbox (
+	id SERIAL PRIMARY KEY,
+	phone TEXT NOT NULL,
+	body TEXT NOT NULL,
+	invitation_id INTEGER,
+	status TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX outbox_status ON outbox (status);
@@ -0,0 +1,9 @@
+-- Strategy for sending invitations per call. More persons than seats left can
+-- be invited (overbooking), the number of invitations waiting for a reply can
+-- be limited (max_outstanding, 0 for no limit) and waves of invitations can be
+-- spaced out (wave_spacing in minutes).
+
+ALTER TABLE calls ADD COLUMN overbooking DOUBLE PRECISION NOT NULL DEFAULT 1.0;
+ALTER TABLE calls ADD COLUMN max_outstanding INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN wave_spacing INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE calls ADD COLUMN last_wave_at TIMESTAMPTZ;
@@ -0,0 +1,12 @@
+-- Delivery receipts of the SMS provider. Messages are matched by the ID the
+-- provider assigned when the message was sent. Persons whose messages can't be
+-- delivered repeatedly are candidates for deactivation.
+
+ALTER TABLE outbox ADD COLUMN provider_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN delivery_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN delivery_error TEXT NOT NULL DEFAULT '';
+ALTER TABLE outbox ADD COLUMN delivery_updated_at TIMESTAMPTZ;
+
+CREATE INDEX outbox_provider_id ON outbox (provider_id);
+
+ALTER TABLE persons ADD COLUMN undelivered INTEGER NOT NULL DEFAULT 0;
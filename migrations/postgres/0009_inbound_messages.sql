@@ -0,0 +1,13 @@
+-- Log of all SMS received from persons on the inbound endpoint, with the
+-- action the message was understood as and the result of handling it.
+
+CREATE TABLE inbound_messages (
+	id SERIAL PRIMARY KEY,
+	phone TEXT NOT NULL,
+	body TEXT NOT NULL,
+	action TEXT NOT NULL,
+	result TEXT NOT NULL DEFAULT '',
+	received_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX inbound_messages_phone ON inbound_messages (phone);
@@ -0,0 +1,4 @@
+-- Characters outside of the GSM-7 alphabet can be replaced in the messages of
+-- a call, so they fit into fewer and cheaper SMS segments.
+
+ALTER TABLE calls ADD COLUMN transliterate BOOLEAN NOT NULL DEFAULT false;
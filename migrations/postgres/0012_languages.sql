@@ -0,0 +1,9 @@
+-- Preferred language of persons for their messages, empty for the default
+-- language. Message templates exist per language.
+
+ALTER TABLE persons ADD COLUMN language TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE message_templates ADD COLUMN language TEXT NOT NULL DEFAULT 'de';
+ALTER TABLE message_templates ALTER COLUMN language DROP DEFAULT;
+ALTER TABLE message_templates DROP CONSTRAINT message_templates_pkey;
+ALTER TABLE message_templates ADD PRIMARY KEY (center_id, language, name);
@@ -0,0 +1,27 @@
+-- Uploaded lists of persons. All rows of a file are kept with the result of
+-- their validation, so the import can be previewed before it is confirmed and
+-- the rows with errors can be downloaded as report.
+
+CREATE TABLE imports (
+	id SERIAL PRIMARY KEY,
+	filename TEXT NOT NULL,
+	header BOOLEAN NOT NULL,
+	phone_column INTEGER NOT NULL,
+	group_column INTEGER NOT NULL,
+	language_column INTEGER NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	created_by TEXT NOT NULL,
+	imported_at TIMESTAMPTZ,
+	imported INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE import_rows (
+	import_id INTEGER NOT NULL,
+	line INTEGER NOT NULL,
+	record TEXT NOT NULL,
+	phone TEXT NOT NULL,
+	group_num INTEGER NOT NULL,
+	language TEXT NOT NULL,
+	error TEXT NOT NULL,
+	PRIMARY KEY (import_id, line)
+);
@@ -0,0 +1,10 @@
+-- Spreadsheets may have several sheets. The rows of all sheets of an upload
+-- are kept, the rows of the selected sheet are imported.
+
+ALTER TABLE imports ADD COLUMN sheets TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE imports ADD COLUMN sheet INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE import_rows ADD COLUMN sheet INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE import_rows ALTER COLUMN sheet DROP DEFAULT;
+ALTER TABLE import_rows DROP CONSTRAINT import_rows_pkey;
+ALTER TABLE import_rows ADD PRIMARY KEY (import_id, sheet, line);
@@ -0,0 +1,15 @@
+-- Persons who asked to be deleted must not be added again. Only a salted hash
+-- of their phone number is kept. A suppression can be lifted by an admin,
+-- who is recorded together with the time.
+CREATE TABLE suppressions (
+	hash TEXT PRIMARY KEY,
+	created_at TIMESTAMPTZ NOT NULL,
+	lifted_at TIMESTAMPTZ,
+	lifted_by TEXT NOT NULL DEFAULT ''
+);
+
+-- Persons of an import which are not added because they are suppressed
+ALTER TABLE imports ADD COLUMN suppressed INTEGER NOT NULL DEFAULT 0;
+
+-- Admins may lift suppressions
+ALTER TABLE users ADD COLUMN admin BOOLEAN NOT NULL DEFAULT FALSE;
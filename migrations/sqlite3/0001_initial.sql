@@ -0,0 +1,38 @@
+-- Initial schema. Uses IF NOT EXISTS so databases created before the
+-- migration subsystem existed are adopted without changes.
+
+CREATE TABLE IF NOT EXISTS calls (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	title TEXT NOT NULL,
+	center_id INTEGER NOT NULL,
+	capacity INTEGER NOT NULL,
+	time_start DATETIME NOT NULL,
+	time_end DATETIME NOT NULL,
+	young_only INTEGER NOT NULL,
+	loc_name TEXT NOT NULL,
+	loc_street TEXT NOT NULL,
+	loc_housenr TEXT NOT NULL,
+	loc_plz TEXT NOT NULL,
+	loc_city TEXT NOT NULL,
+	loc_opt TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS persons (
+	phone TEXT PRIMARY KEY,
+	center_id INTEGER NOT NULL,
+	group_num INTEGER NOT NULL,
+	status INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS users (
+	username TEXT PRIMARY KEY,
+	password TEXT
+);
+
+CREATE TABLE IF NOT EXISTS invitations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	phone TEXT NOT NULL,
+	call_id INTEGER NOT NULL,
+	status TEXT NOT NULL,
+	time DATETIME NOT NULL
+);
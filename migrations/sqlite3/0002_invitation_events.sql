@@ -0,0 +1,12 @@
+-- History of all status changes of invitations
+
+CREATE TABLE invitation_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	invitation_id INTEGER NOT NULL,
+	from_status TEXT NOT NULL,
+	to_status TEXT NOT NULL,
+	actor TEXT NOT NULL,
+	time DATETIME NOT NULL
+);
+
+CREATE INDEX invitation_events_invitation_id ON invitation_events (invitation_id);
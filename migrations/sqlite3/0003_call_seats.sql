@@ -0,0 +1,10 @@
+-- Number of accepted invitations per call. Seats are reserved with a
+-- compare-and-set on this column, so a call can never exceed its capacity.
+
+ALTER TABLE calls ADD COLUMN seats_taken INTEGER NOT NULL DEFAULT 0;
+
+UPDATE calls SET seats_taken = (
+	SELECT COUNT(id) FROM invitations
+	WHERE invitations.call_id = calls.id
+	AND invitations.status = 'accepted'
+);
@@ -0,0 +1,9 @@
+-- Invitations expire if the person does not reply within the response window
+-- of the call. Expired invitations are replaced by a new wave of invitations.
+
+ALTER TABLE calls ADD COLUMN response_window INTEGER NOT NULL DEFAULT 60;
+
+ALTER TABLE invitations ADD COLUMN wave INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE invitations ADD COLUMN expires_at DATETIME;
+
+UPDATE invitations SET expires_at = time;
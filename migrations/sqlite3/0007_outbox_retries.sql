@@ -0,0 +1,9 @@
+-- Failed deliveries are retried with increasing delays. Messages which could
+-- not be delivered after the maximum number of attempts get status 'failed'
+-- and are kept for inspection.
+
+ALTER TABLE outbox ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE outbox ADD COLUMN next_attempt_at DATETIME;
+ALTER TABLE outbox ADD COLUMN last_error TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX outbox_next_attempt ON outbox (status, next_attempt_at);
@@ -0,0 +1,12 @@
+-- Message templates edited in the web UI. They replace the built-in templates
+-- and the ones from IMPF_MESSAGE_DIR. Center 0 applies to all centers without
+-- a template of their own.
+
+CREATE TABLE message_templates (
+	center_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	body TEXT NOT NULL,
+	updated_at DATETIME NOT NULL,
+	updated_by TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (center_id, name)
+);
@@ -0,0 +1,20 @@
+-- Preferred language of persons for their messages, empty for the default
+-- language. Message templates exist per language.
+
+ALTER TABLE persons ADD COLUMN language TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE message_templates_new (
+	center_id INTEGER NOT NULL,
+	language TEXT NOT NULL,
+	name TEXT NOT NULL,
+	body TEXT NOT NULL,
+	updated_at DATETIME NOT NULL,
+	updated_by TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (center_id, language, name)
+);
+
+INSERT INTO message_templates_new (center_id, language, name, body, updated_at, updated_by)
+SELECT center_id, 'de', name, body, updated_at, updated_by FROM message_templates;
+
+DROP TABLE message_templates;
+ALTER TABLE message_templates_new RENAME TO message_templates;
@@ -0,0 +1,23 @@
+-- Spreadsheets may have several sheets. The rows of all sheets of an upload
+-- are kept, the rows of the selected sheet are imported.
+
+ALTER TABLE imports ADD COLUMN sheets TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE imports ADD COLUMN sheet INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE import_rows_new (
+	import_id INTEGER NOT NULL,
+	sheet INTEGER NOT NULL,
+	line INTEGER NOT NULL,
+	record TEXT NOT NULL,
+	phone TEXT NOT NULL,
+	group_num INTEGER NOT NULL,
+	language TEXT NOT NULL,
+	error TEXT NOT NULL,
+	PRIMARY KEY (import_id, sheet, line)
+);
+
+INSERT INTO import_rows_new (import_id, sheet, line, record, phone, group_num, language, error)
+SELECT import_id, 0, line, record, phone, group_num, language, error FROM import_rows;
+
+DROP TABLE import_rows;
+ALTER TABLE import_rows_new RENAME TO import_rows;
@@ -0,0 +1,8 @@
+-- Persons of an import whose phone number exists already are skipped,
+-- updated or make the import fail, depending on the mode chosen when
+-- confirming it. The number of persons per outcome is kept for the summary.
+
+ALTER TABLE imports ADD COLUMN mode TEXT NOT NULL DEFAULT '';
+ALTER TABLE imports ADD COLUMN inserted INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE imports ADD COLUMN updated INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE imports ADD COLUMN skipped INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,17 @@
+-- Imports are processed as jobs by a background worker. An uploaded file is
+-- kept until the worker has read it into rows. The progress of a confirmed
+-- import is saved after each batch of persons, so it can be shown and an
+-- interrupted import continues where it stopped. Existing imports are ready
+-- or done.
+ALTER TABLE imports ADD COLUMN status TEXT NOT NULL DEFAULT 'ready';
+ALTER TABLE imports ADD COLUMN total INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE imports ADD COLUMN processed INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE imports ADD COLUMN queued INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE imports ADD COLUMN error TEXT NOT NULL DEFAULT '';
+
+UPDATE imports SET status='done', total=imported, processed=imported WHERE imported_at IS NOT NULL;
+
+CREATE TABLE import_files (
+	import_id INTEGER PRIMARY KEY,
+	data BLOB NOT NULL
+);
@@ -0,0 +1,6 @@
+-- Users belong to a vaccination center. Calls they create and persons they
+-- add or import get the center, which selects its message templates. Users
+-- without center use the templates for all centers.
+ALTER TABLE users ADD COLUMN center_id INTEGER NOT NULL DEFAULT 0;
+
+ALTER TABLE imports ADD COLUMN center_id INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,6 @@
+-- Settings which must not change once the application has been started, e.g.
+-- the salt of the suppression list
+CREATE TABLE settings (
+	name TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Status of a message in the outbox
+const (
+	MessagePending = "pending"
+	MessageSent    = "sent"
+	MessageFailed  = "failed" // Given up after too many attempts
+)
+
+// ErrMessageNotFailed is returned when requeueing a message that has not
+// failed
+var ErrMessageNotFailed = errors.New("message has not failed")
+
+// OutboxMessage is a SMS waiting to be delivered or already delivered. It is
+// written to the outbox in the same transaction as the data it belongs to, so
+// a message can't get lost or be sent for data that was never saved.
+type OutboxMessage struct {
+	ID            int           `db:"id"`
+	Phone         string        `db:"phone"`
+	Body          string        `db:"body"`
+	InvitationID  sql.NullInt64 `db:"invitation_id"`
+	Status        string        `db:"status"`
+	CreatedAt     time.Time     `db:"created_at"`
+	SentAt        sql.NullTime  `db:"sent_at"`
+	Attempts      int           `db:"attempts"`
+	NextAttemptAt sql.NullTime  `db:"next_attempt_at"`
+	LastError     string        `db:"last_error"`
+
+	// Delivery receipt of the provider, see handlerStatusCallback
+	ProviderID        string       `db:"provider_id"`
+	DeliveryStatus    string       `db:"delivery_status"`
+	DeliveryError     string       `db:"delivery_error"`
+	DeliveryUpdatedAt sql.NullTime `db:"delivery_updated_at"`
+}
+
+// Delivery status of a sent message as reported by the provider
+const (
+	DeliveryQueued      = "queued"
+	DeliverySending     = "sending"
+	DeliverySent        = "sent"
+	DeliveryDelivered   = "delivered"
+	DeliveryUndelivered = "undelivered"
+	DeliveryFailed      = "failed"
+)
+
+// deliveryStatusOrder orders the delivery statuses. Receipts can arrive out of
+// order, a status never replaces one that comes later. The last three are
+// final.
+var deliveryStatusOrder = map[string]int{
+	DeliveryQueued:      1,
+	DeliverySending:     2,
+	DeliverySent:        3,
+	DeliveryDelivered:   4,
+	DeliveryUndelivered: 4,
+	DeliveryFailed:      4,
+}
+
+// ValidDeliveryStatus returns true if status is a known delivery status
+func ValidDeliveryStatus(status string) bool {
+	_, ok := deliveryStatusOrder[status]
+	return ok
+}
+
+// deliveryStatusReplaces returns true if a receipt with status to may replace
+// the current delivery status from
+func deliveryStatusReplaces(from, to string) bool {
+	return from == "" || deliveryStatusOrder[to] > deliveryStatusOrder[from]
+}
+
+// Undeliverable returns true if the provider could not deliver the message
+func Undeliverable(status string) bool {
+	return status == DeliveryUndelivered || status == DeliveryFailed
+}
+
+// ErrMessageNotFound is returned for a delivery receipt of an unknown message
+var ErrMessageNotFound = errors.New("message not found")
+
+// Delivery settings, see retryDelay. They can be changed with
+// IMPF_OUTBOX_WORKERS and IMPF_OUTBOX_MAX_ATTEMPTS.
+var (
+	outboxWorkers     = 4
+	outboxMaxAttempts = 8
+	outboxRetryBase   = 30 * time.Second
+	outboxRetryMax    = time.Hour
+)
+
+// undeliveredThreshold is the number of messages in a row that could not be
+// delivered to a person, after which the person is shown as candidate for
+// deactivation. It can be changed with IMPF_UNDELIVERED_THRESHOLD.
+var undeliveredThreshold = 3
+
+// outboxPollInterval is how often the outbox is checked for messages when no
+// delivery has been triggered
+const outboxPollInterval = time.Minute
+
+// retryDelay returns how long to wait before the next attempt after a message
+// failed to be delivered attempts times. The delay doubles with each attempt,
+// starting at outboxRetryBase and limited to outboxRetryMax.
+func retryDelay(attempts int) time.Duration {
+	delay := outboxRetryBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= outboxRetryMax {
+			return outboxRetryMax
+		}
+	}
+	return delay
+}
@@ -16,6 +16,10 @@ type Person struct {
 	CenterID int    `db:"center_id"` // ID of center that added this person
 	Group    int    `db:"group_num"` // Vaccination group
 	Status   bool   `db:"status"`    // Vaccination status
+	Language string `db:"language"`  // Language of messages, empty for defaultLanguage
+
+	// Number of messages in a row the provider could not deliver
+	Undelivered int `db:"undelivered"`
 }
 
 // NewPerson receives the input data and returns a slice of person objects. For
@@ -28,13 +32,13 @@ func NewPerson(centerID, group int, phone string, status bool) (Person, error) {
 		Status:   status,
 	}
 
-	num, err := libphonenumber.Parse(phone, "DE")
+	normalized, err := normalizePhone(phone)
 	if err != nil {
 		log.Warn("Error parsing phone number: ", phone)
 		return person, errors.New("Ungültige Rufnummer: " + phone)
 	}
 
-	person.Phone = libphonenumber.Format(num, libphonenumber.E164)
+	person.Phone = normalized
 	log.Debug("parsed number: ", person.Phone)
 
 	// Validate that group number is not empty
@@ -46,3 +50,21 @@ func NewPerson(centerID, group int, phone string, status bool) (Person, error) {
 
 	return person, nil
 }
+
+// normalizePhone returns a phone number in E.164 format, numbers without
+// country code are taken as German numbers
+func normalizePhone(phone string) (string, error) {
+	num, err := libphonenumber.Parse(phone, "DE")
+	if err != nil {
+		return "", err
+	}
+	return libphonenumber.Format(num, libphonenumber.E164), nil
+}
+
+// MessageLanguage returns the language messages to the person are written in
+func (p Person) MessageLanguage() string {
+	if p.Language == "" {
+		return defaultLanguage
+	}
+	return p.Language
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reasons a scheduler run is triggered for. They are only used for logging
+const (
+	triggerStart         = "start"
+	triggerTimer         = "timer"
+	triggerCallCreated   = "call created"
+	triggerCancellation  = "invitation cancelled"
+	triggerManual        = "manual"
+	triggerMessageQueued = "message queued"
+	triggerUndelivered   = "invitation undelivered"
+	triggerImportQueued  = "import queued"
+)
+
+// defaultSchedulerInterval is used when IMPF_SCHEDULER_INTERVAL is not set
+const defaultSchedulerInterval = 15 * time.Minute
+
+// importPollInterval is the interval the import worker looks for imports in
+// besides being triggered when an import is uploaded or confirmed
+const importPollInterval = time.Minute
+
+// minSchedulerWait is the shortest time between two runs caused by the timer.
+// It prevents busy looping if a run fails to handle what woke it up.
+const minSchedulerWait = time.Second
+
+// Scheduler runs periodic work of the bridge, like sending invitations and
+// delivering the outbox. Besides running in a fixed interval it reacts to
+// events. A run can be triggered at any time, e.g. when a call is created or
+// an invitation is cancelled, and the scheduler wakes up on its own when
+// something needs attention, e.g. when the next open invitation expires.
+//
+// Triggers arriving while a run is in progress are coalesced into a single
+// follow-up run, so triggering never blocks the caller.
+type Scheduler struct {
+	interval time.Duration
+
+	// run does the actual work, nextWakeup returns when the next run is
+	// needed at the latest besides the interval (zero time if none)
+	run        func(ctx context.Context)
+	nextWakeup func(now time.Time) time.Time
+
+	trigger chan string
+	cancel  context.CancelFunc
+	done    chan struct{}
+	once    sync.Once
+}
+
+// NewScheduler creates a scheduler calling run at least every interval. It
+// does nothing until Start is called.
+func NewScheduler(interval time.Duration, run func(ctx context.Context), nextWakeup func(now time.Time) time.Time) *Scheduler {
+	return &Scheduler{
+		interval:   interval,
+		run:        run,
+		nextWakeup: nextWakeup,
+		trigger:    make(chan string, 1),
+		done:       make(chan struct{}),
+	}
+}
+
+// Start starts the scheduler in the background with an initial run. It stops
+// when ctx is cancelled or Stop is called.
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.Trigger(triggerStart)
+	go s.loop(ctx)
+}
+
+// Trigger requests a run as soon as possible. It is safe to call on a nil
+// scheduler, which does nothing.
+func (s *Scheduler) Trigger(reason string) {
+	if s == nil {
+		return
+	}
+
+	select {
+	case s.trigger <- reason:
+	default:
+		log.Debugf("Scheduler run already pending, not queuing %s", reason)
+	}
+}
+
+// Stop cancels the scheduler and waits until a run in progress has finished.
+// It is safe to call multiple times and on a scheduler never started.
+func (s *Scheduler) Stop() {
+	if s == nil || s.cancel == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		log.Info("Stopping scheduler")
+		s.cancel()
+		<-s.done
+	})
+}
+
+func (s *Scheduler) loop(ctx context.Context) {
+	defer close(s.done)
+
+	timer := time.NewTimer(s.wait(time.Now()))
+	defer timer.Stop()
+
+	for {
+		var reason string
+
+		select {
+		case <-ctx.Done():
+			return
+		case reason = <-s.trigger:
+		case <-timer.C:
+			reason = triggerTimer
+		}
+
+		log.Debug("Scheduler run triggered by: ", reason)
+		s.run(ctx)
+
+		// Reset the timer for the next run. It has to be drained first if
+		// the run was not caused by the timer
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(s.wait(time.Now()))
+	}
+}
+
+// wakeup returns the time the next run is needed at besides the interval
+func (s *Scheduler) wakeup() time.Time {
+	if s.nextWakeup == nil {
+		return time.Time{}
+	}
+	return s.nextWakeup(time.Now())
+}
+
+// wait returns how long to sleep from now until the next run. This is the
+// interval, or less if something needs attention earlier.
+func (s *Scheduler) wait(now time.Time) time.Duration {
+	wait := s.interval
+
+	if wakeup := s.wakeup(); !wakeup.IsZero() && wakeup.Sub(now) < wait {
+		wait = wakeup.Sub(now)
+	}
+
+	if wait < minSchedulerWait {
+		wait = minSchedulerWait
+	}
+	return wait
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScheduler_Trigger(t *testing.T) {
+
+	runs := make(chan struct{}, 10)
+	s := NewScheduler(time.Hour, func(ctx context.Context) { runs <- struct{}{} }, nil)
+	s.Start(context.Background())
+	defer s.Stop()
+
+	// Initial run on start
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scheduler did not run on start")
+	}
+
+	s.Trigger(triggerManual)
+
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scheduler did not run when triggered")
+	}
+}
+
+func TestScheduler_TriggerCoalesced(t *testing.T) {
+
+	block := make(chan struct{})
+	runs := make(chan struct{}, 10)
+
+	s := NewScheduler(time.Hour, func(ctx context.Context) {
+		runs <- struct{}{}
+		<-block
+	}, nil)
+	s.Start(context.Background())
+
+	// Wait for the initial run to be in progress, then trigger several
+	// times. None of them blocks and they result in a single follow-up run
+	<-runs
+	for i := 0; i < 5; i++ {
+		s.Trigger(triggerCancellation)
+	}
+	close(block)
+
+	select {
+	case <-runs:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Scheduler did not run when triggered")
+	}
+
+	s.Stop()
+
+	if got := len(runs); got != 0 {
+		t.Errorf("Scheduler ran %d more times, want 0", got)
+	}
+}
+
+func TestScheduler_Stop(t *testing.T) {
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+
+	s := NewScheduler(time.Hour, func(ctx context.Context) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+	}, nil)
+	s.Start(context.Background())
+	<-started
+
+	// Stop cancels the context of the run in progress and waits for it
+	s.Stop()
+
+	select {
+	case <-cancelled:
+	default:
+		t.Error("Stop() returned before the run finished")
+	}
+
+	// Stopping again, stopping a scheduler never started and using a nil
+	// scheduler does nothing
+	s.Stop()
+	NewScheduler(time.Hour, nil, nil).Stop()
+
+	var nilScheduler *Scheduler
+	nilScheduler.Trigger(triggerManual)
+	nilScheduler.Stop()
+}
+
+func TestScheduler_wait(t *testing.T) {
+
+	now := time.Date(2021, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		wakeup time.Time
+		want   time.Duration
+	}{
+		{
+			name:   "Nothing to wake up for",
+			wakeup: time.Time{},
+			want:   15 * time.Minute,
+		},
+		{
+			name:   "Wake up before interval",
+			wakeup: now.Add(5 * time.Minute),
+			want:   5 * time.Minute,
+		},
+		{
+			name:   "Wake up after interval",
+			wakeup: now.Add(time.Hour),
+			want:   15 * time.Minute,
+		},
+		{
+			name:   "Wake up in the past",
+			wakeup: now.Add(-time.Hour),
+			want:   minSchedulerWait,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewScheduler(15*time.Minute, nil, func(time.Time) time.Time { return tt.wakeup })
+			if got := s.wait(now); got != tt.want {
+				t.Errorf("Scheduler.wait() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
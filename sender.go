@@ -1,134 +1,92 @@
 package main
 
 import (
-	// "bytes"
-	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
-	"net/url"
-	"strings"
+	"os"
 
 	log "github.com/sirupsen/logrus"
 )
 
-// TwillioSender abstracts interactions with the twilio API
-type TwillioSender struct {
-	endpoint, user, token, from string
+// MessageSender delivers SMS to persons. The bridge only depends on this
+// interface, so the SMS provider can be changed by configuration.
+// Implementations exist for Twilio Studio flows (default), the Twilio Messages
+// API, a generic HTTP webhook and a file sink for development. The provider is
+// selected with IMPF_SMS_PROVIDER.
+type MessageSender interface {
+
+	// SendMessage sends a SMS with the provided body to the number. It
+	// returns the ID the provider assigned to the message, which is empty
+	// if the provider does not report one, and an error if the message could
+	// not be handed to the provider
+	SendMessage(to, body string) (string, error)
 }
 
-// SendMessage sends a SMS with the provided body to the number. It will return
-// an error if sending fails
-func (s TwillioSender) SendMessage(msgTo, msgBody string) error {
-
-	var jsonData []byte
-	var err error
-
-	// Set required data
-	data := url.Values{}
-	data.Set("To", msgTo)
-	data.Set("From", s.from)
-
-	values := map[string]string{
-		"type":    "nachricht",
-		"message": msgBody,
-	}
+// Supported values of IMPF_SMS_PROVIDER
+const (
+	providerTwilioStudio = "twilio-studio"
+	providerTwilio       = "twilio"
+	providerWebhook      = "webhook"
+	providerFile         = "file"
+)
 
-	if jsonData, err = json.Marshal(values); err != nil {
-		return err
+// NewMessageSender creates the sender for the given provider. Its settings are
+// read from the environment. An empty provider selects the Twilio Studio flow.
+func NewMessageSender(provider string) (MessageSender, error) {
+	switch provider {
+	case providerTwilioStudio, "":
+		return NewTwilioStudioSender(
+			os.Getenv("IMPF_TWILIO_API_ENDPOINT"),
+			os.Getenv("IMPF_TWILIO_API_USER"),
+			os.Getenv("IMPF_TWILIO_API_PASS"),
+			os.Getenv("IMPF_TWILIO_API_FROM"),
+		), nil
+	case providerTwilio:
+		return NewTwilioSender(
+			os.Getenv("IMPF_TWILIO_API_ENDPOINT"),
+			os.Getenv("IMPF_TWILIO_API_USER"),
+			os.Getenv("IMPF_TWILIO_API_PASS"),
+			os.Getenv("IMPF_TWILIO_API_FROM"),
+			os.Getenv("IMPF_STATUS_CALLBACK_URL"),
+		), nil
+	case providerWebhook:
+		return NewWebhookSender(
+			os.Getenv("IMPF_SMS_WEBHOOK_URL"),
+			os.Getenv("IMPF_SMS_WEBHOOK_TOKEN"),
+			os.Getenv("IMPF_TWILIO_API_FROM"),
+		)
+	case providerFile:
+		return NewFileSender(os.Getenv("IMPF_SMS_FILE"))
+	default:
+		return nil, fmt.Errorf("unsupported SMS provider: %s", provider)
 	}
+}
 
-	data.Set("Parameters", string(jsonData))
-
-	// Create a new client and request
-	client := &http.Client{}
-	r, err := http.NewRequest("POST", s.endpoint, strings.NewReader(data.Encode())) // URL-encoded payload
-	if err != nil {
-		return err
-	}
-
-	// Set necessary headers
-	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
-	r.SetBasicAuth(s.user, s.token)
-
-	if disableSMS != "" {
-		log.Info("SMS sending disabled. Unset IMPF_DISABLE_SMS to enable")
-		return nil
-	}
+// doRequest executes a request to a SMS provider. The response body is read
+// for logging. Responses with a status other than 2xx are returned as error.
+func doRequest(client *http.Client, r *http.Request) ([]byte, error) {
 
-	// Execute the request
 	res, err := client.Do(r)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer res.Body.Close()
 
 	// Print the result status code
 	log.Debug(res.Status)
 
-	// Read the body of the response for logging
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	log.Debug(string(body))
 
-	return nil
-}
-
-// SendMessageOnboarding sends the onboarding message, notifying a person that
-// his/her number has been added to the application
-func (s TwillioSender) SendMessageOnboarding(toPhone string) error {
-	msg := "Willkommen bei der kurzfristigen Impfterminvergabe der Feuerwehr Duisburg. Möchten Sie diesen Service nicht benutzen, antworten Sie jederzeit mit \"LÖSCHEN\"."
-	return s.SendMessage(toPhone, msg)
-}
-
-// SendMessageNotify send the invitation message when the person is invited to a call
-func (s TwillioSender) SendMessageNotify(toPhone, start, end, locName, locStreet, locHouseNr, locPLZ, locCity, locOpt string) error {
-	msg := fmt.Sprintf(
-		// TODO make this a template instead of interpolating the string with vars manually
-		`Sie haben die Möglichkeit zur Corona-Impfung, heute %s-%sh in %s %s %s %s %s %s. Antworten Sie für Zusage mit "JA"`,
-		start, end, locName, locStreet, locHouseNr, locPLZ, locCity, locOpt,
-	)
-	return s.SendMessage(toPhone, msg)
-}
-
-// SendMessageReject sends the rejection message when the person tries to
-// accept a call but it is already wull
-func (s TwillioSender) SendMessageReject(toPhone string) error {
-	msg := "Leider wurden zwischenzeitlich schon alle Termine vergeben. Sie bleiben im System und werden ggf. wieder benachrichtigt."
-	return s.SendMessage(toPhone, msg)
-}
-
-// SendMessageAccept sends the acceptance message when a person replies to a
-// call in time and has been given a spot
-func (s TwillioSender) SendMessageAccept(toPhone, start, end, locName, locStreet, locHouseNr, locPLZ, locCity, locOpt, otp string) error {
-	// msg := "Termin bestätigt. " + locaName + ", heute " + start + "-" + end + "h . ID: " + otp + ". Falls Sie den Termin nicht wahrnehmen können, bitte \"STORNO\" antworten."
-
-	// TODO make this a template instead of interpolating the string with vars manually
-	msg := fmt.Sprintf(
-		`Termin bestätigt %s-%sh in %s %s %s %s %s %s. Falls Sie den Termin nicht wahrnehmen können, bitte \"STORNO\" antworten. Ihre ID ist: %v`,
-		start, end, locName, locStreet, locHouseNr, locPLZ, locCity, locOpt, otp,
-	)
-	return s.SendMessage(toPhone, msg)
-}
-
-// SendMessageDelete notifies a person that their number has been deleted from
-// the database
-func (s TwillioSender) SendMessageDelete(toPhone string) error {
-	msg := "Sie wurden erfolgreich entfernt und erhalten keine weiteren Nachrichten von uns."
-	return s.SendMessage(toPhone, msg)
-}
-
-// NewTwillioSender creates a new instance of the sender from the parameters
-// passed
-func NewTwillioSender(endpoint, user, token, from string) *TwillioSender {
-
-	return &TwillioSender{
-		endpoint: endpoint,
-		token:    token,
-		user:     user,
-		from:     from,
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return body, fmt.Errorf("provider returned %s: %s", res.Status, body)
 	}
+
+	return body, nil
 }
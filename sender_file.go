@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileSender writes messages to a file or stdout instead of sending them. It
+// is meant for development and testing, no SMS leave the application.
+type FileSender struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewFileSender creates a sender appending messages to the file at path. An
+// empty path or "-" writes to stdout.
+func NewFileSender(path string) (*FileSender, error) {
+
+	if path == "" || path == "-" {
+		return &FileSender{w: os.Stdout}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FileSender{w: f}, nil
+}
+
+// SendMessage writes the message with time and recipient as a single line.
+// There are no delivery receipts, so no ID is returned.
+func (s *FileSender) SendMessage(to, body string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := fmt.Fprintf(s.w, "%s\t%s\t%q\n", time.Now().Format(time.RFC3339), to, body)
+	return "", err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// senderTimeout is the timeout for requests to SMS providers
+const senderTimeout = 30 * time.Second
+
+// TwilioStudioSender sends messages by executing a Twilio Studio flow. The
+// message is passed to the flow as parameter "message".
+type TwilioStudioSender struct {
+	endpoint, user, token, from string
+	client                      *http.Client
+}
+
+// NewTwilioStudioSender creates a new instance of the sender. The endpoint is
+// the executions URL of the flow.
+func NewTwilioStudioSender(endpoint, user, token, from string) *TwilioStudioSender {
+	return &TwilioStudioSender{
+		endpoint: endpoint,
+		user:     user,
+		token:    token,
+		from:     from,
+		client:   &http.Client{Timeout: senderTimeout},
+	}
+}
+
+// SendMessage starts an execution of the flow for the number. The flow
+// execution has no message ID, so no ID is returned.
+func (s *TwilioStudioSender) SendMessage(to, body string) (string, error) {
+
+	jsonData, err := json.Marshal(map[string]string{
+		"type":    "nachricht",
+		"message": body,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Set required data
+	data := url.Values{}
+	data.Set("To", to)
+	data.Set("From", s.from)
+	data.Set("Parameters", string(jsonData))
+
+	_, err = postTwilio(s.client, s.endpoint, s.user, s.token, data)
+	return "", err
+}
+
+// twilioAPI is the base URL of the Twilio REST API
+const twilioAPI = "https://api.twilio.com/2010-04-01"
+
+// TwilioSender sends messages with the Twilio Messages API. If statusCallback
+// is set, Twilio reports the delivery status of each message to that URL.
+type TwilioSender struct {
+	endpoint, accountSID, token, from, statusCallback string
+	client                                            *http.Client
+}
+
+// NewTwilioSender creates a new instance of the sender. If endpoint is empty,
+// the Messages API of the account is used.
+func NewTwilioSender(endpoint, accountSID, token, from, statusCallback string) *TwilioSender {
+
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPI, accountSID)
+	}
+
+	return &TwilioSender{
+		endpoint:       endpoint,
+		accountSID:     accountSID,
+		token:          token,
+		from:           from,
+		statusCallback: statusCallback,
+		client:         &http.Client{Timeout: senderTimeout},
+	}
+}
+
+// SendMessage creates a message resource for the number. Returns the SID of
+// the message.
+func (s *TwilioSender) SendMessage(to, body string) (string, error) {
+
+	data := url.Values{}
+	data.Set("To", to)
+	data.Set("From", s.from)
+	data.Set("Body", body)
+
+	if s.statusCallback != "" {
+		data.Set("StatusCallback", s.statusCallback)
+	}
+
+	resBody, err := postTwilio(s.client, s.endpoint, s.accountSID, s.token, data)
+	if err != nil {
+		return "", err
+	}
+
+	var message struct {
+		SID string `json:"sid"`
+	}
+	if err := json.Unmarshal(resBody, &message); err != nil {
+		return "", fmt.Errorf("invalid response of Twilio: %w", err)
+	}
+
+	return message.SID, nil
+}
+
+// postTwilio sends the URL-encoded data to the Twilio API. Returns the body
+// of the response.
+func postTwilio(client *http.Client, endpoint, user, token string, data url.Values) ([]byte, error) {
+
+	r, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	// Set necessary headers
+	r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth(user, token)
+
+	return doRequest(client, r)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// WebhookSender sends messages by posting them as JSON to a URL, so any SMS
+// gateway can be connected with a small adapter. The body of the request is
+//
+//	{"to": "+49...", "from": "...", "body": "..."}
+//
+// If a token is configured, it is sent as bearer token in the Authorization
+// header. The webhook may respond with {"id": "..."} to report the ID of the
+// message for delivery receipts.
+type WebhookSender struct {
+	url, token, from string
+	client           *http.Client
+}
+
+// NewWebhookSender creates a new instance of the sender
+func NewWebhookSender(url, token, from string) (*WebhookSender, error) {
+
+	if url == "" {
+		return nil, errors.New("webhook URL not set, set IMPF_SMS_WEBHOOK_URL")
+	}
+
+	return &WebhookSender{
+		url:    url,
+		token:  token,
+		from:   from,
+		client: &http.Client{Timeout: senderTimeout},
+	}, nil
+}
+
+// SendMessage posts the message to the webhook. Returns the ID of the
+// message if the webhook reported one.
+func (s *WebhookSender) SendMessage(to, body string) (string, error) {
+
+	jsonData, err := json.Marshal(map[string]string{
+		"to":   to,
+		"from": s.from,
+		"body": body,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	r, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(jsonData))
+	if err != nil {
+		return "", err
+	}
+
+	r.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		r.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resBody, err := doRequest(s.client, r)
+	if err != nil {
+		return "", err
+	}
+
+	// The ID is optional, any other response is accepted as well
+	var message struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(resBody, &message); err != nil {
+		return "", nil
+	}
+
+	return message.ID, nil
+}
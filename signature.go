@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"expvar"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// signatureRejected counts requests to the API rejected because of a missing
+// or invalid signature. It is published with the other expvars under
+// /auth/debug/vars.
+var signatureRejected = expvar.NewInt("api_signature_rejected")
+
+// maxAPIBodySize limits the body of API requests read for the signature.
+// Requests of Twilio are a few kilobytes.
+const maxAPIBodySize = 1 << 20
+
+// Errors of the request signature verification
+var (
+	ErrSignatureMissing = errors.New("missing X-Twilio-Signature header")
+	ErrSignatureInvalid = errors.New("invalid X-Twilio-Signature")
+)
+
+// twilioSignature computes the signature Twilio sends in the
+// X-Twilio-Signature header: the HMAC-SHA1 of the full URL followed by all
+// POST parameters sorted by name, each name directly followed by its value,
+// keyed with the auth token and encoded as base64.
+func twilioSignature(token, fullURL string, params url.Values) string {
+
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(fullURL)
+	for _, k := range keys {
+		values := append([]string(nil), params[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			b.WriteString(k)
+			b.WriteString(v)
+		}
+	}
+
+	mac := hmac.New(sha1.New, []byte(token))
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// verifyTwilioRequest checks the X-Twilio-Signature of a request. The URL
+// Twilio signed is publicURL followed by path and query of the request, or
+// the URL the request was received on if publicURL is empty. Form-encoded
+// parameters are part of the signature. For other bodies, Twilio signs the
+// SHA-256 of the body, passed as query parameter bodySHA256.
+//
+// The body is read and restored, so handlers can still read it. Bodies larger
+// than maxAPIBodySize are rejected.
+func verifyTwilioRequest(w http.ResponseWriter, r *http.Request, token, publicURL string) error {
+
+	signature := r.Header.Get("X-Twilio-Signature")
+	if signature == "" {
+		return ErrSignatureMissing
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxAPIBodySize))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var params url.Values
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/x-www-form-urlencoded" {
+		if params, err = url.ParseQuery(string(body)); err != nil {
+			return err
+		}
+	} else if bodyHash := r.URL.Query().Get("bodySHA256"); bodyHash != "" || len(body) > 0 {
+		sum := sha256.Sum256(body)
+		if !hmac.Equal([]byte(bodyHash), []byte(hex.EncodeToString(sum[:]))) {
+			return ErrSignatureInvalid
+		}
+	}
+
+	for _, u := range signedURLs(r, publicURL) {
+		if hmac.Equal([]byte(signature), []byte(twilioSignature(token, u, params))) {
+			return nil
+		}
+	}
+
+	return ErrSignatureInvalid
+}
+
+// signedURLs returns the URLs Twilio may have signed for the request. The
+// signature covers the URL as configured in Twilio, which can contain the
+// default port of the scheme or not, so both variants are returned.
+func signedURLs(r *http.Request, publicURL string) []string {
+
+	var u *url.URL
+	if publicURL != "" {
+		base, err := url.Parse(strings.TrimSuffix(publicURL, "/"))
+		if err != nil {
+			return nil
+		}
+		u = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: base.Path + r.URL.Path, RawQuery: r.URL.RawQuery}
+	} else {
+		u = &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+			u.Scheme = "https"
+		}
+	}
+
+	// Credentials for basic auth are not signed
+	u.User = nil
+
+	defaultPort := map[string]string{"http": "80", "https": "443"}[u.Scheme]
+
+	withPort, withoutPort := *u, *u
+	if u.Port() == "" {
+		withPort.Host = u.Host + ":" + defaultPort
+	} else if u.Port() == defaultPort {
+		withoutPort.Host = u.Hostname()
+	}
+
+	return []string{withoutPort.String(), withPort.String()}
+}
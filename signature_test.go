@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// signedRequests are requests signed by Twilio or with the same algorithm,
+// recorded in testdata/twilio/signed_requests.json
+type signedRequests struct {
+	Token    string `json:"token"`
+	Requests []struct {
+		Name        string `json:"name"`
+		PublicURL   string `json:"public_url"`
+		Path        string `json:"path"`
+		ContentType string `json:"content_type"`
+		Body        string `json:"body"`
+		Signature   string `json:"signature"`
+		Valid       bool   `json:"valid"`
+	} `json:"requests"`
+}
+
+func loadSignedRequests(t *testing.T) signedRequests {
+	data, err := ioutil.ReadFile("testdata/twilio/signed_requests.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var recorded signedRequests
+	if err := json.Unmarshal(data, &recorded); err != nil {
+		t.Fatal(err)
+	}
+	return recorded
+}
+
+func Test_verifyTwilioRequest(t *testing.T) {
+	recorded := loadSignedRequests(t)
+
+	for _, tt := range recorded.Requests {
+		t.Run(tt.Name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.Path, strings.NewReader(tt.Body))
+			r.Header.Set("Content-Type", tt.ContentType)
+			if tt.Signature != "" {
+				r.Header.Set("X-Twilio-Signature", tt.Signature)
+			}
+
+			err := verifyTwilioRequest(httptest.NewRecorder(), r, recorded.Token, tt.PublicURL)
+			if (err == nil) != tt.Valid {
+				t.Errorf("verifyTwilioRequest() error = %v, valid %v", err, tt.Valid)
+			}
+
+			// The body can still be read by the handler
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil || string(body) != tt.Body {
+				t.Errorf("body after verification = %q, %v, want %q", body, err, tt.Body)
+			}
+		})
+	}
+}
+
+func Test_verifyTwilioRequestWithoutPublicURL(t *testing.T) {
+
+	// Signed for https://impf.example.com:443/api/status
+	recorded := loadSignedRequests(t).Requests[2]
+
+	tests := []struct {
+		name    string
+		target  string
+		header  http.Header
+		wantErr error
+	}{
+		{
+			name:   "Received with TLS",
+			target: "https://impf.example.com/api/status",
+		},
+		{
+			name:   "Received through proxy",
+			target: "http://impf.example.com/api/status",
+			header: http.Header{"X-Forwarded-Proto": {"https"}},
+		},
+		{
+			name:    "Received without TLS",
+			target:  "http://impf.example.com/api/status",
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name:    "Received on other host",
+			target:  "https://localhost:12000/api/status",
+			wantErr: ErrSignatureInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(recorded.Body))
+			r.Header.Set("Content-Type", recorded.ContentType)
+			r.Header.Set("X-Twilio-Signature", recorded.Signature)
+			for k, v := range tt.header {
+				r.Header[k] = v
+			}
+
+			if err := verifyTwilioRequest(httptest.NewRecorder(), r, "12345", ""); !errors.Is(err, tt.wantErr) {
+				t.Errorf("verifyTwilioRequest() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_verifyTwilioRequestTooLarge(t *testing.T) {
+
+	body := "Body=" + strings.Repeat("a", maxAPIBodySize)
+	r := httptest.NewRequest(http.MethodPost, "https://impf.example.com/api/sms", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Twilio-Signature", "invalid")
+
+	err := verifyTwilioRequest(httptest.NewRecorder(), r, "12345", "")
+	if err == nil || errors.Is(err, ErrSignatureInvalid) {
+		t.Errorf("verifyTwilioRequest() error = %v, want error reading the body", err)
+	}
+}
+
+func Test_twilioSignature(t *testing.T) {
+	params := url.Values{
+		"CallSid": {"CA1234567890ABCDE"},
+		"Caller":  {"+12349013030"},
+		"Digits":  {"1234"},
+		"From":    {"+12349013030"},
+		"To":      {"+18005551212"},
+	}
+
+	got := twilioSignature("12345", "https://mycompany.com/myapp.php?foo=1&bar=2", params)
+	if want := "0/KCTR6DLpKmkAf8muzZqo1nDgQ="; got != want {
+		t.Errorf("twilioSignature() = %v, want %v", got, want)
+	}
+}
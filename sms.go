@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf16"
+)
+
+// Encodings of SMS
+const (
+	EncodingGSM7 = "GSM-7"
+	EncodingUCS2 = "UCS-2"
+)
+
+// Characters of the GSM 03.38 alphabet. Texts with other characters are sent
+// as UCS-2, which fits less than half as many characters into a segment.
+const (
+	gsm7Basic    = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+	gsm7Extended = "\f^{}\\[~]|€" // Take two septets each
+)
+
+// Characters per segment. Messages longer than a single segment are split,
+// each part loses some characters to the header joining them.
+const (
+	gsm7Single = 160
+	gsm7Part   = 153
+	ucs2Single = 70
+	ucs2Part   = 67
+)
+
+// smsSegmentBudget is the number of segments an invitation may have before the
+// new-call form warns about it. Set with IMPF_SMS_SEGMENT_BUDGET.
+var smsSegmentBudget = 2
+
+// smsTransliterate replaces characters not in GSM-7 in all messages, see
+// transliterateGSM. Set with IMPF_SMS_TRANSLITERATE.
+var smsTransliterate = false
+
+// SMSInfo describes how a text is sent as SMS
+type SMSInfo struct {
+	Encoding string
+	Length   int    // Septets for GSM-7, UTF-16 code units for UCS-2
+	Segments int    // Number of SMS the provider bills
+	NonGSM   string // Characters which cause UCS-2, each once
+}
+
+// analyzeSMS returns the encoding and number of segments of a text
+func analyzeSMS(text string) SMSInfo {
+
+	info := SMSInfo{Encoding: EncodingGSM7}
+
+	var nonGSM strings.Builder
+	for _, r := range text {
+		switch {
+		case strings.ContainsRune(gsm7Basic, r):
+			info.Length++
+		case strings.ContainsRune(gsm7Extended, r):
+			info.Length += 2
+		default:
+			info.Encoding = EncodingUCS2
+			if !strings.ContainsRune(nonGSM.String(), r) {
+				nonGSM.WriteRune(r)
+			}
+		}
+	}
+	info.NonGSM = nonGSM.String()
+
+	single, part := gsm7Single, gsm7Part
+	if info.Encoding == EncodingUCS2 {
+		info.Length = len(utf16.Encode([]rune(text)))
+		single, part = ucs2Single, ucs2Part
+	}
+
+	switch {
+	case info.Length == 0:
+		info.Segments = 0
+	case info.Length <= single:
+		info.Segments = 1
+	default:
+		info.Segments = (info.Length + part - 1) / part
+	}
+
+	return info
+}
+
+// gsmTransliterations are replacements in GSM-7 for common characters outside
+// of it, e.g. typographic quotes copied from word processors
+var gsmTransliterations = strings.NewReplacer(
+	"“", "\"", "”", "\"", "„", "\"", "‟", "\"", "«", "\"", "»", "\"",
+	"‘", "'", "’", "'", "‚", "'", "‛", "'", "´", "'", "`", "'",
+	"–", "-", "—", "-", "‐", "-", "‑", "-", "−", "-", "•", "-",
+	"…", "...", "×", "x",
+	"\u00a0", " ", "\u2009", " ", "\u202f", " ", "\t", " ",
+	"á", "a", "â", "a", "ã", "a", "ą", "a", "Á", "A", "À", "A", "Â", "A", "Ã", "A",
+	"ç", "c", "ć", "c", "č", "c", "Ć", "C", "Č", "C",
+	"ê", "e", "ë", "e", "ę", "e", "ě", "e", "È", "E", "Ê", "E", "Ë", "E",
+	"í", "i", "î", "i", "ï", "i", "ı", "i", "Í", "I", "Ì", "I", "Î", "I", "İ", "I",
+	"ł", "l", "Ł", "L", "ń", "n", "ň", "n",
+	"ó", "o", "ô", "o", "õ", "o", "Ó", "O", "Ò", "O", "Ô", "O", "Õ", "O",
+	"ř", "r", "ś", "s", "ş", "s", "š", "s", "Ś", "S", "Ş", "S", "Š", "S",
+	"ú", "u", "û", "u", "ů", "u", "Ú", "U", "Ù", "U", "Û", "U",
+	"ý", "y", "ğ", "g", "Ğ", "G", "ž", "z", "ź", "z", "ż", "z", "Ž", "Z",
+)
+
+// transliterateGSM replaces characters outside of GSM-7 by similar ones in it,
+// so the text is not sent as UCS-2. German umlauts are part of GSM-7 and kept.
+// Characters without replacement remain.
+func transliterateGSM(text string) string {
+	return gsmTransliterations.Replace(text)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_analyzeSMS(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want SMSInfo
+	}{
+		{
+			name: "Empty",
+			text: "",
+			want: SMSInfo{Encoding: EncodingGSM7},
+		},
+		{
+			name: "Umlauts are GSM-7",
+			text: "Bestätigt: Plessingstraße, Ärztehaus",
+			want: SMSInfo{Encoding: EncodingGSM7, Length: 36, Segments: 1},
+		},
+		{
+			name: "Single GSM-7 segment",
+			text: strings.Repeat("a", 160),
+			want: SMSInfo{Encoding: EncodingGSM7, Length: 160, Segments: 1},
+		},
+		{
+			name: "Two GSM-7 segments",
+			text: strings.Repeat("a", 161),
+			want: SMSInfo{Encoding: EncodingGSM7, Length: 161, Segments: 2},
+		},
+		{
+			name: "Extended characters take two septets",
+			text: strings.Repeat("€", 80) + "a",
+			want: SMSInfo{Encoding: EncodingGSM7, Length: 161, Segments: 2},
+		},
+		{
+			name: "Three GSM-7 segments",
+			text: strings.Repeat("a", 307),
+			want: SMSInfo{Encoding: EncodingGSM7, Length: 307, Segments: 3},
+		},
+		{
+			name: "Curly quotes are UCS-2",
+			text: "Antworten Sie mit „JA“",
+			want: SMSInfo{Encoding: EncodingUCS2, Length: 22, Segments: 1, NonGSM: "„“"},
+		},
+		{
+			name: "Two UCS-2 segments",
+			text: "–" + strings.Repeat("a", 70),
+			want: SMSInfo{Encoding: EncodingUCS2, Length: 71, Segments: 2, NonGSM: "–"},
+		},
+		{
+			name: "Characters outside the BMP take two units",
+			text: strings.Repeat("😀", 35),
+			want: SMSInfo{Encoding: EncodingUCS2, Length: 70, Segments: 1, NonGSM: "😀"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(tt.want, analyzeSMS(tt.text)); diff != "" {
+				t.Errorf("analyzeSMS() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_transliterateGSM(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"Antworten Sie mit „JA“", `Antworten Sie mit "JA"`},
+		{"Eingang B – 2. OG…", "Eingang B - 2. OG..."},
+		{"Café Müller’s", "Café Müller's"},
+		{"Łódź, Kraków", "Lodz, Krakow"},
+		{"Plessingstraße 20", "Plessingstraße 20"},
+		{"Termin 😀", "Termin 😀"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := transliterateGSM(tt.text); got != tt.want {
+				t.Errorf("transliterateGSM(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			if analyzeSMS(tt.want).Encoding == EncodingGSM7 && analyzeSMS(transliterateGSM(tt.text)).Encoding != EncodingGSM7 {
+				t.Errorf("transliterateGSM(%q) is not GSM-7", tt.text)
+			}
+		})
+	}
+}
+
+func TestBridge_InvitationPreview(t *testing.T) {
+
+	call := Call{
+		TimeStart: time.Now().Add(time.Hour),
+		TimeEnd:   time.Now().Add(2 * time.Hour),
+		LocName:   "Impfzentrum",
+		LocStreet: "Plessingstraße",
+		LocCity:   "Duisburg",
+		LocOpt:    "„Eingang Süd“",
+	}
+
+	body, info, err := bridge.InvitationPreview(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoding != EncodingUCS2 || !strings.Contains(body, "„Eingang Süd“") {
+		t.Errorf("InvitationPreview() = %q, %+v, want UCS-2", body, info)
+	}
+
+	call.Transliterate = true
+	body, info, err = bridge.InvitationPreview(call)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Encoding != EncodingGSM7 || !strings.Contains(body, `"Eingang Süd"`) {
+		t.Errorf("InvitationPreview() with transliteration = %q, %+v, want GSM-7", body, info)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Lists of persons can be uploaded as CSV, Excel (XLSX) or OpenDocument (ODS)
+// file. Spreadsheets are read into the same records as CSV files, one per
+// sheet, so all formats are validated and imported the same way.
+
+// Sheet is a table of an uploaded file. CSV files have a single sheet
+// without name.
+type Sheet struct {
+	Name    string
+	Records [][]string // Rows of the sheet, the index is the line - 1
+}
+
+// ErrUnsupportedFormat is returned for files which are neither CSV nor a
+// supported spreadsheet, e.g. XLS of old Excel versions
+var ErrUnsupportedFormat = errors.New("unsupported file format")
+
+// Namespaces of the XML files of spreadsheets
+const (
+	nsODSOffice = "urn:oasis:names:tc:opendocument:xmlns:office:1.0"
+	nsODSTable  = "urn:oasis:names:tc:opendocument:xmlns:table:1.0"
+	nsODSText   = "urn:oasis:names:tc:opendocument:xmlns:text:1.0"
+)
+
+// Limits for the rows and columns of spreadsheets. Spreadsheets often mark
+// the remaining rows or columns of a sheet as repeated empty cells, which are
+// only added if a filled cell follows. Repeated spaces in the text of a cell
+// are limited as well.
+const (
+	maxSheetRows    = 1 << 20
+	maxSheetColumns = 1 << 14
+	maxCellSpaces   = 1 << 10
+)
+
+// readSheets returns the sheets of an uploaded file. The format is detected
+// from the content, not the name of the file.
+func readSheets(r io.Reader) ([]Sheet, error) {
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		return readZippedSheets(data)
+	case bytes.HasPrefix(data, []byte("\xd0\xcf\x11\xe0")):
+		// Compound file of XLS, which is not supported
+		return nil, ErrUnsupportedFormat
+	}
+
+	records, err := readCSV(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return []Sheet{{Records: records}}, nil
+}
+
+// readZippedSheets reads the sheets of a XLSX or ODS file, which are both ZIP
+// archives of XML files
+func readZippedSheets(data []byte) ([]Sheet, error) {
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+
+	switch {
+	case files["xl/workbook.xml"] != nil:
+		return readXLSX(files)
+	case files["content.xml"] != nil:
+		return readODS(files["content.xml"])
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+}
+
+// openZipped opens a file of an archive, returns an error if it is missing
+func openZipped(files map[string]*zip.File, name string) (io.ReadCloser, error) {
+	f := files[name]
+	if f == nil {
+		return nil, fmt.Errorf("%w: missing %s", ErrUnsupportedFormat, name)
+	}
+	return f.Open()
+}
+
+// decodeZipped decodes a XML file of an archive into v
+func decodeZipped(files map[string]*zip.File, name string, v interface{}) error {
+	rc, err := openZipped(files, name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return xml.NewDecoder(rc).Decode(v)
+}
+
+// xlsxWorkbook lists the sheets of a XLSX file in xl/workbook.xml
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name string `xml:"name,attr"`
+		RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+// xlsxRelationships maps the IDs of the sheets to their files in
+// xl/_rels/workbook.xml.rels
+type xlsxRelationships struct {
+	Relationships []struct {
+		ID     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+// xlsxSharedStrings holds the texts of all cells of a XLSX file, cells of
+// type "s" refer to them by index. Formatted texts are split into runs.
+type xlsxSharedStrings struct {
+	Items []xlsxString `xml:"si"`
+}
+
+// xlsxString is a text of a XLSX file, either plain or in formatted runs
+type xlsxString struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+// String returns the text with all runs
+func (s xlsxString) String() string {
+	text := s.Text
+	for _, r := range s.Runs {
+		text += r.Text
+	}
+	return text
+}
+
+// xlsxRow is a row of a worksheet of a XLSX file. Empty rows and cells may
+// be left out, the references give their position.
+type xlsxRow struct {
+	Ref   int `xml:"r,attr"`
+	Cells []struct {
+		Ref    string     `xml:"r,attr"`
+		Type   string     `xml:"t,attr"`
+		Value  string     `xml:"v"`
+		Inline xlsxString `xml:"is"`
+	} `xml:"c"`
+}
+
+// readXLSX reads the sheets of an Excel file in the order of the workbook
+func readXLSX(files map[string]*zip.File) ([]Sheet, error) {
+
+	var workbook xlsxWorkbook
+	if err := decodeZipped(files, "xl/workbook.xml", &workbook); err != nil {
+		return nil, err
+	}
+
+	var rels xlsxRelationships
+	if err := decodeZipped(files, "xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	targets := map[string]string{}
+	for _, rel := range rels.Relationships {
+		// Targets are relative to xl/ or absolute in the archive
+		if strings.HasPrefix(rel.Target, "/") {
+			targets[rel.ID] = strings.TrimPrefix(rel.Target, "/")
+		} else {
+			targets[rel.ID] = path.Join("xl", rel.Target)
+		}
+	}
+
+	// Files without any text have no shared strings
+	var shared xlsxSharedStrings
+	if files["xl/sharedStrings.xml"] != nil {
+		if err := decodeZipped(files, "xl/sharedStrings.xml", &shared); err != nil {
+			return nil, err
+		}
+	}
+
+	sheets := []Sheet{}
+	for _, s := range workbook.Sheets {
+		records, err := readXLSXSheet(files, targets[s.RID], shared)
+		if err != nil {
+			return nil, err
+		}
+		sheets = append(sheets, Sheet{Name: s.Name, Records: records})
+	}
+
+	return sheets, nil
+}
+
+// readXLSXSheet reads the rows of a worksheet of an Excel file
+func readXLSXSheet(files map[string]*zip.File, name string, shared xlsxSharedStrings) ([][]string, error) {
+
+	rc, err := openZipped(files, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	records := [][]string{}
+	decoder := xml.NewDecoder(rc)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xlsxRow
+		if err := decoder.DecodeElement(&row, &start); err != nil {
+			return nil, err
+		}
+
+		line := row.Ref
+		if line <= len(records) {
+			line = len(records) + 1
+		}
+		if line > maxSheetRows {
+			return nil, fmt.Errorf("sheet %s has more than %d rows", name, maxSheetRows)
+		}
+
+		var record []string
+		for _, c := range row.Cells {
+
+			column := len(record)
+			if c.Ref != "" {
+				column = cellColumn(c.Ref)
+			}
+			if column < len(record) || column >= maxSheetColumns {
+				continue
+			}
+
+			var value string
+			switch c.Type {
+			case "s":
+				if c.Value == "" {
+					break
+				}
+				i, err := strconv.Atoi(c.Value)
+				if err != nil || i < 0 || i >= len(shared.Items) {
+					return nil, fmt.Errorf("invalid shared string %q in %s", c.Value, c.Ref)
+				}
+				value = shared.Items[i].String()
+			case "inlineStr":
+				value = c.Inline.String()
+			case "n", "":
+				value = formatNumber(c.Value)
+			default:
+				value = c.Value
+			}
+
+			if value == "" {
+				continue
+			}
+			for len(record) < column {
+				record = append(record, "")
+			}
+			record = append(record, value)
+		}
+
+		if len(record) == 0 {
+			continue
+		}
+		for len(records) < line-1 {
+			records = append(records, nil)
+		}
+		records = append(records, record)
+	}
+}
+
+// cellColumn returns the column of a cell reference like "B12", counted from
+// 0
+func cellColumn(ref string) int {
+	column := 0
+	for _, r := range ref {
+		if r < 'A' || r > 'Z' {
+			break
+		}
+		column = column*26 + int(r-'A') + 1
+	}
+	return column - 1
+}
+
+// formatNumber formats a number of a spreadsheet without exponent and
+// fractional part if it is an integer, as phone numbers are often saved as
+// numbers, e.g. 4.915112345678E+12
+func formatNumber(value string) string {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) >= 1e16 {
+		return value
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// readODS reads the sheets of an OpenDocument spreadsheet from its content.xml
+func readODS(content *zip.File) ([]Sheet, error) {
+
+	rc, err := content.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	sheets := []Sheet{}
+	decoder := xml.NewDecoder(rc)
+
+	// Row being read with its repetitions and the repeated empty rows and
+	// cells before it, which are only added if something follows
+	var record []string
+	var rowRepeat, emptyRows, emptyCells int
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return sheets, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space != nsODSTable {
+				continue
+			}
+
+			switch t.Name.Local {
+			case "table":
+				sheets = append(sheets, Sheet{Name: odsAttr(t, nsODSTable, "name"), Records: [][]string{}})
+				emptyRows = 0
+
+			case "table-row":
+				record, emptyCells = nil, 0
+				rowRepeat = odsRepeat(t, "number-rows-repeated")
+
+			case "table-cell", "covered-table-cell":
+				if len(sheets) == 0 {
+					continue
+				}
+
+				repeat := odsRepeat(t, "number-columns-repeated")
+				value, err := odsCellValue(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				if value == "" {
+					emptyCells += repeat
+					continue
+				}
+
+				for ; emptyCells > 0 && len(record) < maxSheetColumns; emptyCells-- {
+					record = append(record, "")
+				}
+				for k := 0; k < repeat && len(record) < maxSheetColumns; k++ {
+					record = append(record, value)
+				}
+				emptyCells = 0
+			}
+
+		case xml.EndElement:
+			if t.Name.Space != nsODSTable || t.Name.Local != "table-row" || len(sheets) == 0 {
+				continue
+			}
+
+			sheet := &sheets[len(sheets)-1]
+			if len(record) == 0 {
+				emptyRows += rowRepeat
+				continue
+			}
+
+			if len(sheet.Records)+emptyRows+rowRepeat > maxSheetRows {
+				return nil, fmt.Errorf("sheet %s has more than %d rows", sheet.Name, maxSheetRows)
+			}
+			for ; emptyRows > 0; emptyRows-- {
+				sheet.Records = append(sheet.Records, nil)
+			}
+			for k := 0; k < rowRepeat; k++ {
+				sheet.Records = append(sheet.Records, record)
+			}
+		}
+	}
+}
+
+// odsAttr returns the value of an attribute of an element of an OpenDocument
+// file
+func odsAttr(e xml.StartElement, space, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// odsRepeat returns how often a row or cell is repeated, at least once
+func odsRepeat(e xml.StartElement, attr string) int {
+	n, err := strconv.Atoi(odsAttr(e, nsODSTable, attr))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// odsCellValue reads a cell of an OpenDocument spreadsheet. Numbers are taken
+// from the value of the cell, as the displayed text may be formatted, other
+// cells from their text. Paragraphs are separated by line breaks.
+func odsCellValue(decoder *xml.Decoder, start xml.StartElement) (string, error) {
+
+	var text strings.Builder
+	paragraphs := 0
+
+	for depth := 1; depth > 0; {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if t.Name.Space != nsODSText {
+				continue
+			}
+			switch t.Name.Local {
+			case "p":
+				if paragraphs > 0 {
+					text.WriteString("\n")
+				}
+				paragraphs++
+			case "s":
+				n, err := strconv.Atoi(odsAttr(t, nsODSText, "c"))
+				if err != nil || n < 1 {
+					n = 1
+				}
+				if n > maxCellSpaces {
+					n = maxCellSpaces
+				}
+				text.WriteString(strings.Repeat(" ", n))
+			case "tab":
+				text.WriteString("\t")
+			case "line-break":
+				text.WriteString("\n")
+			}
+		case xml.EndElement:
+			depth--
+		case xml.CharData:
+			text.Write(t)
+		}
+	}
+
+	switch odsAttr(start, nsODSOffice, "value-type") {
+	case "float", "percentage", "currency":
+		if value := odsAttr(start, nsODSOffice, "value"); value != "" {
+			return formatNumber(value), nil
+		}
+	}
+
+	return strings.TrimSpace(text.String()), nil
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func Test_readSheets(t *testing.T) {
+	tests := []struct {
+		path string
+		want []Sheet
+	}{
+		{
+			path: "tests/import_example.xlsx",
+			want: []Sheet{
+				{
+					Name: "Personen",
+					Records: [][]string{
+						{"Rufnummer", "Impfgruppe", "Sprache"},
+						{"4915100000001", "2", "English"},
+						{"4915100000002", "1"},
+						nil,
+						{"0151 00000003 ", "", "tr"},
+						{"015100000004", "3"},
+					},
+				},
+				{
+					Name:    "Nachzügler",
+					Records: [][]string{{"4915100000005", "4"}},
+				},
+			},
+		},
+		{
+			path: "tests/import_example.ods",
+			want: []Sheet{
+				{
+					Name:    "Hinweise",
+					Records: [][]string{},
+				},
+				{
+					Name: "Personen",
+					Records: [][]string{
+						{"Handy", "Gruppe", "Sprache"},
+						{"4915100000001", "2", "English"},
+						nil,
+						nil,
+						{"0151 00000003", "", "tr"},
+						{"1", "1"},
+					},
+				},
+			},
+		},
+		{
+			path: "tests/import_example.csv",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			f, err := os.Open(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+
+			got, err := readSheets(f)
+			if err != nil {
+				t.Fatalf("readSheets() error = %v", err)
+			}
+
+			// CSV files have a single sheet without name
+			if tt.want == nil {
+				if len(got) != 1 || got[0].Name != "" || len(got[0].Records) != 14 {
+					t.Errorf("readSheets() = %v, want one sheet with 14 records", got)
+				}
+				return
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("readSheets() mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func Test_readSheets_Unsupported(t *testing.T) {
+
+	// A ZIP archive which is no spreadsheet
+	var archive bytes.Buffer
+	writer := zip.NewWriter(&archive)
+	if _, err := writer.Create("readme.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		wantErr error
+	}{
+		{"XLS", "\xd0\xcf\x11\xe0\xa1\xb1\x1a\xe1", ErrUnsupportedFormat},
+		{"Other ZIP", archive.String(), ErrUnsupportedFormat},
+		{"Invalid ZIP", "PK\x03\x04broken", zip.ErrFormat},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := readSheets(strings.NewReader(tt.data)); !errors.Is(err, tt.wantErr) {
+				t.Errorf("readSheets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_odsCellValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs string
+		cell  string
+		want  string
+	}{
+		{"Text", "", `<text:p>0151 00000001</text:p>`, "0151 00000001"},
+		{"Spaces", "", `<text:p>0151<text:s text:c="3"/>00000001</text:p>`, "0151   00000001"},
+		{"Too many spaces", "", `<text:p>a<text:s text:c="1000000000"/>b</text:p>`, "a" + strings.Repeat(" ", maxCellSpaces) + "b"},
+		{"Paragraphs", "", `<text:p>a</text:p><text:p>b<text:tab/>c</text:p>`, "a\nb\tc"},
+		{"Number", ` office:value-type="float" office:value="1500000000000"`, `<text:p>1,5E+12</text:p>`, "1500000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decoder := xml.NewDecoder(strings.NewReader(`<table:table-cell xmlns:table="` + nsODSTable +
+				`" xmlns:text="` + nsODSText + `" xmlns:office="` + nsODSOffice + `"` + tt.attrs + `>` +
+				tt.cell + `</table:table-cell>`))
+
+			token, err := decoder.Token()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := odsCellValue(decoder, token.(xml.StartElement))
+			if err != nil {
+				t.Fatalf("odsCellValue() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("odsCellValue() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatNumber(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"4915100000001", "4915100000001"},
+		{"4.9151000000020E+12", "4915100000002"},
+		{"3", "3"},
+		{"2.0", "2"},
+		{"2.5", "2.5"},
+		{"abc", "abc"},
+	}
+	for _, tt := range tests {
+		if got := formatNumber(tt.value); got != tt.want {
+			t.Errorf("formatNumber(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func Test_cellColumn(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+	}{
+		{"A1", 0},
+		{"C12", 2},
+		{"Z3", 25},
+		{"AA7", 26},
+		{"XFD1048576", 16383},
+	}
+	for _, tt := range tests {
+		if got := cellColumn(tt.ref); got != tt.want {
+			t.Errorf("cellColumn(%q) = %d, want %d", tt.ref, got, tt.want)
+		}
+	}
+}
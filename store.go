@@ -0,0 +1,109 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Store abstracts the database backend of the bridge. All queries the bridge
+// issues go through this interface, so the application can run on different
+// databases. Implementations exist for SQLite (default) and PostgreSQL, the
+// backend is selected with IMPF_DB_DRIVER.
+type Store interface {
+
+	// Calls
+	AddCall(call Call) error
+	GetCall(id int) (Call, error)
+	GetActiveCalls(now time.Time) ([]Call, error)
+	DeleteCallsEndedBefore(t time.Time) (int64, error)
+
+	// Persons
+	AddPerson(person Person) error
+	AddPersons(persons []Person, mode ImportMode) (ImportSummary, error)
+	GetPerson(phone string) (Person, error)
+	GetPersons() ([]Person, error)
+	DeletePerson(phone string) (int64, error)
+	SetPersonLanguage(phone, language string) (int64, error)
+	GetNextPersonsForCall(num, callID int) ([]Person, error)
+	GetAcceptedPersons(callID int) ([]Person, error)
+	GetUndeliverablePersons(threshold int) ([]Person, error)
+
+	// Invitations
+	AddInvitations(callID, wave int, messages []OutboxMessage, now, expiresAt time.Time) error
+	NextWave(callID int) (int, error)
+	CountOpenInvitations(callID int, now time.Time) (int, error)
+	ExpireInvitations(now time.Time) ([]Invitation, error)
+	NextInvitationExpiry() (sql.NullTime, error)
+	CountAcceptedInvitations(callID int) (int, error)
+	OpenInvitation(phone string, statuses []InvitationStatus, now time.Time) (Invitation, error)
+	AcceptInvitation(phone, actor string, now time.Time) (Invitation, error)
+	CancelInvitation(phone, actor string, now time.Time) (Invitation, error)
+	GetInvitationsByPhone(phone string) ([]Invitation, error)
+	GetInvitationEvents(invitationID int) ([]InvitationEvent, error)
+	TransitionInvitation(id int, to InvitationStatus, actor string, t time.Time) error
+
+	// Outbox
+	QueueMessage(msg OutboxMessage, now time.Time) error
+	PendingMessages(limit int, now time.Time) ([]OutboxMessage, error)
+	NextMessageAttempt() (sql.NullTime, error)
+	MarkMessageSent(id int, providerID string, t time.Time) error
+	UpdateDeliveryStatus(providerID, status, deliveryError string, now time.Time) (OutboxMessage, error)
+	MarkMessageRetry(id int, lastError string, next time.Time) error
+	MarkMessageFailed(id int, lastError string) error
+	RequeueMessage(id int) error
+	GetMessages(limit int) ([]OutboxMessage, error)
+
+	// Inbound messages
+	AddInboundMessage(msg InboundMessage) (int, error)
+	SetInboundResult(id int, result string) error
+	GetInboundMessages(limit int) ([]InboundMessage, error)
+
+	// Imports
+	AddImport(imp Import, data []byte) (int, error)
+	GetImport(id int) (Import, error)
+	GetImports(limit int) ([]Import, error)
+	GetImportFile(id int) ([]byte, error)
+	GetImportRows(id, sheet int) ([]ImportRow, error)
+	SaveImportRows(imp Import, rows []ImportRow) error
+	UpdateImport(imp Import, rows []ImportRow) error
+	ConfirmImport(id int, mode ImportMode) error
+	NextImport() (Import, error)
+	StartImport(id, total int) error
+	ImportBatch(id int, persons []Person, onboarding []OutboxMessage, suppressed int, mode ImportMode, now time.Time) (ImportSummary, error)
+	FinishImport(id int, from, to ImportStatus, message string, now time.Time) error
+	CancelImport(id int) error
+	ExistingPhones(phones []string) ([]string, error)
+
+	// Suppressions
+	AddSuppression(hash string, now time.Time) error
+	GetSuppression(hash string) (Suppression, error)
+	SuppressedHashes(hashes []string) ([]string, error)
+	LiftSuppression(hash, user string, now time.Time) error
+	InitSuppressionSalt(salt string) (string, error)
+
+	// Message templates
+	GetMessageTemplates() ([]MessageTemplate, error)
+	SaveMessageTemplate(t MessageTemplate) error
+	DeleteMessageTemplate(centerID int, language, name string) error
+
+	// Users
+	GetUser(username string) (ImpfUser, error)
+
+	// Close closes the underlying database connection
+	Close() error
+}
+
+// NewStore opens a store for the given driver and data source name. Supported
+// drivers are "sqlite3" and "postgres". The schema is migrated to the latest
+// version before the store is returned.
+func NewStore(driver, dsn string) (Store, error) {
+	switch driver {
+	case "sqlite3", "sqlite", "":
+		return newSQLiteStore(dsn)
+	case "postgres", "postgresql":
+		return newPostgresStore(dsn)
+	default:
+		return nil, fmt.Errorf("unsupported database driver: %s", driver)
+	}
+}
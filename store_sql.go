@@ -0,0 +1,1193 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	log "github.com/sirupsen/logrus"
+)
+
+// sqlStore implements the queries shared by all SQL backends. Queries are
+// written to be valid for both SQLite and PostgreSQL: positional parameters
+// use the $N syntax, named parameters are rebound by sqlx according to the
+// driver and string literals are always single-quoted.
+type sqlStore struct {
+	db *sqlx.DB
+}
+
+// rollback aborts a transaction after a failed statement. The error of the
+// statement is the interesting one, so rollback errors are only logged
+func rollback(tx *sqlx.Tx) {
+	if err := tx.Rollback(); err != nil {
+		log.Error(err)
+	}
+}
+
+// Close closes the database connection
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
+
+// AddCall inserts a new call
+func (s *sqlStore) AddCall(call Call) error {
+	_, err := s.db.NamedExec(
+		`INSERT INTO calls (
+			title,
+			center_id,
+			capacity,
+			time_start,
+			time_end,
+			young_only,
+			loc_name,
+			loc_street,
+			loc_housenr,
+			loc_plz,
+			loc_city,
+			loc_opt,
+			response_window,
+			overbooking,
+			max_outstanding,
+			wave_spacing,
+			transliterate
+		) VALUES (
+			:title,
+			:center_id,
+			:capacity,
+			:time_start,
+			:time_end,
+			:young_only,
+			:loc_name,
+			:loc_street,
+			:loc_housenr,
+			:loc_plz,
+			:loc_city,
+			:loc_opt,
+			:response_window,
+			:overbooking,
+			:max_outstanding,
+			:wave_spacing,
+			:transliterate
+		)`, &call)
+	return err
+}
+
+// GetCall returns the call with the given ID
+func (s *sqlStore) GetCall(id int) (Call, error) {
+	call := Call{}
+	err := s.db.Get(&call, "SELECT * FROM calls WHERE id=$1", id)
+	return call, err
+}
+
+// GetActiveCalls returns all calls which have not ended at the given time
+func (s *sqlStore) GetActiveCalls(now time.Time) ([]Call, error) {
+	calls := []Call{}
+	err := s.db.Select(&calls, "SELECT * FROM calls WHERE time_end > $1 ORDER BY time_start, id", now)
+	return calls, err
+}
+
+// DeleteCallsEndedBefore deletes all calls that ended before t and returns the
+// number of deleted calls
+func (s *sqlStore) DeleteCallsEndedBefore(t time.Time) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM calls WHERE time_end < $1", t)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// AddPerson inserts a single person
+func (s *sqlStore) AddPerson(person Person) error {
+	_, err := s.db.NamedExec(
+		"INSERT INTO persons (center_id, group_num, phone, status, language) VALUES "+
+			"(:center_id, :group_num, :phone, :status, :language)", &person)
+	return err
+}
+
+// AddPersons inserts multiple persons in a single transaction. Persons whose
+// phone number exists already are handled according to mode. If adding one of
+// them fails, none are added.
+func (s *sqlStore) AddPersons(persons []Person, mode ImportMode) (ImportSummary, error) {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return ImportSummary{}, err
+	}
+
+	summary, _, err := upsertPersons(tx, persons, mode)
+	if err != nil {
+		rollback(tx)
+		return ImportSummary{}, err
+	}
+
+	return summary, tx.Commit()
+}
+
+// upsertPersons adds persons in a transaction. Existing persons are skipped,
+// updated or make it fail with ErrPersonExists according to mode. Returns the
+// indices of the persons which have been inserted.
+func upsertPersons(tx *sqlx.Tx, persons []Person, mode ImportMode) (ImportSummary, []int, error) {
+
+	var summary ImportSummary
+	inserted := []int{}
+
+	for k := range persons {
+
+		// Only the group and center are updated, the language may have been
+		// chosen by the person
+		if mode == ImportUpdate {
+			result, err := tx.Exec("UPDATE persons SET group_num=$1, center_id=$2 WHERE phone=$3",
+				persons[k].Group, persons[k].CenterID, persons[k].Phone)
+			if err != nil {
+				return summary, nil, err
+			}
+			if n, err := result.RowsAffected(); err != nil {
+				return summary, nil, err
+			} else if n > 0 {
+				summary.Updated++
+				continue
+			}
+		}
+
+		result, err := tx.NamedExec(
+			"INSERT INTO persons (center_id, group_num, phone, status, language) VALUES "+
+				"(:center_id, :group_num, :phone, :status, :language) ON CONFLICT (phone) DO NOTHING", &persons[k])
+		if err != nil {
+			return summary, nil, err
+		}
+
+		n, err := result.RowsAffected()
+		switch {
+		case err != nil:
+			return summary, nil, err
+		case n > 0:
+			summary.Inserted++
+			inserted = append(inserted, k)
+		case mode == ImportSkip:
+			summary.Skipped++
+		default:
+			return summary, nil, &PersonExistsError{Phone: persons[k].Phone}
+		}
+	}
+
+	return summary, inserted, nil
+}
+
+// GetPerson returns the person with the given phone number. Returns
+// sql.ErrNoRows if there is none.
+func (s *sqlStore) GetPerson(phone string) (Person, error) {
+	var person Person
+	err := s.db.Get(&person, "SELECT * FROM persons WHERE phone=$1", phone)
+	return person, err
+}
+
+// GetPersons returns all persons
+func (s *sqlStore) GetPersons() ([]Person, error) {
+	persons := []Person{}
+	err := s.db.Select(&persons, "SELECT * FROM persons ORDER BY phone")
+	return persons, err
+}
+
+// DeletePerson deletes the person with the given phone number and returns the
+// number of deleted rows
+func (s *sqlStore) DeletePerson(phone string) (int64, error) {
+	result, err := s.db.Exec("DELETE FROM persons WHERE phone=$1", phone)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// SetPersonLanguage sets the language of the messages to the person with the
+// given phone number and returns the number of persons changed
+func (s *sqlStore) SetPersonLanguage(phone, language string) (int64, error) {
+	result, err := s.db.Exec("UPDATE persons SET language=$1 WHERE phone=$2", language, phone)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetNextPersonsForCall returns up to num persons which have not been invited
+// to the call yet and have no open or accepted invitation for any other call.
+// Lower groups are selected first.
+func (s *sqlStore) GetNextPersonsForCall(num, callID int) ([]Person, error) {
+	persons := []Person{}
+	err := s.db.Select(&persons,
+		`SELECT * FROM persons
+			WHERE phone NOT IN (
+				SELECT phone FROM invitations
+					WHERE call_id = $1
+					OR status IN ('accepted', 'notified')
+				)
+			ORDER BY group_num, phone LIMIT $2`,
+		callID, num)
+	return persons, err
+}
+
+// GetAcceptedPersons returns all persons that have accepted the call
+func (s *sqlStore) GetAcceptedPersons(callID int) ([]Person, error) {
+	persons := []Person{}
+	err := s.db.Select(&persons,
+		`SELECT persons.* FROM persons
+		JOIN invitations ON
+		persons.phone = invitations.phone
+		AND invitations.status = 'accepted'
+		AND invitations.call_id = $1
+		ORDER BY persons.phone`, callID)
+	return persons, err
+}
+
+// CountAcceptedInvitations returns the number of accepted invitations for a
+// call
+func (s *sqlStore) CountAcceptedInvitations(callID int) (int, error) {
+	var num int
+	err := s.db.Get(&num, "SELECT COUNT(id) FROM invitations WHERE call_id=$1 AND status='accepted'", callID)
+	return num, err
+}
+
+// OpenInvitation returns the most recent invitation of a phone number that
+// has one of the given statuses and belongs to a call which has not ended yet.
+// Invitations without reply past their deadline are ignored, even if they have
+// not been marked as expired yet. Returns ErrNoOpenInvitation if there is none.
+func (s *sqlStore) OpenInvitation(phone string, statuses []InvitationStatus, now time.Time) (Invitation, error) {
+	return openInvitation(s.db, phone, statuses, now)
+}
+
+func openInvitation(q sqlx.Ext, phone string, statuses []InvitationStatus, now time.Time) (Invitation, error) {
+
+	invitation := Invitation{}
+
+	query, args, err := sqlx.In(
+		`SELECT invitations.* FROM invitations
+		JOIN calls ON calls.id = invitations.call_id
+		WHERE invitations.phone = ?
+		AND invitations.status IN (?)
+		AND (invitations.status != 'notified' OR invitations.expires_at > ?)
+		AND calls.time_end > ?
+		ORDER BY invitations.time DESC, invitations.id DESC LIMIT 1`,
+		phone, statuses, now, now)
+	if err != nil {
+		return invitation, err
+	}
+
+	err = sqlx.Get(q, &invitation, q.Rebind(query), args...)
+	if errors.Is(err, sql.ErrNoRows) {
+		return invitation, ErrNoOpenInvitation
+	}
+
+	return invitation, err
+}
+
+// AcceptInvitation accepts the open invitation of a phone number, if the call
+// still has a seat left. If the call is full, the invitation is rejected and
+// ErrCallFull is returned. Lookup, seat reservation and update are done in a
+// single transaction.
+func (s *sqlStore) AcceptInvitation(phone, actor string, now time.Time) (Invitation, error) {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	invitation, err := openInvitation(tx, phone, []InvitationStatus{InvitationNotified}, now)
+	if err != nil {
+		rollback(tx)
+		return invitation, err
+	}
+
+	// Try to take a seat. If there is none left, reject the invitation
+	// instead, so the person is not notified again for this call
+	status, retErr := InvitationAccepted, error(nil)
+	err = transitionInvitation(tx, invitation.ID, status, actor, now)
+	if errors.Is(err, ErrCallFull) {
+		status, retErr = InvitationRejected, ErrCallFull
+		err = transitionInvitation(tx, invitation.ID, status, actor, now)
+	}
+
+	if err != nil {
+		rollback(tx)
+		return invitation, err
+	}
+
+	invitation.Status = status
+	if err := tx.Commit(); err != nil {
+		return invitation, err
+	}
+
+	return invitation, retErr
+}
+
+// CancelInvitation cancels the open invitation of a phone number. Accepted
+// invitations are cancelled, invitations without reply are declined.
+func (s *sqlStore) CancelInvitation(phone, actor string, now time.Time) (Invitation, error) {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return Invitation{}, err
+	}
+
+	invitation, err := openInvitation(tx, phone,
+		[]InvitationStatus{InvitationNotified, InvitationAccepted}, now)
+	if err != nil {
+		rollback(tx)
+		return invitation, err
+	}
+
+	status := InvitationDeclined
+	if invitation.Status == InvitationAccepted {
+		status = InvitationCancelled
+	}
+
+	if err := transitionInvitation(tx, invitation.ID, status, actor, now); err != nil {
+		rollback(tx)
+		return invitation, err
+	}
+
+	invitation.Status = status
+	return invitation, tx.Commit()
+}
+
+// AddInvitations records that persons have been invited to a call and queues
+// the invitation messages in the outbox. There is one invitation for each
+// message, created for the phone number the message is addressed to. All
+// invitations belong to the same wave and expire at expiresAt.
+func (s *sqlStore) AddInvitations(callID, wave int, messages []OutboxMessage, now, expiresAt time.Time) error {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	for _, msg := range messages {
+
+		id, err := insertInvitation(tx, callID, wave, msg.Phone, now, expiresAt)
+		if err != nil {
+			rollback(tx)
+			return err
+		}
+
+		msg.InvitationID = sql.NullInt64{Int64: int64(id), Valid: true}
+		if err := insertOutboxMessage(tx, msg, now); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+
+	// Remember when the wave was sent, so the next one can be spaced out
+	if _, err := tx.Exec("UPDATE calls SET last_wave_at=$1 WHERE id=$2", now, callID); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// insertInvitation adds a new invitation with status notified and records its
+// creation in the invitation history. Returns the ID of the new invitation.
+func insertInvitation(tx *sqlx.Tx, callID, wave int, phone string, now, expiresAt time.Time) (int, error) {
+
+	var id int
+	if err := tx.Get(&id,
+		`INSERT INTO invitations (phone, call_id, status, time, wave, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`,
+		phone, callID, InvitationNotified, now, wave, expiresAt); err != nil {
+		return id, err
+	}
+
+	return id, insertInvitationEvent(tx, id, "", InvitationNotified, actorSystem, now)
+}
+
+// NextWave returns the number the next wave of invitations for a call gets
+func (s *sqlStore) NextWave(callID int) (int, error) {
+	var wave int
+	err := s.db.Get(&wave, "SELECT COALESCE(MAX(wave), 0) + 1 FROM invitations WHERE call_id=$1", callID)
+	return wave, err
+}
+
+// CountOpenInvitations returns the number of invitations for a call which
+// have not been replied to and have not expired at now
+func (s *sqlStore) CountOpenInvitations(callID int, now time.Time) (int, error) {
+	var num int
+	err := s.db.Get(&num,
+		"SELECT COUNT(id) FROM invitations WHERE call_id=$1 AND status='notified' AND expires_at > $2",
+		callID, now)
+	return num, err
+}
+
+// NextInvitationExpiry returns the earliest deadline of all invitations
+// without reply. The returned time is not valid if there are none.
+func (s *sqlStore) NextInvitationExpiry() (sql.NullTime, error) {
+	var expiry sql.NullTime
+	err := s.db.Get(&expiry,
+		"SELECT expires_at FROM invitations WHERE status='notified' ORDER BY expires_at LIMIT 1")
+	if errors.Is(err, sql.ErrNoRows) {
+		return expiry, nil
+	}
+	return expiry, err
+}
+
+// ExpireInvitations marks all invitations without reply which are past their
+// deadline as expired. Returns the expired invitations.
+func (s *sqlStore) ExpireInvitations(now time.Time) ([]Invitation, error) {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+
+	invitations := []Invitation{}
+	if err := tx.Select(&invitations,
+		"SELECT * FROM invitations WHERE status='notified' AND expires_at <= $1 ORDER BY id",
+		now); err != nil {
+		rollback(tx)
+		return nil, err
+	}
+
+	for k := range invitations {
+		if err := transitionInvitation(tx, invitations[k].ID, InvitationExpired, actorSystem, now); err != nil {
+			rollback(tx)
+			return nil, err
+		}
+		invitations[k].Status = InvitationExpired
+	}
+
+	return invitations, tx.Commit()
+}
+
+// insertOutboxMessage queues a message for delivery, inside a transaction or
+// directly on the database
+func insertOutboxMessage(db sqlx.Execer, msg OutboxMessage, now time.Time) error {
+	_, err := db.Exec(
+		`INSERT INTO outbox (phone, body, invitation_id, status, created_at)
+		VALUES ($1, $2, $3, $4, $5)`,
+		msg.Phone, msg.Body, msg.InvitationID, MessagePending, now)
+	return err
+}
+
+// QueueMessage adds a message to the outbox
+func (s *sqlStore) QueueMessage(msg OutboxMessage, now time.Time) error {
+	return insertOutboxMessage(s.db, msg, now)
+}
+
+// PendingMessages returns up to limit messages waiting for delivery, which
+// are due at now. Oldest first.
+func (s *sqlStore) PendingMessages(limit int, now time.Time) ([]OutboxMessage, error) {
+	messages := []OutboxMessage{}
+	err := s.db.Select(&messages,
+		`SELECT * FROM outbox WHERE status=$1 AND (next_attempt_at IS NULL OR next_attempt_at <= $2)
+		ORDER BY id LIMIT $3`, MessagePending, now, limit)
+	return messages, err
+}
+
+// NextMessageAttempt returns when the earliest retry of a message is due. The
+// returned time is not valid if no message is waiting for a retry.
+func (s *sqlStore) NextMessageAttempt() (sql.NullTime, error) {
+	var next sql.NullTime
+	err := s.db.Get(&next,
+		`SELECT next_attempt_at FROM outbox WHERE status=$1 AND next_attempt_at IS NOT NULL
+		ORDER BY next_attempt_at LIMIT 1`, MessagePending)
+	if errors.Is(err, sql.ErrNoRows) {
+		return next, nil
+	}
+	return next, err
+}
+
+// MarkMessageSent marks a message of the outbox as handed to the provider,
+// which assigned providerID to it
+func (s *sqlStore) MarkMessageSent(id int, providerID string, t time.Time) error {
+	_, err := s.db.Exec(
+		`UPDATE outbox SET status=$1, sent_at=$2, attempts=attempts+1, next_attempt_at=NULL, provider_id=$3
+		WHERE id=$4`,
+		MessageSent, t, providerID, id)
+	return err
+}
+
+// UpdateDeliveryStatus records a delivery receipt for the message with the
+// given provider ID. Receipts which arrive after a later status are ignored.
+// If the message could not be delivered, the undelivered counter of the person
+// is increased and an invitation waiting for a reply to the message expires,
+// so its seat is offered to the next person. A delivered message resets the
+// counter. Returns the message after the update or ErrMessageNotFound.
+func (s *sqlStore) UpdateDeliveryStatus(providerID, status, deliveryError string, now time.Time) (OutboxMessage, error) {
+
+	var msg OutboxMessage
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return msg, err
+	}
+
+	if err := tx.Get(&msg,
+		"SELECT * FROM outbox WHERE provider_id=$1 AND provider_id != '' ORDER BY id DESC LIMIT 1",
+		providerID); errors.Is(err, sql.ErrNoRows) {
+		rollback(tx)
+		return msg, ErrMessageNotFound
+	} else if err != nil {
+		rollback(tx)
+		return msg, err
+	}
+
+	if !deliveryStatusReplaces(msg.DeliveryStatus, status) {
+		log.Debugf("Ignoring status %s of message %d, already %s", status, msg.ID, msg.DeliveryStatus)
+		return msg, tx.Commit()
+	}
+
+	msg.DeliveryStatus = status
+	msg.DeliveryError = deliveryError
+	msg.DeliveryUpdatedAt = sql.NullTime{Time: now, Valid: true}
+
+	if _, err := tx.Exec(
+		"UPDATE outbox SET delivery_status=$1, delivery_error=$2, delivery_updated_at=$3 WHERE id=$4",
+		status, deliveryError, now, msg.ID); err != nil {
+		rollback(tx)
+		return msg, err
+	}
+
+	switch {
+	case Undeliverable(status):
+		if _, err := tx.Exec("UPDATE persons SET undelivered=undelivered+1 WHERE phone=$1", msg.Phone); err != nil {
+			rollback(tx)
+			return msg, err
+		}
+
+		if msg.InvitationID.Valid {
+			var invitationStatus InvitationStatus
+			if err := tx.Get(&invitationStatus,
+				"SELECT status FROM invitations WHERE id=$1", msg.InvitationID.Int64); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				rollback(tx)
+				return msg, err
+			}
+
+			if invitationStatus == InvitationNotified {
+				if err := transitionInvitation(tx, int(msg.InvitationID.Int64), InvitationExpired, actorProvider, now); err != nil {
+					rollback(tx)
+					return msg, err
+				}
+			}
+		}
+
+	case status == DeliveryDelivered:
+		if _, err := tx.Exec("UPDATE persons SET undelivered=0 WHERE phone=$1", msg.Phone); err != nil {
+			rollback(tx)
+			return msg, err
+		}
+	}
+
+	return msg, tx.Commit()
+}
+
+// GetUndeliverablePersons returns the persons whose last threshold or more
+// messages could not be delivered
+func (s *sqlStore) GetUndeliverablePersons(threshold int) ([]Person, error) {
+	persons := []Person{}
+	err := s.db.Select(&persons,
+		"SELECT * FROM persons WHERE undelivered >= $1 ORDER BY undelivered DESC, phone", threshold)
+	return persons, err
+}
+
+// MarkMessageRetry records a failed delivery attempt. The message stays
+// pending and is sent again at next.
+func (s *sqlStore) MarkMessageRetry(id int, lastError string, next time.Time) error {
+	_, err := s.db.Exec(
+		"UPDATE outbox SET attempts=attempts+1, last_error=$1, next_attempt_at=$2 WHERE id=$3",
+		lastError, next, id)
+	return err
+}
+
+// MarkMessageFailed records a failed delivery attempt and gives up on the
+// message
+func (s *sqlStore) MarkMessageFailed(id int, lastError string) error {
+	_, err := s.db.Exec(
+		"UPDATE outbox SET status=$1, attempts=attempts+1, last_error=$2, next_attempt_at=NULL WHERE id=$3",
+		MessageFailed, lastError, id)
+	return err
+}
+
+// RequeueMessage queues a failed message for delivery again, starting over
+// with the attempts. Returns ErrMessageNotFailed if there is no failed message
+// with the ID.
+func (s *sqlStore) RequeueMessage(id int) error {
+	res, err := s.db.Exec(
+		"UPDATE outbox SET status=$1, attempts=0, next_attempt_at=NULL WHERE id=$2 AND status=$3",
+		MessagePending, id, MessageFailed)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != 1 {
+		return ErrMessageNotFailed
+	}
+	return nil
+}
+
+// GetMessages returns the latest limit messages of the outbox, newest first
+func (s *sqlStore) GetMessages(limit int) ([]OutboxMessage, error) {
+	messages := []OutboxMessage{}
+	err := s.db.Select(&messages, "SELECT * FROM outbox ORDER BY id DESC LIMIT $1", limit)
+	return messages, err
+}
+
+// AddInboundMessage logs a message received from a person. Returns the ID
+// of the new entry.
+func (s *sqlStore) AddInboundMessage(msg InboundMessage) (int, error) {
+	var id int
+	err := s.db.Get(&id,
+		`INSERT INTO inbound_messages (phone, body, action, result, received_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id`,
+		msg.Phone, msg.Body, msg.Action, msg.Result, msg.ReceivedAt)
+	return id, err
+}
+
+// SetInboundResult records the result of handling an inbound message
+func (s *sqlStore) SetInboundResult(id int, result string) error {
+	_, err := s.db.Exec("UPDATE inbound_messages SET result=$1 WHERE id=$2", result, id)
+	return err
+}
+
+// GetInboundMessages returns the latest limit inbound messages, newest first
+func (s *sqlStore) GetInboundMessages(limit int) ([]InboundMessage, error) {
+	messages := []InboundMessage{}
+	err := s.db.Select(&messages, "SELECT * FROM inbound_messages ORDER BY id DESC LIMIT $1", limit)
+	return messages, err
+}
+
+// GetMessageTemplates returns all message templates saved in the database
+func (s *sqlStore) GetMessageTemplates() ([]MessageTemplate, error) {
+	templates := []MessageTemplate{}
+	err := s.db.Select(&templates, "SELECT * FROM message_templates ORDER BY center_id, language, name")
+	return templates, err
+}
+
+// SaveMessageTemplate adds or replaces the message template of a center in a
+// language
+func (s *sqlStore) SaveMessageTemplate(t MessageTemplate) error {
+	_, err := s.db.Exec(
+		`INSERT INTO message_templates (center_id, language, name, body, updated_at, updated_by)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (center_id, language, name) DO UPDATE
+		SET body=excluded.body, updated_at=excluded.updated_at, updated_by=excluded.updated_by`,
+		t.CenterID, t.Language, t.Name, t.Body, t.UpdatedAt, t.UpdatedBy)
+	return err
+}
+
+// DeleteMessageTemplate deletes the message template of a center in a
+// language
+func (s *sqlStore) DeleteMessageTemplate(centerID int, language, name string) error {
+	_, err := s.db.Exec("DELETE FROM message_templates WHERE center_id=$1 AND language=$2 AND name=$3", centerID, language, name)
+	return err
+}
+
+// GetInvitationsByPhone returns all invitations of a phone number, latest
+// first
+func (s *sqlStore) GetInvitationsByPhone(phone string) ([]Invitation, error) {
+	invitations := []Invitation{}
+	err := s.db.Select(&invitations,
+		"SELECT * FROM invitations WHERE phone=$1 ORDER BY time DESC, id DESC", phone)
+	return invitations, err
+}
+
+// GetInvitationEvents returns the status history of an invitation, oldest
+// first
+func (s *sqlStore) GetInvitationEvents(invitationID int) ([]InvitationEvent, error) {
+	events := []InvitationEvent{}
+	err := s.db.Select(&events,
+		"SELECT * FROM invitation_events WHERE invitation_id=$1 ORDER BY time, id", invitationID)
+	return events, err
+}
+
+// TransitionInvitation changes the status of an invitation and records the
+// change in the invitation history
+func (s *sqlStore) TransitionInvitation(id int, to InvitationStatus, actor string, t time.Time) error {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	if err := transitionInvitation(tx, id, to, actor, t); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// transitionInvitation performs a guarded status change of an invitation
+// inside of tx. The update only succeeds if the status has not been changed
+// since it was read, so concurrent transitions can't both succeed.
+//
+// Accepting an invitation takes a seat of the call, cancelling it frees the
+// seat again. If no seat is left, ErrCallFull is returned.
+func transitionInvitation(tx *sqlx.Tx, id int, to InvitationStatus, actor string, t time.Time) error {
+
+	invitation := Invitation{}
+	if err := tx.Get(&invitation, "SELECT * FROM invitations WHERE id=$1", id); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvitationNotFound
+		}
+		return err
+	}
+
+	from := invitation.Status
+	if !from.CanTransition(to) {
+		return transitionError(id, from, to)
+	}
+
+	switch {
+	case to == InvitationAccepted:
+		if err := reserveSeat(tx, invitation.CallID); err != nil {
+			return err
+		}
+	case from == InvitationAccepted && to == InvitationCancelled:
+		if err := releaseSeat(tx, invitation.CallID); err != nil {
+			return err
+		}
+	}
+
+	result, err := tx.Exec(
+		"UPDATE invitations SET status=$1, time=$2 WHERE id=$3 AND status=$4",
+		to, t, id, from)
+	if err != nil {
+		return err
+	}
+
+	if numrows, err := result.RowsAffected(); err != nil {
+		return err
+	} else if numrows != 1 {
+		return transitionError(id, from, to)
+	}
+
+	return insertInvitationEvent(tx, id, from, to, actor, t)
+}
+
+// reserveSeat takes a seat of a call. The check for free seats and the
+// increment are a single statement, which makes it a compare-and-set: of two
+// concurrent reservations for the last seat, only one can succeed. Returns
+// ErrCallFull if no seat is left.
+func reserveSeat(tx *sqlx.Tx, callID int) error {
+
+	result, err := tx.Exec(
+		"UPDATE calls SET seats_taken = seats_taken + 1 WHERE id=$1 AND seats_taken < capacity",
+		callID)
+	if err != nil {
+		return err
+	}
+
+	numrows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if numrows != 1 {
+		return ErrCallFull
+	}
+
+	return nil
+}
+
+// releaseSeat frees a seat of a call taken by reserveSeat
+func releaseSeat(tx *sqlx.Tx, callID int) error {
+	_, err := tx.Exec(
+		"UPDATE calls SET seats_taken = seats_taken - 1 WHERE id=$1 AND seats_taken > 0",
+		callID)
+	return err
+}
+
+// insertInvitationEvent adds an entry to the history of an invitation
+func insertInvitationEvent(tx *sqlx.Tx, id int, from, to InvitationStatus, actor string, t time.Time) error {
+	_, err := tx.Exec(
+		`INSERT INTO invitation_events (invitation_id, from_status, to_status, actor, time)
+		VALUES ($1, $2, $3, $4, $5)`,
+		id, from, to, actor, t)
+	return err
+}
+
+// GetUser returns the user with the given username. Returns sql.ErrNoRows if
+// the user does not exist
+func (s *sqlStore) GetUser(username string) (ImpfUser, error) {
+	user := ImpfUser{}
+	err := s.db.Get(&user, "SELECT * FROM users WHERE username=$1", username)
+	return user, err
+}
+
+// AddImport saves an uploaded file as new import, which the worker reads into
+// rows. Returns the ID of the new import.
+func (s *sqlStore) AddImport(imp Import, data []byte) (int, error) {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	if err := tx.Get(&id,
+		`INSERT INTO imports (filename, header, phone_column, group_column, language_column, created_at, created_by, center_id, sheets, sheet, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`,
+		imp.Filename, imp.Header, imp.Phone, imp.Group, imp.Language, imp.CreatedAt, imp.CreatedBy, imp.CenterID, imp.Sheets, imp.Sheet, imp.Status); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO import_files (import_id, data) VALUES ($1, $2)", id, data); err != nil {
+		rollback(tx)
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+// GetImport returns an import. Returns sql.ErrNoRows if there is none.
+func (s *sqlStore) GetImport(id int) (Import, error) {
+	var imp Import
+	err := s.db.Get(&imp, "SELECT * FROM imports WHERE id=$1", id)
+	return imp, err
+}
+
+// GetImports returns the last limit imports, newest first
+func (s *sqlStore) GetImports(limit int) ([]Import, error) {
+	imports := []Import{}
+	err := s.db.Select(&imports, "SELECT * FROM imports ORDER BY id DESC LIMIT $1", limit)
+	return imports, err
+}
+
+// GetImportFile returns the uploaded file of an import which has not been
+// read yet. Returns sql.ErrNoRows if there is none.
+func (s *sqlStore) GetImportFile(id int) ([]byte, error) {
+	var data []byte
+	err := s.db.Get(&data, "SELECT data FROM import_files WHERE import_id=$1", id)
+	return data, err
+}
+
+// GetImportRows returns all rows of a sheet of an import in the order of the
+// file
+func (s *sqlStore) GetImportRows(id, sheet int) ([]ImportRow, error) {
+	rows := []ImportRow{}
+	err := s.db.Select(&rows, "SELECT * FROM import_rows WHERE import_id=$1 AND sheet=$2 ORDER BY line", id, sheet)
+	return rows, err
+}
+
+// SaveImportRows saves the rows read from the file of an import together with
+// its sheets and mapping of the columns. The import is ready then and the file
+// is deleted. If reading the file failed, imp has the status ImportFailed and
+// the error. Returns ErrImportCancelled if the import has been cancelled
+// meanwhile.
+func (s *sqlStore) SaveImportRows(imp Import, rows []ImportRow) error {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		`UPDATE imports SET header=$1, phone_column=$2, group_column=$3, language_column=$4, sheets=$5, sheet=$6, status=$7, error=$8
+		WHERE id=$9 AND status=$10`,
+		imp.Header, imp.Phone, imp.Group, imp.Language, imp.Sheets, imp.Sheet, imp.Status, imp.Error, imp.ID, ImportUploaded)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		rollback(tx)
+		if err == nil {
+			err = ErrImportCancelled
+		}
+		return err
+	}
+
+	for _, row := range rows {
+		row.ImportID = imp.ID
+		if _, err := tx.NamedExec(
+			`INSERT INTO import_rows (import_id, sheet, line, record, phone, group_num, language, error)
+			VALUES (:import_id, :sheet, :line, :record, :phone, :group_num, :language, :error)`, &row); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM import_files WHERE import_id=$1", imp.ID); err != nil {
+		rollback(tx)
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateImport saves a changed sheet or mapping of the columns of an import
+// together with the rows validated again. Returns ErrImportDone if the import
+// is not ready, e.g. because it has been confirmed.
+func (s *sqlStore) UpdateImport(imp Import, rows []ImportRow) error {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(
+		`UPDATE imports SET header=$1, phone_column=$2, group_column=$3, language_column=$4, sheet=$5
+		WHERE id=$6 AND status=$7`,
+		imp.Header, imp.Phone, imp.Group, imp.Language, imp.Sheet, imp.ID, ImportReady)
+	if err != nil {
+		rollback(tx)
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		rollback(tx)
+		if err == nil {
+			err = ErrImportDone
+		}
+		return err
+	}
+
+	for _, row := range rows {
+		if _, err := tx.NamedExec(
+			`UPDATE import_rows SET phone=:phone, group_num=:group_num, language=:language, error=:error
+			WHERE import_id=:import_id AND sheet=:sheet AND line=:line`, &row); err != nil {
+			rollback(tx)
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ConfirmImport queues an import for the worker, which adds its persons
+// according to mode. The progress of a previous run is reset. Returns
+// ErrImportDone if the import can't be confirmed, see
+// ImportPreview.Confirmable.
+func (s *sqlStore) ConfirmImport(id int, mode ImportMode) error {
+
+	result, err := s.db.Exec(
+		`UPDATE imports SET status=$1, mode=$2, error='', total=0, processed=0, queued=0,
+		imported=0, inserted=0, updated=0, skipped=0, suppressed=0, imported_at=NULL
+		WHERE id=$3 AND (status=$4 OR status IN ($5, $6) AND EXISTS (SELECT 1 FROM import_rows WHERE import_id=$3))`,
+		ImportQueued, mode, id, ImportReady, ImportFailed, ImportCancelled)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrImportDone
+		}
+		return err
+	}
+	return nil
+}
+
+// NextImport returns the oldest import the worker has to process. Imports
+// which are running already have been interrupted and are continued. Returns
+// sql.ErrNoRows if there is none.
+func (s *sqlStore) NextImport() (Import, error) {
+	var imp Import
+	err := s.db.Get(&imp, "SELECT * FROM imports WHERE status IN ($1, $2, $3) ORDER BY id LIMIT 1",
+		ImportUploaded, ImportQueued, ImportRunning)
+	return imp, err
+}
+
+// StartImport marks a queued import as running with the total number of
+// persons to import. Returns ErrImportCancelled if the import has been
+// cancelled meanwhile.
+func (s *sqlStore) StartImport(id, total int) error {
+
+	result, err := s.db.Exec("UPDATE imports SET status=$1, total=$2 WHERE id=$3 AND status=$4",
+		ImportRunning, total, id, ImportQueued)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrImportCancelled
+		}
+		return err
+	}
+	return nil
+}
+
+// ImportBatch adds a batch of persons of a running import according to mode
+// and queues the onboarding message of each new person, onboarding[k] being
+// the one of persons[k]. Messages without body are not queued. suppressed is
+// the number of persons of the batch left out because they are suppressed.
+// The progress of the import is saved together with the persons, so a batch
+// is either imported and counted or not at all. Returns ErrImportCancelled if
+// the import has been cancelled meanwhile.
+func (s *sqlStore) ImportBatch(id int, persons []Person, onboarding []OutboxMessage, suppressed int, mode ImportMode, now time.Time) (ImportSummary, error) {
+
+	tx, err := s.db.Beginx()
+	if err != nil {
+		return ImportSummary{}, err
+	}
+
+	summary, inserted, err := upsertPersons(tx, persons, mode)
+	if err != nil {
+		rollback(tx)
+		return ImportSummary{}, err
+	}
+	summary.Suppressed = suppressed
+
+	queued := 0
+	for _, k := range inserted {
+		if k >= len(onboarding) || onboarding[k].Body == "" {
+			continue
+		}
+		if err := insertOutboxMessage(tx, onboarding[k], now); err != nil {
+			rollback(tx)
+			return ImportSummary{}, err
+		}
+		queued++
+	}
+
+	result, err := tx.Exec(
+		`UPDATE imports SET processed=processed+$1, queued=queued+$2, imported=imported+$3,
+		inserted=inserted+$4, updated=updated+$5, skipped=skipped+$6, suppressed=suppressed+$7
+		WHERE id=$8 AND status=$9`,
+		len(persons)+suppressed, queued, summary.Inserted+summary.Updated,
+		summary.Inserted, summary.Updated, summary.Skipped, suppressed, id, ImportRunning)
+	if err != nil {
+		rollback(tx)
+		return ImportSummary{}, err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		rollback(tx)
+		if err == nil {
+			err = ErrImportCancelled
+		}
+		return ImportSummary{}, err
+	}
+
+	return summary, tx.Commit()
+}
+
+// FinishImport changes the status of an import from from to to, with the
+// error message of a failed import. Completed imports get the time now.
+// Returns ErrImportCancelled if the import is not in status from anymore.
+func (s *sqlStore) FinishImport(id int, from, to ImportStatus, message string, now time.Time) error {
+
+	var importedAt sql.NullTime
+	if to == ImportDone {
+		importedAt = sql.NullTime{Time: now, Valid: true}
+	}
+
+	result, err := s.db.Exec("UPDATE imports SET status=$1, error=$2, imported_at=$3 WHERE id=$4 AND status=$5",
+		to, message, importedAt, id, from)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrImportCancelled
+		}
+		return err
+	}
+	return nil
+}
+
+// CancelImport stops an import the worker has not finished yet. Persons of
+// batches imported already are kept. Returns ErrImportDone if the import is
+// not active anymore.
+func (s *sqlStore) CancelImport(id int) error {
+
+	result, err := s.db.Exec("UPDATE imports SET status=$1 WHERE id=$2 AND status IN ($3, $4, $5)",
+		ImportCancelled, id, ImportUploaded, ImportQueued, ImportRunning)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = ErrImportDone
+		}
+		return err
+	}
+
+	// The file of an import cancelled before it has been read is not needed
+	// anymore
+	_, err = s.db.Exec("DELETE FROM import_files WHERE import_id=$1", id)
+	return err
+}
+
+// ExistingPhones returns the phone numbers of phones which belong to a person
+// already
+func (s *sqlStore) ExistingPhones(phones []string) ([]string, error) {
+
+	existing := []string{}
+	if len(phones) == 0 {
+		return existing, nil
+	}
+
+	query, args, err := sqlx.In("SELECT phone FROM persons WHERE phone IN (?) ORDER BY phone", phones)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Select(&existing, s.db.Rebind(query), args...)
+	return existing, err
+}
+
+// AddSuppression adds the hash of a phone number to the suppression list. A
+// suppression which has been lifted is active again.
+func (s *sqlStore) AddSuppression(hash string, now time.Time) error {
+	_, err := s.db.Exec(
+		`INSERT INTO suppressions (hash, created_at) VALUES ($1, $2)
+		ON CONFLICT (hash) DO UPDATE SET created_at=excluded.created_at, lifted_at=NULL, lifted_by=''`,
+		hash, now)
+	return err
+}
+
+// GetSuppression returns the suppression of a hash, also if it has been
+// lifted. Returns sql.ErrNoRows if there is none.
+func (s *sqlStore) GetSuppression(hash string) (Suppression, error) {
+	var suppression Suppression
+	err := s.db.Get(&suppression, "SELECT * FROM suppressions WHERE hash=$1", hash)
+	return suppression, err
+}
+
+// SuppressedHashes returns those of hashes which have an active suppression
+func (s *sqlStore) SuppressedHashes(hashes []string) ([]string, error) {
+
+	suppressed := []string{}
+	if len(hashes) == 0 {
+		return suppressed, nil
+	}
+
+	query, args, err := sqlx.In("SELECT hash FROM suppressions WHERE hash IN (?) AND lifted_at IS NULL", hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Select(&suppressed, s.db.Rebind(query), args...)
+	return suppressed, err
+}
+
+// LiftSuppression lifts the active suppression of a hash and records the user
+// who lifted it. Returns sql.ErrNoRows if there is no active suppression.
+func (s *sqlStore) LiftSuppression(hash, user string, now time.Time) error {
+
+	result, err := s.db.Exec("UPDATE suppressions SET lifted_at=$1, lifted_by=$2 WHERE hash=$3 AND lifted_at IS NULL",
+		now, user, hash)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = sql.ErrNoRows
+		}
+		return err
+	}
+	return nil
+}
+
+// InitSuppressionSalt saves salt as salt of the suppression list, unless a salt
+// has been saved before. Returns the saved salt.
+func (s *sqlStore) InitSuppressionSalt(salt string) (string, error) {
+
+	if _, err := s.db.Exec("INSERT INTO settings (name, value) VALUES ($1, $2) ON CONFLICT (name) DO NOTHING",
+		settingSuppressionSalt, salt); err != nil {
+		return "", err
+	}
+
+	var saved string
+	err := s.db.Get(&saved, "SELECT value FROM settings WHERE name=$1", settingSuppressionSalt)
+	return saved, err
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteStore is the SQLite backend of the Store. It is the default and needs
+// no setup besides a writable path for the database file.
+type sqliteStore struct {
+	sqlStore
+}
+
+// newSQLiteStore opens the database file at path. It will be created if it
+// does not already exist.
+func newSQLiteStore(path string) (*sqliteStore, error) {
+
+	db, err := sqlx.Connect("sqlite3", path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite only allows a single writer. Limiting the pool to one
+	// connection avoids "database is locked" errors
+	db.SetMaxOpenConns(1)
+
+	if err := migrateDB(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &sqliteStore{sqlStore{db: db}}, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+// Suppression keeps persons who asked to be deleted from being added again,
+// e.g. by the next import of the same list. Only a hash of the phone number is
+// stored, see suppressionHash. An admin can lift a suppression, e.g. when the
+// person registers again.
+type Suppression struct {
+	Hash      string       `db:"hash"`
+	CreatedAt time.Time    `db:"created_at"`
+	LiftedAt  sql.NullTime `db:"lifted_at"` // Not valid while the suppression is active
+	LiftedBy  string       `db:"lifted_by"` // User who lifted the suppression
+}
+
+// Active returns true if the suppression has not been lifted
+func (s Suppression) Active() bool {
+	return !s.LiftedAt.Valid
+}
+
+// ErrPersonSuppressed is returned when a person is added who asked to be
+// deleted
+var ErrPersonSuppressed = errors.New("person is suppressed")
+
+// settingSuppressionSalt is the name of the setting holding the salt of the
+// suppression list
+const settingSuppressionSalt = "suppression_salt"
+
+// ErrSuppressionSaltChanged is returned when IMPF_SUPPRESSION_SALT differs
+// from the salt the suppression list was created with
+var ErrSuppressionSaltChanged = errors.New("IMPF_SUPPRESSION_SALT differs from the salt of the suppression list")
+
+// loadSuppressionSalt returns the salt of the suppression list. The salt is
+// saved in the database on the first start, as the stored hashes can't be
+// found anymore with another salt. It is the configured one or, if none is
+// configured, a random one. Returns ErrSuppressionSaltChanged if the
+// configured salt differs from the saved one.
+func loadSuppressionSalt(store Store, configured string) (string, error) {
+
+	salt := configured
+	if salt == "" {
+		random := make([]byte, 32)
+		if _, err := rand.Read(random); err != nil {
+			return "", err
+		}
+		salt = hex.EncodeToString(random)
+	}
+
+	saved, err := store.InitSuppressionSalt(salt)
+	if err != nil {
+		return "", err
+	}
+
+	if configured != "" && saved != configured {
+		return "", ErrSuppressionSaltChanged
+	}
+	return saved, nil
+}
+
+// suppressionHash returns the hash of a phone number stored in the suppression
+// list. The number is normalized to E.164 first, so all spellings of a number
+// have the same hash. The hash is salted with suppressionSalt, so the numbers
+// can't be found by hashing all possible numbers without knowing it.
+func suppressionHash(phone string) string {
+
+	if normalized, err := normalizePhone(phone); err == nil {
+		phone = normalized
+	}
+
+	mac := hmac.New(sha256.New, []byte(suppressionSalt))
+	mac.Write([]byte(phone))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func Test_suppressionHash(t *testing.T) {
+
+	defer func(salt string) { suppressionSalt = salt }(suppressionSalt)
+	suppressionSalt = "salt"
+
+	hash := suppressionHash("+4915100000001")
+	if len(hash) != 64 {
+		t.Errorf("suppressionHash() = %q, want SHA-256 in hex", hash)
+	}
+
+	// All spellings of a number have the same hash
+	for _, phone := range []string{"015100000001", "0151 000 000 01", "+49 151 00000001"} {
+		if got := suppressionHash(phone); got != hash {
+			t.Errorf("suppressionHash(%q) = %q, want %q", phone, got, hash)
+		}
+	}
+
+	if got := suppressionHash("+4915100000002"); got == hash {
+		t.Error("suppressionHash() of another number is the same")
+	}
+
+	suppressionSalt = "other"
+	if got := suppressionHash("+4915100000001"); got == hash {
+		t.Error("suppressionHash() with another salt is the same")
+	}
+}
+
+func Test_loadSuppressionSalt(t *testing.T) {
+	forEachBackend(t, func(t *testing.T) {
+
+		if _, err := testDB(bridge.store).Exec("DELETE FROM settings"); err != nil {
+			t.Fatal(err)
+		}
+
+		// A random salt is saved on the first start without salt
+		generated, err := loadSuppressionSalt(bridge.store, "")
+		if err != nil || len(generated) != 64 {
+			t.Fatalf("loadSuppressionSalt() = %q, %v, want random salt", generated, err)
+		}
+
+		tests := []struct {
+			name       string
+			configured string
+			want       string
+			wantErr    error
+		}{
+			{"Saved salt", "", generated, nil},
+			{"Same salt configured", generated, generated, nil},
+			{"Other salt configured", "other", "", ErrSuppressionSaltChanged},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				got, err := loadSuppressionSalt(bridge.store, tt.configured)
+				if !errors.Is(err, tt.wantErr) {
+					t.Errorf("loadSuppressionSalt() error = %v, wantErr %v", err, tt.wantErr)
+				}
+				if got != tt.want {
+					t.Errorf("loadSuppressionSalt() = %q, want %q", got, tt.want)
+				}
+			})
+		}
+
+		// A configured salt is saved on the first start
+		if _, err := testDB(bridge.store).Exec("DELETE FROM settings"); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := loadSuppressionSalt(bridge.store, "configured"); err != nil || got != "configured" {
+			t.Errorf("loadSuppressionSalt() = %q, %v, want configured salt", got, err)
+		}
+	})
+}
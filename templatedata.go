@@ -6,6 +6,9 @@ package main
 // might occurr
 type TmplData struct {
 	CurrentUser           string
+	Lang                  string // Language of the web UI, see T
+	Language              string // Language of the message templates shown
+	Languages             []string
 	DefaultCapacity       string
 	DefaultEndHour        string
 	DefaultEndMinute      string
@@ -17,9 +20,32 @@ type TmplData struct {
 	DefaultStartHour      string
 	DefaultStartMinute    string
 	DefaultTitle          string
+	DefaultResponseWindow string
+	DefaultOverbooking    string
+	DefaultMaxOutstanding string
+	DefaultWaveSpacing    string
+	DefaultLocationOpt    string
+	DefaultStartTime      string
+	DefaultEndTime        string
+	DefaultTransliterate  bool
 	AppMessages           []string
 	AppMessageSuccess     string
 	Calls                 []Call
 	CallStatus            CallStatus
 	Persons               []Person
+	Messages              []OutboxMessage
+	Center                int
+	MessageTemplates      []MessageTemplateView
+	Placeholders          []string
+	Import                ImportPreview
+	Imports               []Import
+	Refresh               int // Seconds after which the page reloads, 0 for never
+	Phone                 string
+	Suppression           *Suppression // Suppression of Phone, nil if never suppressed
+
+	// Invitation of a new call exceeding smsSegmentBudget
+	InvitationPreview  string
+	InvitationInfo     SMSInfo
+	TransliteratedInfo SMSInfo
+	SegmentBudget      int
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	// Zone data for systems without it, e.g. minimal containers
+	_ "time/tzdata"
+)
+
+// Times of calls are shown to persons in the zone of the vaccination centers,
+// independent of the zone of the server.
+
+// callZone is the zone times in messages are formatted in
+var callZone = mustLoadLocation("Europe/Berlin")
+
+// dateFormat holds how days are written in a language
+type dateFormat struct {
+	date     string // Layout of dates for time.Format
+	today    string
+	tomorrow string
+	day      string // Other days, fmt format with the weekday and the date
+	weekdays [7]string
+}
+
+// dateFormats are the date formats of the languages, see languages
+var dateFormats = map[string]dateFormat{
+	"de": {
+		date: "02.01.2006", today: "heute", tomorrow: "morgen", day: "am %s, %s",
+		weekdays: [...]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+	},
+	"en": {
+		date: "02/01/2006", today: "today", tomorrow: "tomorrow", day: "on %s, %s",
+		weekdays: [...]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"},
+	},
+	"tr": {
+		date: "02.01.2006", today: "bugün", tomorrow: "yarın", day: "%[2]s %[1]s günü",
+		weekdays: [...]string{"Pazar", "Pazartesi", "Salı", "Çarşamba", "Perşembe", "Cuma", "Cumartesi"},
+	},
+	"ar": {
+		date: "02/01/2006", today: "اليوم", tomorrow: "غدًا", day: "يوم %s، %s",
+		weekdays: [...]string{"الأحد", "الاثنين", "الثلاثاء", "الأربعاء", "الخميس", "الجمعة", "السبت"},
+	},
+}
+
+func mustLoadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		panic(err)
+	}
+	return loc
+}
+
+// dateFormatOf returns the date format of a language, the one of the default
+// language for unknown languages
+func dateFormatOf(lang string) dateFormat {
+	if f, ok := dateFormats[lang]; ok {
+		return f
+	}
+	return dateFormats[defaultLanguage]
+}
+
+// formatTime returns the time of day, e.g. "14:05"
+func formatTime(t time.Time) string {
+	return t.In(callZone).Format("15:04")
+}
+
+// formatDate returns the date in a language, e.g. "10.02.2021"
+func formatDate(t time.Time, lang string) string {
+	return t.In(callZone).Format(dateFormatOf(lang).date)
+}
+
+// formatWeekday returns the name of the weekday in a language, e.g. "Mittwoch"
+func formatWeekday(t time.Time, lang string) string {
+	return dateFormatOf(lang).weekdays[t.In(callZone).Weekday()]
+}
+
+// formatDay returns the day of t as said in a sentence in a language, relative
+// to now: "heute", "morgen" or e.g. "am Mittwoch, 10.02.2021"
+func formatDay(t, now time.Time, lang string) string {
+
+	f := dateFormatOf(lang)
+	t, now = t.In(callZone), now.In(callZone)
+
+	y, m, d := now.Date()
+	today := time.Date(y, m, d, 0, 0, 0, 0, callZone)
+
+	switch day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, callZone); {
+	case day.Equal(today):
+		return f.today
+	case day.Equal(today.AddDate(0, 0, 1)):
+		return f.tomorrow
+	default:
+		return fmt.Sprintf(f.day, formatWeekday(t, lang), formatDate(t, lang))
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_formatDay(t *testing.T) {
+
+	// 21:00 in Berlin
+	now := time.Date(2021, 1, 1, 20, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		t    time.Time
+		lang string
+		want string
+	}{
+		{"Later today", time.Date(2021, 1, 1, 22, 30, 0, 0, time.UTC), "de", "heute"},
+		{"Tomorrow in UTC, today in Berlin", time.Date(2021, 1, 1, 22, 59, 0, 0, time.UTC), "de", "heute"},
+		{"Midnight in Berlin", time.Date(2021, 1, 1, 23, 0, 0, 0, time.UTC), "de", "morgen"},
+		{"Tomorrow", time.Date(2021, 1, 2, 9, 30, 0, 0, time.UTC), "de", "morgen"},
+		{"Day after tomorrow", time.Date(2021, 1, 3, 9, 30, 0, 0, time.UTC), "de", "am Sonntag, 03.01.2021"},
+		{"Next month", time.Date(2021, 2, 10, 11, 30, 0, 0, time.UTC), "de", "am Mittwoch, 10.02.2021"},
+		{"English today", time.Date(2021, 1, 1, 22, 30, 0, 0, time.UTC), "en", "today"},
+		{"English", time.Date(2021, 2, 10, 11, 30, 0, 0, time.UTC), "en", "on Wednesday, 10/02/2021"},
+		{"Turkish tomorrow", time.Date(2021, 1, 2, 9, 30, 0, 0, time.UTC), "tr", "yarın"},
+		{"Turkish", time.Date(2021, 2, 10, 11, 30, 0, 0, time.UTC), "tr", "10.02.2021 Çarşamba günü"},
+		{"Arabic", time.Date(2021, 2, 10, 11, 30, 0, 0, time.UTC), "ar", "يوم الأربعاء، 10/02/2021"},
+		{"Unknown language", time.Date(2021, 2, 10, 11, 30, 0, 0, time.UTC), "xx", "am Mittwoch, 10.02.2021"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatDay(tt.t, now, tt.lang); got != tt.want {
+				t.Errorf("formatDay(%v, %s) = %q, want %q", tt.t, tt.lang, got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_formatTime(t *testing.T) {
+	tests := []struct {
+		name string
+		t    time.Time
+		want string
+	}{
+		{"Winter time", time.Date(2021, 2, 10, 13, 5, 0, 0, time.UTC), "14:05"},
+		{"Summer time", time.Date(2021, 6, 10, 13, 5, 0, 0, time.UTC), "15:05"},
+		{"Other zone", time.Date(2021, 6, 10, 9, 5, 0, 0, time.FixedZone("EDT", -4*3600)), "15:05"},
+		{"Midnight", time.Date(2021, 6, 9, 22, 0, 0, 0, time.UTC), "00:00"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatTime(tt.t); got != tt.want {
+				t.Errorf("formatTime(%v) = %q, want %q", tt.t, got, tt.want)
+			}
+		})
+	}
+}